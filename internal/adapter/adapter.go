@@ -1,10 +1,55 @@
 package adapter
 
+import (
+	"encoding/base64"
+	"regexp"
+)
+
 type AdapterResponse struct {
 	Content          string
 	ReasoningContent string
 	ToolCalls        string
 	Done             bool
+	ConversationID   string
+	Parts            []Part
+}
+
+// Output part types
+const (
+	PartText            = "text"
+	PartImage           = "image"
+	PartFile            = "file"
+	PartCitation        = "citation"
+	PartCode            = "code"
+	PartExecutionResult = "execution_result"
+)
+
+// Part is a typed output part of a response, in the order the website returned it.
+// Images and files carry their data, or a URL the page can download. Citations refer to the
+// Content text between StartIndex and EndIndex.
+type Part struct {
+	Type       string
+	Text       string
+	URL        string
+	Data       []byte
+	MimeType   string
+	Filename   string
+	Title      string
+	StartIndex int
+	EndIndex   int
+	Language   string
+	Outcome    string
+}
+
+// PartsOfType returns the parts of a type
+func (r *AdapterResponse) PartsOfType(partType string) []Part {
+	parts := make([]Part, 0)
+	for _, part := range r.Parts {
+		if part.Type == partType {
+			parts = append(parts, part)
+		}
+	}
+	return parts
 }
 
 var Adapters = map[string]Adapter{}
@@ -12,3 +57,30 @@ var Adapters = map[string]Adapter{}
 type Adapter interface {
 	HandleResponse(responseBuffer []byte, done bool) (*AdapterResponse, error)
 }
+
+// appendTextPart adds text to the last part when it is a text part, otherwise as a new text part
+func appendTextPart(parts []Part, text string) []Part {
+	if text == "" {
+		return parts
+	}
+	if len(parts) > 0 && parts[len(parts)-1].Type == PartText {
+		parts[len(parts)-1].Text = parts[len(parts)-1].Text + text
+		return parts
+	}
+	return append(parts, Part{Type: PartText, Text: text})
+}
+
+var inlineImageRegexp = regexp.MustCompile(`"(image/(?:png|jpeg|webp|gif))"\s*,\s*"([A-Za-z0-9+/]{64,}={0,2})"`)
+
+// inlineImages returns the base64 images sent next to their MIME type in a response, like the inline data of Gemini
+func inlineImages(responseBuffer []byte) []Part {
+	images := make([]Part, 0)
+	for _, match := range inlineImageRegexp.FindAllSubmatch(responseBuffer, -1) {
+		data, err := base64.StdEncoding.DecodeString(string(match[2]))
+		if err != nil {
+			continue
+		}
+		images = append(images, Part{Type: PartImage, Data: data, MimeType: string(match[1])})
+	}
+	return images
+}
@@ -14,6 +14,11 @@ func init() {
 type ChatGPTAdapter struct {
 }
 
+var chatGPTConversationIDRegexp = regexp.MustCompile(`"conversation_id"\s*:\s*"([^"]+)"`)
+
+// chatGPTAssetPointerRegexp finds the files of the generated images, downloaded through the files API of the site
+var chatGPTAssetPointerRegexp = regexp.MustCompile(`"asset_pointer"\s*:\s*"file-service://([^"]+)"`)
+
 func (g *ChatGPTAdapter) HandleResponse(responseBuffer []byte, done bool) (*AdapterResponse, error) {
 	content := ""
 	reasoningContent := ""
@@ -24,15 +29,30 @@ func (g *ChatGPTAdapter) HandleResponse(responseBuffer []byte, done bool) (*Adap
 		return nil, fmt.Errorf("no match data")
 	}
 	thinkStatus := false
+	parts := make([]Part, 0)
+	seen := make(map[string]bool)
+	// streamPart is the code or execution output part also receiving the text appended to the current message
+	streamPart := -1
 	for i := 0; i < len(matches); i++ {
 		match := matches[i]
 		if len(match) == 2 {
+			if gjson.Get(match[1], "o").String() == "add" {
+				parts, streamPart = g.addMessagePart(parts, gjson.Get(match[1], "v.message.content"))
+			}
+			parts = g.appendReferences(parts, match[1], seen)
+
 			c, d := g.getDataContent(match[1], &thinkStatus)
 			if !d {
 				if thinkStatus {
 					reasoningContent = reasoningContent + c
 				} else {
+					// The code and its output stay in the content too, next to their typed part
 					content = content + c
+					if streamPart >= 0 {
+						parts[streamPart].Text = parts[streamPart].Text + c
+					} else {
+						parts = appendTextPart(parts, c)
+					}
 				}
 			} else {
 				done = true
@@ -41,15 +61,87 @@ func (g *ChatGPTAdapter) HandleResponse(responseBuffer []byte, done bool) (*Adap
 		}
 	}
 
+	conversationID := ""
+	if conversationIDMatch := chatGPTConversationIDRegexp.FindSubmatch(responseBuffer); conversationIDMatch != nil {
+		conversationID = string(conversationIDMatch[1])
+	}
+
 	return &AdapterResponse{
 		Content:          content,
 		ReasoningContent: reasoningContent,
 		ToolCalls:        "",
 		Done:             done,
+		ConversationID:   conversationID,
+		Parts:            parts,
 	}, nil
 
 }
 
+// addMessagePart starts the part of a new message. The code run by the code interpreter and its output
+// are parts of their own, it returns the index of such a part, or -1 for a text message.
+func (g *ChatGPTAdapter) addMessagePart(parts []Part, messageContent gjson.Result) ([]Part, int) {
+	switch messageContent.Get("content_type").String() {
+	case "code":
+		parts = append(parts, Part{
+			Type:     PartCode,
+			Language: messageContent.Get("language").String(),
+			Text:     messageContent.Get("text").String(),
+		})
+		return parts, len(parts) - 1
+	case "execution_output":
+		parts = append(parts, Part{
+			Type: PartExecutionResult,
+			Text: messageContent.Get("text").String(),
+		})
+		return parts, len(parts) - 1
+	}
+	return parts, -1
+}
+
+// appendReferences adds the generated images and the citations of a data line, the cumulative
+// buffer repeats them so the seen ones are skipped
+func (g *ChatGPTAdapter) appendReferences(parts []Part, jsonData string, seen map[string]bool) []Part {
+	for _, assetPointerMatch := range chatGPTAssetPointerRegexp.FindAllStringSubmatch(jsonData, -1) {
+		imageURL := fmt.Sprintf("/backend-api/files/%s/download", assetPointerMatch[1])
+		if !seen[imageURL] {
+			seen[imageURL] = true
+			parts = append(parts, Part{Type: PartImage, URL: imageURL})
+		}
+	}
+
+	citations := make([]gjson.Result, 0)
+	citations = append(citations, gjson.Get(jsonData, "v.message.metadata.citations").Array()...)
+	if gjson.Get(jsonData, "p").String() == "/message/metadata/citations" {
+		citations = append(citations, gjson.Get(jsonData, "v").Array()...)
+	}
+	if gjson.Get(jsonData, "o").String() == "patch" {
+		for _, patch := range gjson.Get(jsonData, "v").Array() {
+			if patch.Get("p").String() == "/message/metadata/citations" {
+				citations = append(citations, patch.Get("v").Array()...)
+			}
+		}
+	}
+	for _, citation := range citations {
+		citationURL := citation.Get("metadata.url").String()
+		if citationURL == "" {
+			continue
+		}
+		key := fmt.Sprintf("%d-%d-%s", citation.Get("start_ix").Int(), citation.Get("end_ix").Int(), citationURL)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		parts = append(parts, Part{
+			Type:       PartCitation,
+			URL:        citationURL,
+			Title:      citation.Get("metadata.title").String(),
+			StartIndex: int(citation.Get("start_ix").Int()),
+			EndIndex:   int(citation.Get("end_ix").Int()),
+		})
+	}
+	return parts
+}
+
 func (g *ChatGPTAdapter) getDataContent(jsonData string, thinkStatus *bool) (string, bool) {
 	if jsonData == "[DONE]" {
 		return "", true
@@ -24,12 +24,21 @@ func (g *GeminiAIStudioAdapter) HandleResponse(responseBuffer []byte, done bool)
 	arrToolCalls := make([]string, 0)
 	input := string(responseBuffer)
 	matches := re.FindAllString(input, -1)
+	parts := make([]Part, 0)
+	imagesInParts := false
 	for _, match := range matches {
+		// The inline images take their place among the text, in the order of the response
+		if images := inlineImages([]byte(match)); len(images) > 0 {
+			parts = append(parts, images...)
+			imagesInParts = true
+			continue
+		}
 		value := gjson.Get(match, "0.0")
 		if value.IsArray() {
 			arr := value.Array()
 			if len(arr) == 2 {
 				body = body + arr[1].String()
+				parts = appendTextPart(parts, arr[1].String())
 			} else if len(arr) == 11 && arr[1].Type == gjson.Null && arr[10].Type == gjson.JSON {
 				if !arr[10].IsArray() {
 					continue
@@ -40,7 +49,8 @@ func (g *GeminiAIStudioAdapter) HandleResponse(responseBuffer []byte, done bool)
 				params := g.parseToolCallParams(argumentsStr)
 
 				toolCallsTemplate := `{"id":"","index":0,"type":"function","function":{"name":"","arguments":""}}`
-				tcs, _ := sjson.Set(toolCallsTemplate, "function.name", funcName)
+				tcs, _ := sjson.Set(toolCallsTemplate, "index", len(arrToolCalls))
+				tcs, _ = sjson.Set(tcs, "function.name", funcName)
 				tcs, _ = sjson.Set(tcs, "function.arguments", params)
 				arrToolCalls = append(arrToolCalls, tcs)
 			} else if len(arr) > 2 {
@@ -52,11 +62,17 @@ func (g *GeminiAIStudioAdapter) HandleResponse(responseBuffer []byte, done bool)
 		toolCalls = "[" + strings.Join(arrToolCalls, ",") + "]"
 	}
 
+	// Images outside of the model parts are still reported, after the text
+	if !imagesInParts {
+		parts = append(parts, inlineImages(responseBuffer)...)
+	}
+
 	result := &AdapterResponse{
 		Content:          body,
 		ReasoningContent: think,
 		ToolCalls:        toolCalls,
 		Done:             done,
+		Parts:            parts,
 	}
 	return result, nil
 }
@@ -16,9 +16,15 @@ func (g *GrokAdapter) HandleResponse(responseBuffer []byte, done bool) (*Adapter
 	think := ""
 	body := ""
 	toolCalls := ""
+	conversationID := ""
 
 	parsedObjects := strings.Split(string(responseBuffer), "\n")
 	for _, obj := range parsedObjects {
+		conversationIDResult := gjson.Get(obj, "result.conversation.conversationId")
+		if conversationIDResult.Type == gjson.String {
+			conversationID = conversationIDResult.String()
+		}
+
 		modelResponseResult := gjson.Get(obj, "result.response.modelResponse")
 		if modelResponseResult.Type == gjson.Null {
 			token := ""
@@ -52,5 +58,6 @@ func (g *GrokAdapter) HandleResponse(responseBuffer []byte, done bool) (*Adapter
 		ReasoningContent: think,
 		ToolCalls:        toolCalls,
 		Done:             done,
+		ConversationID:   conversationID,
 	}, nil
 }
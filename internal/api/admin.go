@@ -0,0 +1,241 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/chromedp/chromedp"
+	"github.com/gin-gonic/gin"
+	"github.com/luispater/anyAIProxyAPI/internal/browser/chrome"
+	"github.com/luispater/anyAIProxyAPI/internal/config"
+	"github.com/luispater/anyAIProxyAPI/internal/runner"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/sjson"
+	"net/http"
+	"time"
+)
+
+// AdminInstances handles the GET /admin/instances endpoint
+func (h *APIHandlers) AdminInstances(c *gin.Context) {
+	list := `{"object":"list","data":[],"queue_length":0}`
+	for i := range h.appConfig.Instance {
+		list, _ = sjson.SetRaw(list, "data.-1", h.instanceJson(i))
+	}
+	list, _ = sjson.Set(list, "queue_length", h.queue.GetQueueLength())
+	c.Header("Content-Type", "application/json")
+	c.String(http.StatusOK, list)
+}
+
+// AdminInstance handles the GET /admin/instances/{name} endpoint
+func (h *APIHandlers) AdminInstance(c *gin.Context) {
+	index, ok := h.adminInstance(c)
+	if !ok {
+		return
+	}
+	c.Header("Content-Type", "application/json")
+	c.String(http.StatusOK, h.instanceJson(index))
+}
+
+// AdminInstanceAction handles the POST /admin/instances/{name}/{action} endpoint.
+// Actions driving the page wait for no task to run on it, they answer 409 otherwise.
+func (h *APIHandlers) AdminInstanceAction(c *gin.Context) {
+	index, ok := h.adminInstance(c)
+	if !ok {
+		return
+	}
+	instanceConfig := h.appConfig.Instance[index]
+	action := c.Param("action")
+
+	var err error
+	switch action {
+	case "pause":
+		h.instances.SetPaused(instanceConfig.Name, true)
+	case "resume":
+		h.instances.SetPaused(instanceConfig.Name, false)
+	case "init":
+		err = h.withIdlePage(c, instanceConfig, func(page *chrome.Page) error {
+			r, errNewRunnerManager := runner.NewRunnerManager(instanceConfig.Name, instanceConfig.Runner, page, h.debug)
+			if errNewRunnerManager != nil {
+				return errNewRunnerManager
+			}
+			return r.Run("init")
+		})
+	case "reload":
+		err = h.withIdlePage(c, instanceConfig, func(page *chrome.Page) error {
+			ctx, cancel := context.WithTimeout(page.GetContext(), time.Minute)
+			defer cancel()
+			return chromedp.Run(ctx, chromedp.Reload())
+		})
+	case "attach":
+		err = h.attachConsole(c, instanceConfig)
+	case "detach":
+		err = h.detachConsole(c, instanceConfig.Name)
+	case "reload-runner":
+		err = h.reloadRunner(index)
+	case "save-auth":
+		page, ready := h.pages[instanceConfig.Name]
+		if !ready {
+			err = fmt.Errorf("the page of instance %s is not open", instanceConfig.Name)
+		} else if instanceConfig.Auth.File == "" {
+			err = fmt.Errorf("instance %s has no auth file", instanceConfig.Name)
+		} else if err = chrome.SaveAuthInfo(page.GetContext(), instanceConfig.Auth.File); err == nil {
+			h.instances.SetAuthSaved(instanceConfig.Name)
+		}
+	default:
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("Unknown action '%s', use init, reload, reload-runner, save-auth, pause, resume, attach or detach", action),
+				Type:    "invalid_request_error",
+			},
+		})
+		return
+	}
+	if c.Writer.Written() {
+		// The page was busy or the console state did not allow the action
+		return
+	}
+	if err != nil {
+		log.Errorf("Admin action %s on instance %s failed: %v", action, instanceConfig.Name, err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("Action %s failed: %v", action, err),
+				Type:    "server_error",
+			},
+		})
+		return
+	}
+	log.Infof("Admin action %s on instance %s done", action, instanceConfig.Name)
+
+	result, _ := sjson.Set(`{"action":"","instance":null}`, "action", action)
+	result, _ = sjson.SetRaw(result, "instance", h.instanceJson(index))
+	c.Header("Content-Type", "application/json")
+	c.String(http.StatusOK, result)
+}
+
+// AdminClearCookies handles the POST /admin/browser/clear-cookies endpoint, it logs every instance out
+func (h *APIHandlers) AdminClearCookies(c *gin.Context) {
+	if h.browser == nil {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error: ErrorDetail{
+				Message: "The browser is not running",
+				Type:    "server_error",
+			},
+		})
+		return
+	}
+	if err := h.browser.ClearBrowserCookies(); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: ErrorDetail{
+				Message: err.Error(),
+				Type:    "server_error",
+			},
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"action": "clear-cookies"})
+}
+
+// adminInstance returns the index of the instance of the name parameter, or answers 404
+func (h *APIHandlers) adminInstance(c *gin.Context) (int, bool) {
+	for i, instanceConfig := range h.appConfig.Instance {
+		if instanceConfig.Name == c.Param("name") {
+			return i, true
+		}
+	}
+	c.JSON(http.StatusNotFound, ErrorResponse{
+		Error: ErrorDetail{
+			Message: fmt.Sprintf("Instance %s not found", c.Param("name")),
+			Type:    "invalid_request_error",
+		},
+	})
+	return 0, false
+}
+
+// withIdlePage runs an action on the page of an instance while holding its request lock.
+// When a task is running on the page, it answers 409 instead of waiting for it.
+func (h *APIHandlers) withIdlePage(c *gin.Context, instanceConfig config.AppConfigInstance, action func(page *chrome.Page) error) error {
+	page, ready := h.pages[instanceConfig.Name]
+	if !ready {
+		return fmt.Errorf("the page of instance %s is not open", instanceConfig.Name)
+	}
+	if !page.RequestMutex.TryLock() {
+		c.JSON(http.StatusConflict, ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("Instance %s is busy with a task, pause it and retry when the task is finished", instanceConfig.Name),
+				Type:    "invalid_request_error",
+				Code:    "instance_busy",
+			},
+		})
+		return nil
+	}
+	defer page.RequestMutex.Unlock()
+	return action(page)
+}
+
+// reloadRunner checks that the runner YAML files of an instance load and reloads its models.yaml catalog.
+// Workflows are read again by every task, so the new files are used from the next task on.
+func (h *APIHandlers) reloadRunner(index int) error {
+	instanceConfig := h.appConfig.Instance[index]
+	r, err := runner.NewRunnerManager(instanceConfig.Name, instanceConfig.Runner, h.pages[instanceConfig.Name], h.debug)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Instance %s workflows: %v", instanceConfig.Name, r.Workflows())
+
+	models, err := config.LoadInstanceModels(instanceConfig.Name)
+	if err != nil {
+		return err
+	}
+	if len(models) > 0 {
+		h.setInstanceModels(instanceConfig.Name, models)
+	}
+	return nil
+}
+
+// instanceJson renders the configuration and the runtime state of an instance
+func (h *APIHandlers) instanceJson(index int) string {
+	instanceConfig := h.appConfig.Instance[index]
+	status := h.instances.Status(instanceConfig.Name)
+
+	instanceJson := `{"name":"","adapter":"","url":"","current_url":null,"ready":false,"paused":false,"attached":false,"attached_at":null,"auth":{"check":"","file":"","logged_in":null,"checked_at":null,"saved_at":null},"models":[],"current_task":null,"waiting_tasks":0,"readiness":null,"last_error":null}`
+	instanceJson, _ = sjson.Set(instanceJson, "name", instanceConfig.Name)
+	instanceJson, _ = sjson.Set(instanceJson, "adapter", instanceConfig.Adapter)
+	instanceJson, _ = sjson.Set(instanceJson, "url", instanceConfig.URL)
+	instanceJson, _ = sjson.Set(instanceJson, "paused", status.Paused)
+	if status.Attached {
+		instanceJson, _ = sjson.Set(instanceJson, "attached", true)
+		instanceJson, _ = sjson.Set(instanceJson, "attached_at", status.AttachedAt.Unix())
+	}
+	instanceJson, _ = sjson.Set(instanceJson, "auth.check", instanceConfig.Auth.Check)
+	instanceJson, _ = sjson.Set(instanceJson, "auth.file", instanceConfig.Auth.File)
+	if status.AuthChecked {
+		instanceJson, _ = sjson.Set(instanceJson, "auth.logged_in", status.LoggedIn)
+		instanceJson, _ = sjson.Set(instanceJson, "auth.checked_at", status.AuthCheckedAt.Unix())
+	}
+	if !status.AuthSavedAt.IsZero() {
+		instanceJson, _ = sjson.Set(instanceJson, "auth.saved_at", status.AuthSavedAt.Unix())
+	}
+	for _, model := range h.instanceModels(instanceConfig.Name) {
+		instanceJson, _ = sjson.Set(instanceJson, "models.-1", model)
+	}
+
+	if page, ready := h.pages[instanceConfig.Name]; ready {
+		instanceJson, _ = sjson.Set(instanceJson, "ready", true)
+		var currentURL string
+		ctx, cancel := context.WithTimeout(page.GetContext(), 2*time.Second)
+		if err := chromedp.Run(ctx, chromedp.Location(&currentURL)); err == nil {
+			instanceJson, _ = sjson.Set(instanceJson, "current_url", currentURL)
+		}
+		cancel()
+	}
+
+	currentTask, waiting := h.tasks.instanceTasks(index)
+	instanceJson, _ = sjson.SetRaw(instanceJson, "current_task", currentTask)
+	instanceJson, _ = sjson.Set(instanceJson, "waiting_tasks", waiting)
+
+	_, readinessJson := h.instanceReadiness(index, h.browser != nil && h.browser.Connected())
+	instanceJson, _ = sjson.SetRaw(instanceJson, "readiness", readinessJson)
+	if status.LastError != "" {
+		instanceJson, _ = sjson.Set(instanceJson, "last_error", status.LastError)
+	}
+	return instanceJson
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/luispater/anyAIProxyAPI/internal/config"
+	"github.com/luispater/anyAIProxyAPI/internal/fetch"
+	"github.com/luispater/anyAIProxyAPI/internal/method"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+)
+
+const defaultMaxFileSizeMB = 20
+
+// checkAttachments validates the image, file and audio parts of a request against the attachment limits
+// of its instance, it returns nil when they can all be uploaded. The remote URLs only count toward the
+// number of files, until inlineRemoteFiles downloads them.
+func checkAttachments(instanceConfig config.AppConfigInstance, rawJson []byte) *ErrorResponse {
+	attachmentError := func(code, message string) *ErrorResponse {
+		return &ErrorResponse{
+			Error: ErrorDetail{
+				Message: message,
+				Type:    "invalid_request_error",
+				Code:    code,
+			},
+		}
+	}
+
+	attachments, err := method.RequestAttachments(string(rawJson))
+	if err != nil {
+		if errors.Is(err, method.ErrUnsupportedType) {
+			return attachmentError("unsupported_file_type", fmt.Sprintf("Invalid attachment, %v", err))
+		}
+		return attachmentError("invalid_attachment", fmt.Sprintf("Invalid attachment, %v", err))
+	}
+
+	limits := instanceConfig.Attachments
+	if count := len(attachments) + len(remoteParts(string(rawJson))); limits.MaxFiles > 0 && count > limits.MaxFiles {
+		return attachmentError("too_many_files", fmt.Sprintf("The request has %d attachments, instance %s accepts at most %d", count, instanceConfig.Name, limits.MaxFiles))
+	}
+	maxFileSizeMB := limits.MaxFileSizeMB
+	if maxFileSizeMB <= 0 {
+		maxFileSizeMB = defaultMaxFileSizeMB
+	}
+	for _, attachment := range attachments {
+		if attachment.Size() > maxFileSizeMB*1024*1024 {
+			return attachmentError("file_too_large", fmt.Sprintf("Attachment %s is larger than the %d MB accepted by instance %s", attachment.Filename, maxFileSizeMB, instanceConfig.Name))
+		}
+		if !typeAllowed(limits.Types, attachment.MimeType) {
+			return attachmentError("unsupported_file_type", fmt.Sprintf("Attachment %s has type %s, instance %s accepts %s", attachment.Filename, attachment.MimeType, instanceConfig.Name, strings.Join(limits.Types, ", ")))
+		}
+	}
+	return nil
+}
+
+// typeAllowed reports whether a MIME type matches one of the types, all the types are allowed when there are none
+func typeAllowed(types []string, mimeType string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	for _, allowed := range types {
+		if matched, _ := path.Match(strings.ToLower(allowed), mimeType); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// remotePart is an image_url or file part of a request referencing an http(s) URL
+type remotePart struct {
+	path     string
+	url      string
+	partType string
+	code     string
+	message  int
+}
+
+// remoteParts returns the image_url and file parts of a request with an http(s) URL to download
+func remoteParts(request string) []remotePart {
+	parts := make([]remotePart, 0)
+	for i, msg := range gjson.Get(request, "messages").Array() {
+		for j, part := range msg.Get("content").Array() {
+			field, code := "", ""
+			switch part.Get("type").String() {
+			case "image_url":
+				field, code = "image_url.url", "invalid_image_url"
+			case "file":
+				field, code = "file.file_data", "invalid_file_url"
+			default:
+				continue
+			}
+			if remoteURL := part.Get(field).String(); fetch.IsRemote(remoteURL) {
+				parts = append(parts, remotePart{
+					path:     fmt.Sprintf("messages.%d.content.%d.%s", i, j, field),
+					url:      remoteURL,
+					partType: part.Get("type").String(),
+					code:     code,
+					message:  i,
+				})
+			}
+		}
+	}
+	return parts
+}
+
+// inlineRemoteFiles downloads the http(s) URLs of the image_url and file parts of an admitted request and
+// replaces them with data URLs, so the runners can upload them, then checks the downloaded files against
+// the attachment limits of the instance. A URL that cannot be downloaded fails the request.
+func (h *APIHandlers) inlineRemoteFiles(ctx context.Context, admission *taskAdmission) *ErrorResponse {
+	request := string(admission.request)
+	parts := remoteParts(request)
+	if len(parts) == 0 {
+		return nil
+	}
+	for _, part := range parts {
+		data, mimeType, err := h.fetcher.Fetch(ctx, part.url)
+		if err != nil {
+			return &ErrorResponse{
+				Error: ErrorDetail{
+					Message: fmt.Sprintf("Could not fetch the %s of message %d: %v", part.partType, part.message, err),
+					Type:    "invalid_request_error",
+					Code:    part.code,
+				},
+			}
+		}
+		attachment := method.Attachment{MimeType: mimeType, Data: base64.StdEncoding.EncodeToString(data)}
+		if part.partType == "image_url" {
+			attachment.Filename = urlFilename(part.url, mimeType)
+		}
+		request, _ = sjson.Set(request, part.path, attachment.DataURL())
+	}
+	if attachmentError := checkAttachments(h.appConfig.Instance[admission.instanceIndex], []byte(request)); attachmentError != nil {
+		return attachmentError
+	}
+	admission.request = []byte(request)
+	return nil
+}
+
+// urlFilename returns the last path segment of a URL when its extension matches the MIME type, "" otherwise
+func urlFilename(rawURL, mimeType string) string {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	filename := path.Base(parsedURL.Path)
+	extensionType, _, _ := mime.ParseMediaType(mime.TypeByExtension(path.Ext(filename)))
+	if extensionType != mimeType {
+		return ""
+	}
+	return filename
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"github.com/luispater/anyAIProxyAPI/internal/browser/chrome"
+	"github.com/luispater/anyAIProxyAPI/internal/config"
+	"github.com/luispater/anyAIProxyAPI/internal/fetch"
+	"github.com/luispater/anyAIProxyAPI/internal/instance"
+	"github.com/luispater/anyAIProxyAPI/internal/ratelimit"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRemoteFileDataIsFetchedAfterAdmission(t *testing.T) {
+	h := &APIHandlers{
+		appConfig: &config.AppConfig{Instance: []config.AppConfigInstance{{Name: "test"}}},
+		pages:     map[string]*chrome.Page{"test": {}},
+		instances: instance.NewRegistry(),
+		limiter:   ratelimit.NewLimiter(),
+		fetcher:   fetch.New(config.AppConfigFetch{}),
+	}
+	rawJson := []byte(`{"model":"test/model","messages":[{"role":"user","content":[{"type":"text","text":"Summarize"},{"type":"file","file":{"filename":"report.pdf","file_data":"https://127.0.0.1/report.pdf"}}]}]}`)
+
+	admission, _, status, errResponse := h.admitTask(nil, rawJson)
+	if errResponse != nil {
+		t.Fatalf("admitTask rejected a remote file_data with %d: %s", status, errResponse.Error.Message)
+	}
+
+	// The loopback URL is not fetched, so the request fails on the download rather than being queued
+	_, status, errResponse = h.startTask(admission, nil)
+	if errResponse == nil {
+		t.Fatal("startTask fetched a loopback URL")
+	}
+	if status != http.StatusBadRequest || errResponse.Error.Code != "invalid_file_url" {
+		t.Fatalf("startTask returned %d %s, want 400 invalid_file_url", status, errResponse.Error.Code)
+	}
+	if !strings.Contains(errResponse.Error.Message, "Could not fetch the file of message 0") {
+		t.Fatalf("unexpected error message: %s", errResponse.Error.Message)
+	}
+}
+
+func TestRemoteFileDataCountsTowardMaxFiles(t *testing.T) {
+	instanceConfig := config.AppConfigInstance{Name: "test", Attachments: config.AppConfigAttachments{MaxFiles: 1}}
+	rawJson := []byte(`{"messages":[{"role":"user","content":[{"type":"file","file":{"file_data":"https://example.com/a.pdf"}},{"type":"file","file":{"file_data":"https://example.com/b.pdf"}}]}]}`)
+
+	errResponse := checkAttachments(instanceConfig, rawJson)
+	if errResponse == nil || errResponse.Error.Code != "too_many_files" {
+		t.Fatalf("checkAttachments returned %v, want too_many_files", errResponse)
+	}
+}
@@ -0,0 +1,516 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/luispater/anyAIProxyAPI/internal/auth"
+	"github.com/luispater/anyAIProxyAPI/internal/batch"
+	"github.com/luispater/anyAIProxyAPI/internal/ratelimit"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// batchEndpoint is the only endpoint batches can target
+const batchEndpoint = "/v1/chat/completions"
+
+// UploadFile handles the /v1/files endpoint, it stores a batch input file
+func (h *APIHandlers) UploadFile(c *gin.Context) {
+	purpose := c.PostForm("purpose")
+	if purpose != "batch" {
+		writeBatchError(c, http.StatusBadRequest, "invalid_purpose", fmt.Sprintf("The purpose '%s' is not supported, only 'batch' is.", purpose))
+		return
+	}
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		writeBatchError(c, http.StatusBadRequest, "missing_file", fmt.Sprintf("Invalid request: %v", err))
+		return
+	}
+	content, err := fileHeader.Open()
+	if err != nil {
+		writeBatchError(c, http.StatusBadRequest, "invalid_file", fmt.Sprintf("Invalid request: %v", err))
+		return
+	}
+	defer func() {
+		_ = content.Close()
+	}()
+
+	file, err := h.batches.CreateFile(apiKeyFromContext(c).ID(), fileHeader.Filename, purpose, content)
+	if err != nil {
+		log.Errorf("Error storing file %s: %v", fileHeader.Filename, err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("Failed to store the file: %v", err),
+				Type:    "server_error",
+			},
+		})
+		return
+	}
+	c.Header("Content-Type", "application/json")
+	c.String(http.StatusOK, file.JSON())
+}
+
+// ListFiles handles the GET /v1/files endpoint
+func (h *APIHandlers) ListFiles(c *gin.Context) {
+	list := `{"object":"list","data":[]}`
+	for _, file := range h.batches.Files(apiKeyFromContext(c).ID()) {
+		list, _ = sjson.SetRaw(list, "data.-1", file.JSON())
+	}
+	c.Header("Content-Type", "application/json")
+	c.String(http.StatusOK, list)
+}
+
+// RetrieveFile handles the GET /v1/files/{id} endpoint
+func (h *APIHandlers) RetrieveFile(c *gin.Context) {
+	file, ok := h.ownedFile(c)
+	if !ok {
+		return
+	}
+	c.Header("Content-Type", "application/json")
+	c.String(http.StatusOK, file.JSON())
+}
+
+// RetrieveFileContent handles the GET /v1/files/{id}/content endpoint, it returns input and output JSONL files
+func (h *APIHandlers) RetrieveFileContent(c *gin.Context) {
+	file, ok := h.ownedFile(c)
+	if !ok {
+		return
+	}
+	c.Header("Content-Type", "application/jsonl")
+	c.File(h.batches.FilePath(file.ID))
+}
+
+// DeleteFile handles the DELETE /v1/files/{id} endpoint
+func (h *APIHandlers) DeleteFile(c *gin.Context) {
+	file, ok := h.ownedFile(c)
+	if !ok {
+		return
+	}
+	for _, unfinished := range h.batches.Unfinished() {
+		if unfinished.InputFileID == file.ID || unfinished.OutputFileID == file.ID {
+			writeBatchError(c, http.StatusConflict, "file_in_use", fmt.Sprintf("The file is used by batch %s, which has not finished.", unfinished.ID))
+			return
+		}
+	}
+	if err := h.batches.DeleteFile(file.ID); err != nil {
+		log.Errorf("Error deleting file %s: %v", file.ID, err)
+	}
+	deleted, _ := sjson.Set(`{"id":"","object":"file","deleted":true}`, "id", file.ID)
+	c.Header("Content-Type", "application/json")
+	c.String(http.StatusOK, deleted)
+}
+
+// ownedFile returns the file of the id parameter, or answers 404 when it does not belong to the API key
+func (h *APIHandlers) ownedFile(c *gin.Context) (batch.File, bool) {
+	file, ok := h.batches.File(c.Param("id"))
+	if !ok || file.Owner != apiKeyFromContext(c).ID() {
+		writeBatchError(c, http.StatusNotFound, "file_not_found", fmt.Sprintf("No such File object: %s", c.Param("id")))
+		return batch.File{}, false
+	}
+	return file, true
+}
+
+// CreateBatch handles the /v1/batches endpoint
+func (h *APIHandlers) CreateBatch(c *gin.Context) {
+	rawJson, err := c.GetRawData()
+	if err != nil {
+		writeBatchError(c, http.StatusBadRequest, "", fmt.Sprintf("Invalid request: %v", err))
+		return
+	}
+
+	endpoint := gjson.GetBytes(rawJson, "endpoint").String()
+	if endpoint != batchEndpoint {
+		writeBatchError(c, http.StatusBadRequest, "invalid_endpoint", fmt.Sprintf("The endpoint '%s' is not supported, only '%s' is.", endpoint, batchEndpoint))
+		return
+	}
+	completionWindow := gjson.GetBytes(rawJson, "completion_window").String()
+	if completionWindow == "" {
+		completionWindow = "24h"
+	}
+	owner := apiKeyFromContext(c).ID()
+	inputFileID := gjson.GetBytes(rawJson, "input_file_id").String()
+	inputFile, ok := h.batches.File(inputFileID)
+	if !ok || inputFile.Owner != owner {
+		writeBatchError(c, http.StatusBadRequest, "invalid_input_file", fmt.Sprintf("No such File object: %s", inputFileID))
+		return
+	}
+
+	metadata := make(map[string]string)
+	gjson.GetBytes(rawJson, "metadata").ForEach(func(key, value gjson.Result) bool {
+		metadata[key.String()] = value.String()
+		return true
+	})
+
+	requests, errors := batch.ReadRequests(h.batches.FilePath(inputFileID), endpoint)
+	newBatch := batch.Batch{
+		Endpoint:         endpoint,
+		InputFileID:      inputFileID,
+		CompletionWindow: completionWindow,
+		Status:           batch.StatusValidating,
+		RequestCounts:    batch.RequestCounts{Total: len(requests)},
+		Metadata:         metadata,
+		Owner:            owner,
+	}
+	if len(errors) > 0 {
+		newBatch.Status = batch.StatusFailed
+		newBatch.Errors = &batch.Errors{Object: "list", Data: errors}
+		newBatch.FailedAt = time.Now().Unix()
+	}
+
+	created, err := h.batches.CreateBatch(newBatch)
+	if err != nil {
+		log.Errorf("Error storing batch: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("Failed to store the batch: %v", err),
+				Type:    "server_error",
+			},
+		})
+		return
+	}
+	if created.Status == batch.StatusValidating {
+		log.Infof("Batch %s from API key %s created with %d requests", created.ID, apiKeyFromContext(c).Name(), len(requests))
+		h.startBatch(created.ID)
+	} else {
+		log.Infof("Batch %s from API key %s failed validation with %d errors", created.ID, apiKeyFromContext(c).Name(), len(errors))
+	}
+
+	c.Header("Content-Type", "application/json")
+	c.String(http.StatusOK, created.JSON())
+}
+
+// ListBatches handles the GET /v1/batches endpoint
+func (h *APIHandlers) ListBatches(c *gin.Context) {
+	list := `{"object":"list","data":[],"first_id":null,"last_id":null,"has_more":false}`
+	batches := h.batches.Batches(apiKeyFromContext(c).ID())
+	for _, item := range batches {
+		list, _ = sjson.SetRaw(list, "data.-1", item.JSON())
+	}
+	if len(batches) > 0 {
+		list, _ = sjson.Set(list, "first_id", batches[0].ID)
+		list, _ = sjson.Set(list, "last_id", batches[len(batches)-1].ID)
+	}
+	c.Header("Content-Type", "application/json")
+	c.String(http.StatusOK, list)
+}
+
+// RetrieveBatch handles the GET /v1/batches/{id} endpoint
+func (h *APIHandlers) RetrieveBatch(c *gin.Context) {
+	item, ok := h.ownedBatch(c)
+	if !ok {
+		return
+	}
+	c.Header("Content-Type", "application/json")
+	c.String(http.StatusOK, item.JSON())
+}
+
+// CancelBatch handles the /v1/batches/{id}/cancel endpoint. Requests already running are finished,
+// the batch is cancelled once they are written to the output file.
+func (h *APIHandlers) CancelBatch(c *gin.Context) {
+	item, ok := h.ownedBatch(c)
+	if !ok {
+		return
+	}
+	if item.Finished() || item.Status == batch.StatusCancelling {
+		writeBatchError(c, http.StatusConflict, "invalid_status", fmt.Sprintf("Cannot cancel a batch with status '%s'.", item.Status))
+		return
+	}
+
+	h.batchMu.Lock()
+	cancel, running := h.batchCancels[item.ID]
+	h.batchMu.Unlock()
+
+	item, err := h.batches.UpdateBatch(item.ID, func(b *batch.Batch) {
+		b.CancellingAt = time.Now().Unix()
+		b.Status = batch.StatusCancelling
+		if !running {
+			b.Status = batch.StatusCancelled
+			b.CancelledAt = b.CancellingAt
+		}
+	})
+	if err != nil {
+		log.Errorf("Error cancelling batch %s: %v", item.ID, err)
+	}
+	if running {
+		cancel()
+	}
+	log.Infof("Batch %s cancelled by API key %s", item.ID, apiKeyFromContext(c).Name())
+
+	c.Header("Content-Type", "application/json")
+	c.String(http.StatusOK, item.JSON())
+}
+
+// ownedBatch returns the batch of the id parameter, or answers 404 when it does not belong to the API key
+func (h *APIHandlers) ownedBatch(c *gin.Context) (batch.Batch, bool) {
+	item, ok := h.batches.Batch(c.Param("id"))
+	if !ok || item.Owner != apiKeyFromContext(c).ID() {
+		writeBatchError(c, http.StatusNotFound, "batch_not_found", fmt.Sprintf("No such Batch object: %s", c.Param("id")))
+		return batch.Batch{}, false
+	}
+	return item, true
+}
+
+// ResumeBatches restarts the batches that were running when the server stopped.
+// The requests already written to their output files are skipped.
+func (h *APIHandlers) ResumeBatches() {
+	for _, item := range h.batches.Unfinished() {
+		if item.Status == batch.StatusCancelling {
+			_, _ = h.batches.UpdateBatch(item.ID, func(b *batch.Batch) {
+				b.Status = batch.StatusCancelled
+				b.CancelledAt = time.Now().Unix()
+			})
+			continue
+		}
+		log.Infof("Resuming batch %s", item.ID)
+		h.startBatch(item.ID)
+	}
+}
+
+// startBatch runs a batch in the background
+func (h *APIHandlers) startBatch(id string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	h.batchMu.Lock()
+	h.batchCancels[id] = cancel
+	h.batchMu.Unlock()
+
+	go func() {
+		defer func() {
+			h.batchMu.Lock()
+			delete(h.batchCancels, id)
+			h.batchMu.Unlock()
+			cancel()
+		}()
+		h.runBatch(ctx, id)
+	}()
+}
+
+// runBatch runs the pending requests of a batch. Every instance works through its requests in parallel
+// with the other instances, up to its batch-concurrency.
+func (h *APIHandlers) runBatch(ctx context.Context, id string) {
+	item, ok := h.batches.Batch(id)
+	if !ok {
+		return
+	}
+
+	var key *auth.Key
+	if h.authenticator.Enabled() {
+		if key, ok = h.authenticator.Lookup(item.Owner); !ok {
+			h.failBatch(id, batch.Error{Code: "invalid_api_key", Message: "The API key that created the batch is no longer configured."})
+			return
+		}
+	}
+
+	requests, errors := batch.ReadRequests(h.batches.FilePath(item.InputFileID), item.Endpoint)
+	if len(errors) > 0 {
+		h.failBatch(id, errors...)
+		return
+	}
+	if item.OutputFileID == "" {
+		if _, err := h.batches.CreateOutputFile(id); err != nil {
+			h.failBatch(id, batch.Error{Code: "server_error", Message: fmt.Sprintf("Failed to create the output file: %v", err)})
+			return
+		}
+	}
+	results, err := h.batches.Results(id)
+	if err != nil {
+		h.failBatch(id, batch.Error{Code: "server_error", Message: fmt.Sprintf("Failed to read the output file: %v", err)})
+		return
+	}
+
+	_, _ = h.batches.UpdateBatch(id, func(b *batch.Batch) {
+		if b.Status == batch.StatusValidating {
+			b.Status = batch.StatusInProgress
+			b.InProgressAt = time.Now().Unix()
+		}
+		b.RequestCounts.Total = len(requests)
+	})
+
+	pending := make(map[string][]batch.Request)
+	for _, request := range requests {
+		if _, done := results[request.CustomID]; done {
+			continue
+		}
+		instanceName := strings.Split(gjson.Get(request.Body, "model").String(), "/")[0]
+		pending[instanceName] = append(pending[instanceName], request)
+	}
+
+	var wg sync.WaitGroup
+	for instanceName, instanceRequests := range pending {
+		wg.Add(1)
+		go func(slots chan struct{}, instanceRequests []batch.Request) {
+			defer wg.Done()
+			var running sync.WaitGroup
+			for _, request := range instanceRequests {
+				if !acquireBatchSlot(ctx, slots) {
+					break
+				}
+				running.Add(1)
+				go func(request batch.Request) {
+					defer running.Done()
+					defer releaseBatchSlot(slots)
+					result, failed := h.runBatchRequest(ctx, key, request)
+					if result == "" {
+						return
+					}
+					if errAppend := h.batches.AppendResult(id, result, failed); errAppend != nil {
+						log.Errorf("Error writing the result of %s in batch %s: %v", request.CustomID, id, errAppend)
+					}
+				}(request)
+			}
+			running.Wait()
+		}(h.batchSlots[instanceName], instanceRequests)
+	}
+	wg.Wait()
+
+	item, _ = h.batches.UpdateBatch(id, func(b *batch.Batch) {
+		if ctx.Err() != nil || b.Status == batch.StatusCancelling {
+			b.Status = batch.StatusCancelled
+			b.CancelledAt = time.Now().Unix()
+			return
+		}
+		b.Status = batch.StatusCompleted
+		b.FinalizingAt = time.Now().Unix()
+		b.CompletedAt = b.FinalizingAt
+	})
+	log.Infof("Batch %s %s: %d completed, %d failed of %d requests", id, item.Status, item.RequestCounts.Completed, item.RequestCounts.Failed, item.RequestCounts.Total)
+}
+
+// runBatchRequest runs a request of a batch and returns its output line, or an empty string when the batch
+// was cancelled before the request started. Rate limited requests and instances still starting are retried.
+func (h *APIHandlers) runBatchRequest(ctx context.Context, key *auth.Key, request batch.Request) (string, bool) {
+	body, _ := sjson.Set(request.Body, "stream", true)
+
+	var admission *taskAdmission
+	for {
+		var rateLimit ratelimit.Result
+		var status int
+		var errResponse *ErrorResponse
+		admission, rateLimit, status, errResponse = h.admitTask(key, []byte(body))
+		if errResponse == nil {
+			break
+		}
+
+		wait := time.Second
+		switch {
+		case status == http.StatusTooManyRequests:
+			wait = max(wait, rateLimit.RetryAfter)
+		case status == http.StatusNotFound && h.instanceConfigured(gjson.Get(body, "model").String()):
+			// The browser page of the instance is not open yet
+		case errResponse.Error.Code == "instance_paused", errResponse.Error.Code == "instance_attached":
+		default:
+			return batchResult(request, status, errorResponseJson(errResponse))
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", false
+		case <-time.After(wait):
+		}
+	}
+
+	task, status, errResponse := h.startTask(admission, nil)
+	if errResponse != nil {
+		return batchResult(request, status, errorResponseJson(errResponse))
+	}
+	defer task.Release()
+
+	accumulator := &completionAccumulator{}
+	if errorJson := h.collectTask(task, accumulator.add); errorJson != "" {
+		return batchResult(request, http.StatusInternalServerError, errorJson)
+	}
+	return batchResult(request, http.StatusOK, accumulator.completion(admission.model))
+}
+
+// instanceConfigured reports whether the instance of a model is configured
+func (h *APIHandlers) instanceConfigured(model string) bool {
+	instanceName := strings.Split(model, "/")[0]
+	for _, instance := range h.appConfig.Instance {
+		if instance.Name == instanceName {
+			return true
+		}
+	}
+	return false
+}
+
+// failBatch marks a batch as failed with its errors
+func (h *APIHandlers) failBatch(id string, errors ...batch.Error) {
+	_, err := h.batches.UpdateBatch(id, func(b *batch.Batch) {
+		b.Status = batch.StatusFailed
+		b.Errors = &batch.Errors{Object: "list", Data: errors}
+		b.FailedAt = time.Now().Unix()
+	})
+	if err != nil {
+		log.Errorf("Error failing batch %s: %v", id, err)
+	}
+	log.Warnf("Batch %s failed: %s", id, errors[0].Message)
+}
+
+// batchResult renders the output line of a batch request, the bool reports a failed request
+func batchResult(request batch.Request, status int, body string) (string, bool) {
+	result := `{"id":"","custom_id":"","response":{"status_code":0,"request_id":"","body":null},"error":null}`
+	id := "batch_req_" + generateRandomString(24)
+	result, _ = sjson.Set(result, "id", id)
+	result, _ = sjson.Set(result, "custom_id", request.CustomID)
+	result, _ = sjson.Set(result, "response.status_code", status)
+	result, _ = sjson.Set(result, "response.request_id", id)
+	result, _ = sjson.SetRaw(result, "response.body", body)
+	if status != http.StatusOK {
+		code := gjson.Get(body, "error.code").String()
+		if code == "" {
+			code = gjson.Get(body, "error.type").String()
+		}
+		result, _ = sjson.Set(result, "error", map[string]string{
+			"code":    code,
+			"message": gjson.Get(body, "error.message").String(),
+		})
+		return result, true
+	}
+	return result, false
+}
+
+// errorResponseJson renders an error response of the handlers as json
+func errorResponseJson(errResponse *ErrorResponse) string {
+	errorJson, _ := sjson.Set(`{"error":{"message":"","type":""}}`, "error.message", errResponse.Error.Message)
+	errorJson, _ = sjson.Set(errorJson, "error.type", errResponse.Error.Type)
+	if errResponse.Error.Code != "" {
+		errorJson, _ = sjson.Set(errorJson, "error.code", errResponse.Error.Code)
+	}
+	return errorJson
+}
+
+func acquireBatchSlot(ctx context.Context, slots chan struct{}) bool {
+	if slots == nil {
+		return ctx.Err() == nil
+	}
+	select {
+	case slots <- struct{}{}:
+		if ctx.Err() != nil {
+			<-slots
+			return false
+		}
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func releaseBatchSlot(slots chan struct{}) {
+	if slots != nil {
+		<-slots
+	}
+}
+
+func writeBatchError(c *gin.Context, status int, code, message string) {
+	c.JSON(status, ErrorResponse{
+		Error: ErrorDetail{
+			Message: message,
+			Type:    "invalid_request_error",
+			Code:    code,
+		},
+	})
+}
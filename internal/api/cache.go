@@ -0,0 +1,106 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/luispater/anyAIProxyAPI/internal/cache"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"strings"
+	"time"
+)
+
+// dispatchCachedTask answers a request from the response cache, or dispatches it and caches its output.
+// A Cache-Control: no-cache request header skips the lookup, no-store skips the lookup and the caching.
+func (h *APIHandlers) dispatchCachedTask(c *gin.Context, admission *taskAdmission) (*DispatchedTask, int, *ErrorResponse) {
+	cacheControl := strings.ToLower(c.GetHeader("Cache-Control"))
+	noStore := strings.Contains(cacheControl, "no-store")
+	noCache := noStore || strings.Contains(cacheControl, "no-cache")
+
+	key := cache.Key(admission.request)
+	if !noCache {
+		if chunks, hit := h.cache.Get(key); hit {
+			admission.release()
+			log.Debugf("Cache hit for request %s", key)
+			c.Header("X-Cache", "hit")
+			return h.cachedTask(c, admission, chunks), 0, nil
+		}
+		c.Header("X-Cache", "miss")
+	} else {
+		c.Header("X-Cache", "bypass")
+	}
+
+	task, status, errResponse := h.startTask(admission, c)
+	if errResponse == nil && !noStore {
+		h.cacheTask(task, key)
+	}
+	return task, status, errResponse
+}
+
+// cachedTask replays cached chunks as the stream of a new task, with a new completion id and creation time
+func (h *APIHandlers) cachedTask(c *gin.Context, admission *taskAdmission, chunks []string) *DispatchedTask {
+	taskID := uuid.New().String()
+	c.Header("X-Task-Id", taskID)
+
+	created := time.Now().Unix()
+	stream := make(chan string, len(chunks))
+	for _, chunk := range chunks {
+		if gjson.Get(chunk, "id").Exists() {
+			chunk, _ = sjson.Set(chunk, "id", "chatcmpl-"+taskID)
+		}
+		if gjson.Get(chunk, "created").Exists() {
+			chunk, _ = sjson.Set(chunk, "created", created)
+		}
+		stream <- chunk
+	}
+	close(stream)
+
+	return &DispatchedTask{
+		ID:            taskID,
+		InstanceIndex: admission.instanceIndex,
+		Response: &TaskResponse{
+			Success: true,
+			Stream:  stream,
+		},
+	}
+}
+
+// cacheTask forwards the stream of a task to its consumer and caches it when it finishes.
+// Failed and cancelled tasks are not cached.
+func (h *APIHandlers) cacheTask(task *DispatchedTask, key string) {
+	source := task.Response.Stream
+	stream := make(chan string, cap(source))
+	released := make(chan struct{})
+	release := task.release
+	task.release = func() {
+		close(released)
+		if release != nil {
+			release()
+		}
+	}
+	task.Response.Stream = stream
+
+	go func() {
+		defer close(stream)
+		chunks := make([]string, 0)
+		finished := false
+		failed := false
+		for chunk := range source {
+			if strings.HasPrefix(chunk, "{\"error\"") || gjson.Get(chunk, "choices.0.native_finish_reason").String() == "cancelled" {
+				failed = true
+			} else if gjson.Get(chunk, "choices.0.finish_reason").Type == gjson.String {
+				finished = true
+			}
+			chunks = append(chunks, chunk)
+			select {
+			case stream <- chunk:
+			case <-released:
+				return
+			}
+		}
+		if finished && !failed {
+			h.cache.Set(key, chunks)
+		}
+	}()
+}
@@ -0,0 +1,435 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/luispater/anyAIProxyAPI/internal/usage"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ClaudeMessages handles the Anthropic compatible /v1/messages endpoint
+func (h *APIHandlers) ClaudeMessages(c *gin.Context) {
+	rawJson, err := c.GetRawData()
+	if err != nil {
+		writeClaudeError(c, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("Invalid request: %v", err))
+		return
+	}
+
+	chatJson, err := convertClaudeRequest(rawJson)
+	if err != nil {
+		writeClaudeError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+
+	task, status, errResponse := h.dispatchTask(c, []byte(chatJson))
+	if errResponse != nil {
+		writeClaudeError(c, status, claudeErrorType(errResponse.Error.Type), errResponse.Error.Message)
+		return
+	}
+	defer task.Release()
+
+	model := gjson.GetBytes(rawJson, "model").String()
+	if gjson.GetBytes(rawJson, "stream").Type == gjson.True {
+		// message_start comes before the usage of the task, it reports the estimated prompt tokens
+		h.writeResponse(c, task, &claudeStreamWriter{model: model, inputTokens: int64(usage.EstimatePromptTokens(chatJson))})
+	} else {
+		h.writeResponse(c, task, &claudeWriter{model: model})
+	}
+}
+
+// convertClaudeRequest converts an Anthropic messages request into the internal chat completion request
+func convertClaudeRequest(rawJson []byte) (string, error) {
+	root := gjson.ParseBytes(rawJson)
+	if !root.Get("messages").IsArray() {
+		return "", fmt.Errorf("messages: field required")
+	}
+
+	chatJson := `{"model":"","messages":[]}`
+	chatJson, _ = sjson.Set(chatJson, "model", root.Get("model").String())
+	if root.Get("stream").Type == gjson.True {
+		chatJson, _ = sjson.Set(chatJson, "stream", true)
+	}
+	if maxTokensResult := root.Get("max_tokens"); maxTokensResult.Type == gjson.Number {
+		chatJson, _ = sjson.Set(chatJson, "max_tokens", maxTokensResult.Int())
+	}
+	if temperatureResult := root.Get("temperature"); temperatureResult.Type == gjson.Number {
+		chatJson, _ = sjson.Set(chatJson, "temperature", temperatureResult.Float())
+	}
+	if topPResult := root.Get("top_p"); topPResult.Type == gjson.Number {
+		chatJson, _ = sjson.Set(chatJson, "top_p", topPResult.Float())
+	}
+	if stopResult := root.Get("stop_sequences"); stopResult.IsArray() {
+		stops := stopResult.Array()
+		if len(stops) == 1 {
+			chatJson, _ = sjson.Set(chatJson, "stop", stops[0].String())
+		} else if len(stops) > 1 {
+			chatJson, _ = sjson.SetRaw(chatJson, "stop", stopResult.Raw)
+		}
+	}
+	if root.Get("thinking.type").String() == "enabled" {
+		chatJson, _ = sjson.Set(chatJson, "reasoning_effort", reasoningEffortFromBudget(root.Get("thinking.budget_tokens").Int()))
+	}
+
+	// System prompt
+	systemResult := root.Get("system")
+	if systemResult.Type == gjson.String && systemResult.String() != "" {
+		chatJson, _ = sjson.SetRaw(chatJson, "messages.-1", systemMessage(systemResult.String()))
+	} else if systemResult.IsArray() {
+		texts := make([]string, 0)
+		for _, block := range systemResult.Array() {
+			if block.Get("type").String() == "text" {
+				texts = append(texts, block.Get("text").String())
+			}
+		}
+		if len(texts) > 0 {
+			chatJson, _ = sjson.SetRaw(chatJson, "messages.-1", systemMessage(strings.Join(texts, "\n")))
+		}
+	}
+
+	// Messages
+	for _, msg := range root.Get("messages").Array() {
+		role := msg.Get("role").String()
+		if role != "user" && role != "assistant" {
+			return "", fmt.Errorf("messages: unexpected role \"%s\"", role)
+		}
+
+		contentResult := msg.Get("content")
+		if contentResult.Type == gjson.String {
+			message, _ := sjson.Set(`{"role":"","content":""}`, "role", role)
+			message, _ = sjson.Set(message, "content", contentResult.String())
+			chatJson, _ = sjson.SetRaw(chatJson, "messages.-1", message)
+			continue
+		}
+		if !contentResult.IsArray() {
+			return "", fmt.Errorf("messages: content is not a string or array")
+		}
+
+		message, _ := sjson.Set(`{"role":"","content":[]}`, "role", role)
+		hasContent := false
+		for _, block := range contentResult.Array() {
+			switch block.Get("type").String() {
+			case "text":
+				part, _ := sjson.Set(`{"type":"text","text":""}`, "text", block.Get("text").String())
+				message, _ = sjson.SetRaw(message, "content.-1", part)
+				hasContent = true
+			case "image":
+				imageURL := ""
+				if block.Get("source.type").String() == "base64" {
+					imageURL = fmt.Sprintf("data:%s;base64,%s", block.Get("source.media_type").String(), block.Get("source.data").String())
+				} else if block.Get("source.type").String() == "url" {
+					imageURL = block.Get("source.url").String()
+				}
+				if imageURL != "" {
+					part, _ := sjson.Set(`{"type":"image_url","image_url":{"url":""}}`, "image_url.url", imageURL)
+					message, _ = sjson.SetRaw(message, "content.-1", part)
+					hasContent = true
+				}
+			case "tool_use":
+				arguments := block.Get("input").Raw
+				if arguments == "" {
+					arguments = "{}"
+				}
+				toolCall, _ := sjson.Set(`{"id":"","type":"function","function":{"name":"","arguments":""}}`, "id", block.Get("id").String())
+				toolCall, _ = sjson.Set(toolCall, "function.name", block.Get("name").String())
+				toolCall, _ = sjson.Set(toolCall, "function.arguments", arguments)
+				message, _ = sjson.SetRaw(message, "tool_calls.-1", toolCall)
+			case "tool_result":
+				toolMessage, _ := sjson.Set(`{"role":"tool","tool_call_id":"","content":""}`, "tool_call_id", block.Get("tool_use_id").String())
+				toolMessage, _ = sjson.Set(toolMessage, "content", claudeBlocksText(block.Get("content")))
+				chatJson, _ = sjson.SetRaw(chatJson, "messages.-1", toolMessage)
+			}
+		}
+
+		if hasContent || gjson.Get(message, "tool_calls").Exists() {
+			if !hasContent {
+				message, _ = sjson.Set(message, "content", nil)
+			}
+			chatJson, _ = sjson.SetRaw(chatJson, "messages.-1", message)
+		}
+	}
+
+	// Tools
+	if toolsResult := root.Get("tools"); toolsResult.IsArray() {
+		for _, tool := range toolsResult.Array() {
+			if tool.Get("name").String() == "" {
+				continue
+			}
+			function := `{"type":"function","function":{"name":""}}`
+			function, _ = sjson.Set(function, "function.name", tool.Get("name").String())
+			if descriptionResult := tool.Get("description"); descriptionResult.Type == gjson.String {
+				function, _ = sjson.Set(function, "function.description", descriptionResult.String())
+			}
+			if schemaResult := tool.Get("input_schema"); schemaResult.IsObject() {
+				function, _ = sjson.SetRaw(function, "function.parameters", schemaResult.Raw)
+			}
+			chatJson, _ = sjson.SetRaw(chatJson, "tools.-1", function)
+		}
+	}
+	switch root.Get("tool_choice.type").String() {
+	case "auto":
+		chatJson, _ = sjson.Set(chatJson, "tool_choice", "auto")
+	case "any":
+		chatJson, _ = sjson.Set(chatJson, "tool_choice", "required")
+	case "none":
+		chatJson, _ = sjson.Set(chatJson, "tool_choice", "none")
+	case "tool":
+		toolChoice, _ := sjson.Set(`{"type":"function","function":{"name":""}}`, "function.name", root.Get("tool_choice.name").String())
+		chatJson, _ = sjson.SetRaw(chatJson, "tool_choice", toolChoice)
+	}
+
+	return chatJson, nil
+}
+
+// systemMessage builds an OpenAI system message
+func systemMessage(content string) string {
+	message, _ := sjson.Set(`{"role":"system","content":""}`, "content", content)
+	return message
+}
+
+// claudeBlocksText joins the text of a content that is either a string or an array of blocks
+func claudeBlocksText(content gjson.Result) string {
+	if content.Type == gjson.String {
+		return content.String()
+	}
+	texts := make([]string, 0)
+	for _, block := range content.Array() {
+		if block.Get("type").String() == "text" {
+			texts = append(texts, block.Get("text").String())
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// claudeStopReason converts an OpenAI finish reason into an Anthropic stop reason
+func claudeStopReason(finishReason string) string {
+	switch finishReason {
+	case "tool_calls":
+		return "tool_use"
+	case "length":
+		return "max_tokens"
+	default:
+		return "end_turn"
+	}
+}
+
+// claudeErrorType converts the type of an ErrorResponse into an Anthropic error type
+func claudeErrorType(errorType string) string {
+	switch errorType {
+	case "not_found":
+		return "not_found_error"
+	case "invalid_request_error":
+		return "invalid_request_error"
+	case "authentication_error":
+		return "authentication_error"
+	case "permission_error":
+		return "permission_error"
+	case "rate_limit_error":
+		return "rate_limit_error"
+	default:
+		return "api_error"
+	}
+}
+
+// claudeErrorJson builds an Anthropic error json
+func claudeErrorJson(errorType, message string) string {
+	errorJson, _ := sjson.Set(`{"type":"error","error":{"type":"","message":""}}`, "error.type", errorType)
+	errorJson, _ = sjson.Set(errorJson, "error.message", message)
+	return errorJson
+}
+
+func writeClaudeError(c *gin.Context, status int, errorType, message string) {
+	c.Header("Content-Type", "application/json")
+	c.String(status, claudeErrorJson(errorType, message))
+}
+
+// claudeMessageID builds an Anthropic message id
+func claudeMessageID() string {
+	return fmt.Sprintf("msg_%s%d", generateRandomString(12), time.Now().Unix())
+}
+
+// claudeWriter converts a non-streaming chat completion into an Anthropic message
+type claudeWriter struct {
+	model string
+}
+
+func (w *claudeWriter) Begin(c *gin.Context) {
+	c.Header("Content-Type", "application/json")
+}
+
+func (w *claudeWriter) Chunk(c *gin.Context, chunk string) {
+	message := gjson.Get(chunk, "choices.0.message")
+
+	output := `{"id":"","type":"message","role":"assistant","model":"","content":[],"stop_reason":"end_turn","stop_sequence":null,"usage":{"input_tokens":0,"output_tokens":0}}`
+	output, _ = sjson.Set(output, "id", claudeMessageID())
+	output, _ = sjson.Set(output, "model", w.model)
+
+	if reasoningResult := message.Get("reasoning_content"); reasoningResult.Type == gjson.String && reasoningResult.String() != "" {
+		block, _ := sjson.Set(`{"type":"thinking","thinking":"","signature":""}`, "thinking", reasoningResult.String())
+		output, _ = sjson.SetRaw(output, "content.-1", block)
+	}
+	if contentResult := message.Get("content"); contentResult.Type == gjson.String && contentResult.String() != "" {
+		block, _ := sjson.Set(`{"type":"text","text":""}`, "text", contentResult.String())
+		output, _ = sjson.SetRaw(output, "content.-1", block)
+	}
+	for _, toolCall := range message.Get("tool_calls").Array() {
+		block, _ := sjson.Set(`{"type":"tool_use","id":"","name":"","input":{}}`, "id", toolCall.Get("id").String())
+		block, _ = sjson.Set(block, "name", toolCall.Get("function.name").String())
+		if arguments := gjson.Parse(toolCall.Get("function.arguments").String()); arguments.IsObject() {
+			block, _ = sjson.SetRaw(block, "input", arguments.Raw)
+		}
+		output, _ = sjson.SetRaw(output, "content.-1", block)
+	}
+
+	output, _ = sjson.Set(output, "stop_reason", claudeStopReason(gjson.Get(chunk, "choices.0.finish_reason").String()))
+	output, _ = sjson.Set(output, "usage.input_tokens", gjson.Get(chunk, "usage.prompt_tokens").Int())
+	output, _ = sjson.Set(output, "usage.output_tokens", gjson.Get(chunk, "usage.completion_tokens").Int())
+
+	c.Status(http.StatusOK)
+	_, _ = c.Writer.Write([]byte(output))
+}
+
+func (w *claudeWriter) Error(c *gin.Context, chunk string) {
+	c.Status(http.StatusInternalServerError)
+	_, _ = c.Writer.Write([]byte(claudeErrorJson("api_error", gjson.Get(chunk, "error.message").String())))
+}
+
+func (w *claudeWriter) Done(_ *gin.Context) {
+}
+
+func (w *claudeWriter) KeepAlive(c *gin.Context) {
+	_, _ = c.Writer.Write([]byte("\n"))
+}
+
+// claudeStreamWriter converts chat completion chunks into Anthropic server-sent events
+type claudeStreamWriter struct {
+	model        string
+	started      bool
+	stopped      bool
+	blockIndex   int
+	blockType    string
+	toolIndex    int64
+	stopReason   string
+	inputTokens  int64
+	outputTokens int64
+}
+
+func (w *claudeStreamWriter) Begin(c *gin.Context) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("Access-Control-Allow-Origin", "*")
+	w.blockIndex = -1
+	w.stopReason = "end_turn"
+}
+
+func (w *claudeStreamWriter) event(c *gin.Context, name, data string) {
+	_, _ = fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", name, data)
+}
+
+func (w *claudeStreamWriter) start(c *gin.Context) {
+	if w.started {
+		return
+	}
+	w.started = true
+	message := `{"type":"message_start","message":{"id":"","type":"message","role":"assistant","model":"","content":[],"stop_reason":null,"stop_sequence":null,"usage":{"input_tokens":0,"output_tokens":0}}}`
+	message, _ = sjson.Set(message, "message.id", claudeMessageID())
+	message, _ = sjson.Set(message, "message.model", w.model)
+	message, _ = sjson.Set(message, "message.usage.input_tokens", w.inputTokens)
+	w.event(c, "message_start", message)
+}
+
+// openBlock closes the current content block and starts a new one
+func (w *claudeStreamWriter) openBlock(c *gin.Context, blockType, contentBlock string) {
+	w.closeBlock(c)
+	w.blockIndex++
+	w.blockType = blockType
+	data, _ := sjson.Set(`{"type":"content_block_start","index":0}`, "index", w.blockIndex)
+	data, _ = sjson.SetRaw(data, "content_block", contentBlock)
+	w.event(c, "content_block_start", data)
+}
+
+func (w *claudeStreamWriter) closeBlock(c *gin.Context) {
+	if w.blockType == "" {
+		return
+	}
+	data, _ := sjson.Set(`{"type":"content_block_stop","index":0}`, "index", w.blockIndex)
+	w.event(c, "content_block_stop", data)
+	w.blockType = ""
+}
+
+func (w *claudeStreamWriter) delta(c *gin.Context, delta string) {
+	data, _ := sjson.Set(`{"type":"content_block_delta","index":0}`, "index", w.blockIndex)
+	data, _ = sjson.SetRaw(data, "delta", delta)
+	w.event(c, "content_block_delta", data)
+}
+
+func (w *claudeStreamWriter) Chunk(c *gin.Context, chunk string) {
+	if promptTokensResult := gjson.Get(chunk, "usage.prompt_tokens"); promptTokensResult.Exists() {
+		w.inputTokens = promptTokensResult.Int()
+	}
+	w.start(c)
+	if completionTokensResult := gjson.Get(chunk, "usage.completion_tokens"); completionTokensResult.Exists() {
+		w.outputTokens = completionTokensResult.Int()
+	}
+
+	delta := gjson.Get(chunk, "choices.0.delta")
+	if reasoningResult := delta.Get("reasoning_content"); reasoningResult.Type == gjson.String && reasoningResult.String() != "" {
+		if w.blockType != "thinking" {
+			w.openBlock(c, "thinking", `{"type":"thinking","thinking":""}`)
+		}
+		data, _ := sjson.Set(`{"type":"thinking_delta","thinking":""}`, "thinking", reasoningResult.String())
+		w.delta(c, data)
+	}
+	if contentResult := delta.Get("content"); contentResult.Type == gjson.String && contentResult.String() != "" {
+		if w.blockType != "text" {
+			w.openBlock(c, "text", `{"type":"text","text":""}`)
+		}
+		data, _ := sjson.Set(`{"type":"text_delta","text":""}`, "text", contentResult.String())
+		w.delta(c, data)
+	}
+	for _, toolCall := range delta.Get("tool_calls").Array() {
+		index := toolCall.Get("index").Int()
+		// The first delta of a tool call carries its id and name
+		if w.blockType != "tool_use" || index != w.toolIndex || toolCall.Get("id").String() != "" || toolCall.Get("function.name").String() != "" {
+			block, _ := sjson.Set(`{"type":"tool_use","id":"","name":"","input":{}}`, "id", toolCall.Get("id").String())
+			block, _ = sjson.Set(block, "name", toolCall.Get("function.name").String())
+			w.openBlock(c, "tool_use", block)
+			w.toolIndex = index
+		}
+		if arguments := toolCall.Get("function.arguments").String(); arguments != "" {
+			data, _ := sjson.Set(`{"type":"input_json_delta","partial_json":""}`, "partial_json", arguments)
+			w.delta(c, data)
+		}
+	}
+
+	if finishReasonResult := gjson.Get(chunk, "choices.0.finish_reason"); finishReasonResult.Type == gjson.String {
+		w.stopReason = claudeStopReason(finishReasonResult.String())
+	}
+}
+
+func (w *claudeStreamWriter) Error(c *gin.Context, chunk string) {
+	w.event(c, "error", claudeErrorJson("api_error", gjson.Get(chunk, "error.message").String()))
+}
+
+func (w *claudeStreamWriter) Done(c *gin.Context) {
+	if w.stopped {
+		return
+	}
+	w.stopped = true
+	w.start(c)
+	w.closeBlock(c)
+	// The final usage carries the input tokens of the task, message_start only had the estimate
+	data, _ := sjson.Set(`{"type":"message_delta","delta":{"stop_reason":"","stop_sequence":null},"usage":{"input_tokens":0,"output_tokens":0}}`, "delta.stop_reason", w.stopReason)
+	data, _ = sjson.Set(data, "usage.input_tokens", w.inputTokens)
+	data, _ = sjson.Set(data, "usage.output_tokens", w.outputTokens)
+	w.event(c, "message_delta", data)
+	w.event(c, "message_stop", `{"type":"message_stop"}`)
+}
+
+func (w *claudeStreamWriter) KeepAlive(c *gin.Context) {
+	w.event(c, "ping", `{"type":"ping"}`)
+}
@@ -0,0 +1,354 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/luispater/anyAIProxyAPI/internal/auth"
+	"github.com/luispater/anyAIProxyAPI/internal/browser/chrome"
+	"github.com/luispater/anyAIProxyAPI/internal/config"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// consoleIdleTimeout detaches an operator who sent no input for a while, so a closed console does not hold the page
+const consoleIdleTimeout = 10 * time.Minute
+
+const (
+	// consoleCookieName is the cookie authenticating the screencast of the console, issued by the attach action
+	consoleCookieName = "console_token"
+	consoleTokenTTL   = time.Hour
+)
+
+// consoleSession is an operator attached to the page of an instance. It holds the request lock of the page,
+// so no task runs on it until the operator detaches.
+type consoleSession struct {
+	page           *chrome.Page
+	authFile       string
+	timer          *time.Timer
+	token          string
+	tokenExpiresAt time.Time
+}
+
+// AdminConsole handles the GET /admin/instances/{name}/console endpoint. The page shows the live screencast
+// of the instance and forwards the mouse and keyboard input of the operator, to log in or solve a challenge.
+// It is served without the admin key, the operator enters it in the page.
+func (h *APIHandlers) AdminConsole(c *gin.Context) {
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("Cache-Control", "no-cache")
+	c.String(http.StatusOK, consoleHTML)
+}
+
+// AdminScreencast handles the GET /admin/instances/{name}/screencast endpoint
+func (h *APIHandlers) AdminScreencast(c *gin.Context) {
+	index, ok := h.adminInstance(c)
+	if !ok {
+		return
+	}
+	instanceName := h.appConfig.Instance[index].Name
+	page, ready := h.pages[instanceName]
+	if !ready {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("The page of instance %s is not open", instanceName),
+				Type:    "invalid_request_error",
+			},
+		})
+		return
+	}
+	h.streamScreencast(c, page, instanceName)
+}
+
+// AdminInput handles the POST /admin/instances/{name}/input endpoint, it forwards an input event of the
+// attached operator to the page
+func (h *APIHandlers) AdminInput(c *gin.Context) {
+	index, ok := h.adminInstance(c)
+	if !ok {
+		return
+	}
+	instanceName := h.appConfig.Instance[index].Name
+
+	h.consoleMu.Lock()
+	session, attached := h.consoles[instanceName]
+	if attached {
+		session.timer.Reset(consoleIdleTimeout)
+	}
+	h.consoleMu.Unlock()
+	if !attached {
+		c.JSON(http.StatusConflict, ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("Attach to instance %s before sending input", instanceName),
+				Type:    "invalid_request_error",
+				Code:    "not_attached",
+			},
+		})
+		return
+	}
+
+	rawJson, err := c.GetRawData()
+	if err != nil || !gjson.ValidBytes(rawJson) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetail{
+				Message: "Invalid input event",
+				Type:    "invalid_request_error",
+			},
+		})
+		return
+	}
+	event := chrome.InputEvent{
+		Type:       gjson.GetBytes(rawJson, "type").String(),
+		X:          gjson.GetBytes(rawJson, "x").Float(),
+		Y:          gjson.GetBytes(rawJson, "y").Float(),
+		Button:     gjson.GetBytes(rawJson, "button").String(),
+		ClickCount: gjson.GetBytes(rawJson, "click_count").Int(),
+		DeltaX:     gjson.GetBytes(rawJson, "delta_x").Float(),
+		DeltaY:     gjson.GetBytes(rawJson, "delta_y").Float(),
+		Key:        gjson.GetBytes(rawJson, "key").String(),
+		Modifiers:  gjson.GetBytes(rawJson, "modifiers").Int(),
+	}
+	if err = chrome.DispatchInput(session.page.GetContext(), event); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("Failed to dispatch input: %v", err),
+				Type:    "invalid_request_error",
+			},
+		})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// attachConsole takes the page of an instance out of routing for an operator. It answers 409
+// when a task runs on the page or another operator is attached.
+func (h *APIHandlers) attachConsole(c *gin.Context, instanceConfig config.AppConfigInstance) error {
+	page, ready := h.pages[instanceConfig.Name]
+	if !ready {
+		return fmt.Errorf("the page of instance %s is not open", instanceConfig.Name)
+	}
+
+	h.consoleMu.Lock()
+	defer h.consoleMu.Unlock()
+	if _, attached := h.consoles[instanceConfig.Name]; attached {
+		c.JSON(http.StatusConflict, ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("An operator is already attached to instance %s", instanceConfig.Name),
+				Type:    "invalid_request_error",
+				Code:    "already_attached",
+			},
+		})
+		return nil
+	}
+	if !page.RequestMutex.TryLock() {
+		c.JSON(http.StatusConflict, ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("Instance %s is busy with a task, pause it and retry when the task is finished", instanceConfig.Name),
+				Type:    "invalid_request_error",
+				Code:    "instance_busy",
+			},
+		})
+		return nil
+	}
+
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		page.RequestMutex.Unlock()
+		return err
+	}
+	token := hex.EncodeToString(tokenBytes)
+
+	instanceName := instanceConfig.Name
+	h.consoles[instanceName] = &consoleSession{
+		page:           page,
+		authFile:       instanceConfig.Auth.File,
+		token:          token,
+		tokenExpiresAt: time.Now().Add(consoleTokenTTL),
+		timer: time.AfterFunc(consoleIdleTimeout, func() {
+			log.Warnf("No console input on instance %s for %s, detaching", instanceName, consoleIdleTimeout)
+			if _, err := h.releaseConsole(instanceName); err != nil {
+				log.Errorf("Failed to save the auth of instance %s: %v", instanceName, err)
+			}
+		}),
+	}
+	h.instances.SetAttached(instanceName, true)
+	setConsoleCookie(c, instanceName, token, int(consoleTokenTTL.Seconds()))
+	log.Infof("Operator attached to instance %s", instanceName)
+	return nil
+}
+
+// setConsoleCookie sets the console cookie for the paths of an instance, a negative max age deletes it
+func setConsoleCookie(c *gin.Context, instanceName, token string, maxAge int) {
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(consoleCookieName, token, maxAge, "/admin/instances/"+url.PathEscape(instanceName)+"/", "", c.Request.TLS != nil, true)
+}
+
+// validConsoleToken reports whether a token is the unexpired console token of the operator attached to an instance
+func (h *APIHandlers) validConsoleToken(instanceName, token string) bool {
+	h.consoleMu.Lock()
+	defer h.consoleMu.Unlock()
+	session, attached := h.consoles[instanceName]
+	return attached && time.Now().Before(session.tokenExpiresAt) && auth.Equal(token, session.token)
+}
+
+// detachConsole ends the console session of an instance, it answers 409 when no operator is attached
+func (h *APIHandlers) detachConsole(c *gin.Context, instanceName string) error {
+	setConsoleCookie(c, instanceName, "", -1)
+	attached, err := h.releaseConsole(instanceName)
+	if !attached {
+		c.JSON(http.StatusConflict, ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("No operator is attached to instance %s", instanceName),
+				Type:    "invalid_request_error",
+				Code:    "not_attached",
+			},
+		})
+		return nil
+	}
+	return err
+}
+
+// releaseConsole saves the auth of an attached page and gives the page back to the tasks.
+// It reports whether an operator was attached.
+func (h *APIHandlers) releaseConsole(instanceName string) (bool, error) {
+	h.consoleMu.Lock()
+	session, attached := h.consoles[instanceName]
+	delete(h.consoles, instanceName)
+	h.consoleMu.Unlock()
+	if !attached {
+		return false, nil
+	}
+	session.timer.Stop()
+	defer func() {
+		session.page.RequestMutex.Unlock()
+		h.instances.SetAttached(instanceName, false)
+		log.Infof("Operator detached from instance %s", instanceName)
+	}()
+
+	if session.authFile == "" {
+		return true, nil
+	}
+	if err := chrome.SaveAuthInfo(session.page.GetContext(), session.authFile); err != nil {
+		return true, err
+	}
+	h.instances.SetAuthSaved(instanceName)
+	return true, nil
+}
+
+// consoleHTML is the operator console. It reads the instance from its path, the admin key is entered by the
+// operator and only sent in headers. The screencast is shown once attached, with the cookie of the attach action.
+const consoleHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Console</title>
+<style>
+body { margin: 0; font-family: sans-serif; background: #202124; color: #e8eaed; }
+header { display: flex; gap: 8px; align-items: center; padding: 8px; }
+#status { flex: 1; }
+#screen { display: block; max-width: 100%; margin: 0 auto; outline: none; cursor: default; }
+#screen:focus { box-shadow: 0 0 0 2px #8ab4f8; }
+</style>
+</head>
+<body>
+<header>
+<strong id="name"></strong>
+<input id="key" type="password" placeholder="Admin key" autocomplete="off">
+<span id="status"></span>
+<button id="attach">Attach</button>
+<button id="detach">Detach and save auth</button>
+</header>
+<img id="screen" tabindex="0" draggable="false" alt="">
+<script>
+const base = location.pathname.replace(/\/console\/?$/, "");
+const keyInput = document.getElementById("key");
+const screen = document.getElementById("screen");
+const status = document.getElementById("status");
+document.getElementById("name").textContent = decodeURIComponent(base.split("/").pop());
+keyInput.addEventListener("change", () => refresh());
+
+async function call(path, body) {
+	const response = await fetch(base + path, {
+		method: "POST",
+		headers: {"Authorization": "Bearer " + keyInput.value, "Content-Type": "application/json"},
+		body: body ? JSON.stringify(body) : undefined,
+	});
+	if (!response.ok) {
+		const error = await response.json().catch(() => ({}));
+		status.textContent = (error.error && error.error.message) || response.statusText;
+	}
+	return response;
+}
+
+async function refresh() {
+	if (!keyInput.value) {
+		status.textContent = "enter the admin key";
+		return;
+	}
+	const response = await fetch(base, {headers: {"Authorization": "Bearer " + keyInput.value}});
+	if (!response.ok) {
+		status.textContent = response.status === 401 ? "incorrect admin key" : response.statusText;
+		return;
+	}
+	const instance = await response.json();
+	if (!instance.attached && screen.src) screen.removeAttribute("src");
+	status.textContent = (instance.attached ? "attached" : "not attached") +
+		(instance.paused ? ", paused" : "") +
+		(instance.auth.logged_in === false ? ", logged out" : "");
+}
+
+function modifiers(event) {
+	return (event.altKey ? 1 : 0) | (event.ctrlKey ? 2 : 0) | (event.metaKey ? 4 : 0) | (event.shiftKey ? 8 : 0);
+}
+
+function position(event) {
+	const rect = screen.getBoundingClientRect();
+	return {x: (event.clientX - rect.left) / rect.width, y: (event.clientY - rect.top) / rect.height};
+}
+
+const buttons = ["left", "middle", "right"];
+screen.addEventListener("mousedown", (event) => {
+	event.preventDefault();
+	screen.focus();
+	call("/input", {type: "mousedown", button: buttons[event.button], click_count: event.detail, modifiers: modifiers(event), ...position(event)});
+});
+screen.addEventListener("mouseup", (event) => {
+	call("/input", {type: "mouseup", button: buttons[event.button], click_count: event.detail, modifiers: modifiers(event), ...position(event)});
+});
+let lastMove = 0;
+screen.addEventListener("mousemove", (event) => {
+	if (Date.now() - lastMove < 100) return;
+	lastMove = Date.now();
+	call("/input", {type: "mousemove", modifiers: modifiers(event), ...position(event)});
+});
+screen.addEventListener("wheel", (event) => {
+	event.preventDefault();
+	call("/input", {type: "wheel", delta_x: event.deltaX, delta_y: event.deltaY, modifiers: modifiers(event), ...position(event)});
+}, {passive: false});
+screen.addEventListener("contextmenu", (event) => event.preventDefault());
+screen.addEventListener("keydown", (event) => {
+	if (["Shift", "Control", "Alt", "Meta"].includes(event.key)) return;
+	event.preventDefault();
+	call("/input", {type: "key", key: event.key, modifiers: modifiers(event)});
+});
+
+document.getElementById("attach").onclick = async () => {
+	if ((await call("/attach")).ok) {
+		screen.src = base + "/screencast";
+		refresh();
+	}
+};
+document.getElementById("detach").onclick = async () => {
+	if ((await call("/detach")).ok) {
+		screen.removeAttribute("src");
+		refresh();
+	}
+};
+refresh();
+setInterval(refresh, 5000);
+</script>
+</body>
+</html>
+`
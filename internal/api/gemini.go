@@ -0,0 +1,445 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"net/http"
+	"strings"
+)
+
+// GeminiModels handles the Gemini compatible /v1beta/models endpoint
+func (h *APIHandlers) GeminiModels(c *gin.Context) {
+	models := `{"models":[]}`
+	for _, model := range h.listModels(c) {
+		models, _ = sjson.SetRaw(models, "models.-1", geminiModel(model))
+	}
+	c.Header("Content-Type", "application/json")
+	c.String(http.StatusOK, models)
+}
+
+// GeminiGetModel handles the Gemini compatible /v1beta/models/{instance}/{model} endpoint
+func (h *APIHandlers) GeminiGetModel(c *gin.Context) {
+	modelID := strings.TrimPrefix(c.Param("action"), "/")
+	for _, model := range h.listModels(c) {
+		if model.ID == modelID {
+			c.Header("Content-Type", "application/json")
+			c.String(http.StatusOK, geminiModel(model))
+			return
+		}
+	}
+	writeGeminiError(c, http.StatusNotFound, fmt.Sprintf("models/%s is not found", modelID))
+}
+
+// GeminiGenerateContent handles the Gemini compatible /v1beta/models/{instance}/{model}:generateContent
+// and :streamGenerateContent endpoints
+func (h *APIHandlers) GeminiGenerateContent(c *gin.Context) {
+	action := strings.TrimPrefix(c.Param("action"), "/")
+	separatorIndex := strings.LastIndex(action, ":")
+	if separatorIndex == -1 {
+		writeGeminiError(c, http.StatusNotFound, fmt.Sprintf("unknown method %s", action))
+		return
+	}
+	model := action[:separatorIndex]
+	method := action[separatorIndex+1:]
+	if method != "generateContent" && method != "streamGenerateContent" {
+		writeGeminiError(c, http.StatusNotFound, fmt.Sprintf("unknown method %s", method))
+		return
+	}
+	stream := method == "streamGenerateContent"
+
+	rawJson, err := c.GetRawData()
+	if err != nil {
+		writeGeminiError(c, http.StatusBadRequest, fmt.Sprintf("Invalid request: %v", err))
+		return
+	}
+
+	chatJson, err := convertGeminiRequest(model, stream, rawJson)
+	if err != nil {
+		writeGeminiError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	task, status, errResponse := h.dispatchTask(c, []byte(chatJson))
+	if errResponse != nil {
+		writeGeminiError(c, status, errResponse.Error.Message)
+		return
+	}
+	defer task.Release()
+
+	h.writeResponse(c, task, &geminiWriter{
+		model:  model,
+		stream: stream,
+		sse:    c.Query("alt") == "sse",
+	})
+}
+
+// geminiModel builds a Gemini model object
+func geminiModel(model ModelObject) string {
+	result := `{"name":"","displayName":"","supportedGenerationMethods":["generateContent","streamGenerateContent"]}`
+	result, _ = sjson.Set(result, "name", "models/"+model.ID)
+	result, _ = sjson.Set(result, "displayName", model.ID)
+	return result
+}
+
+// convertGeminiRequest converts a Gemini generateContent request into the internal chat completion request
+func convertGeminiRequest(model string, stream bool, rawJson []byte) (string, error) {
+	root := gjson.ParseBytes(rawJson)
+	if !root.Get("contents").IsArray() {
+		return "", fmt.Errorf("contents is required")
+	}
+
+	chatJson := `{"model":"","messages":[]}`
+	chatJson, _ = sjson.Set(chatJson, "model", model)
+	if stream {
+		chatJson, _ = sjson.Set(chatJson, "stream", true)
+	}
+
+	generationConfig := root.Get("generationConfig")
+	if temperatureResult := generationConfig.Get("temperature"); temperatureResult.Type == gjson.Number {
+		chatJson, _ = sjson.Set(chatJson, "temperature", temperatureResult.Float())
+	}
+	if topPResult := generationConfig.Get("topP"); topPResult.Type == gjson.Number {
+		chatJson, _ = sjson.Set(chatJson, "top_p", topPResult.Float())
+	}
+	if maxTokensResult := generationConfig.Get("maxOutputTokens"); maxTokensResult.Type == gjson.Number {
+		chatJson, _ = sjson.Set(chatJson, "max_tokens", maxTokensResult.Int())
+	}
+	if stopResult := generationConfig.Get("stopSequences"); stopResult.IsArray() {
+		stops := stopResult.Array()
+		if len(stops) == 1 {
+			chatJson, _ = sjson.Set(chatJson, "stop", stops[0].String())
+		} else if len(stops) > 1 {
+			chatJson, _ = sjson.SetRaw(chatJson, "stop", stopResult.Raw)
+		}
+	}
+	if budgetResult := generationConfig.Get("thinkingConfig.thinkingBudget"); budgetResult.Type == gjson.Number && budgetResult.Int() != 0 {
+		chatJson, _ = sjson.Set(chatJson, "reasoning_effort", reasoningEffortFromBudget(budgetResult.Int()))
+	}
+
+	// System instruction
+	systemResult := root.Get("systemInstruction")
+	if !systemResult.Exists() {
+		systemResult = root.Get("system_instruction")
+	}
+	if systemText := geminiPartsText(systemResult.Get("parts")); systemText != "" {
+		chatJson, _ = sjson.SetRaw(chatJson, "messages.-1", systemMessage(systemText))
+	}
+
+	// Gemini pairs function calls and responses by name, OpenAI by id
+	callIDs := make(map[string][]string)
+	callCount := 0
+	for _, content := range root.Get("contents").Array() {
+		role := content.Get("role").String()
+		if role == "model" {
+			role = "assistant"
+		} else {
+			role = "user"
+		}
+
+		message, _ := sjson.Set(`{"role":"","content":[]}`, "role", role)
+		hasContent := false
+		for _, part := range content.Get("parts").Array() {
+			if part.Get("thought").Type == gjson.True {
+				continue
+			}
+			if textResult := part.Get("text"); textResult.Type == gjson.String {
+				contentPart, _ := sjson.Set(`{"type":"text","text":""}`, "text", textResult.String())
+				message, _ = sjson.SetRaw(message, "content.-1", contentPart)
+				hasContent = true
+			} else if inlineDataResult := part.Get("inlineData"); inlineDataResult.IsObject() {
+				imageURL := fmt.Sprintf("data:%s;base64,%s", inlineDataResult.Get("mimeType").String(), inlineDataResult.Get("data").String())
+				contentPart, _ := sjson.Set(`{"type":"image_url","image_url":{"url":""}}`, "image_url.url", imageURL)
+				message, _ = sjson.SetRaw(message, "content.-1", contentPart)
+				hasContent = true
+			} else if fileDataResult := part.Get("fileData"); fileDataResult.IsObject() {
+				contentPart, _ := sjson.Set(`{"type":"image_url","image_url":{"url":""}}`, "image_url.url", fileDataResult.Get("fileUri").String())
+				message, _ = sjson.SetRaw(message, "content.-1", contentPart)
+				hasContent = true
+			} else if functionCallResult := part.Get("functionCall"); functionCallResult.IsObject() {
+				name := functionCallResult.Get("name").String()
+				callID := functionCallResult.Get("id").String()
+				if callID == "" {
+					callCount++
+					callID = fmt.Sprintf("call_%s_%d", name, callCount)
+				}
+				callIDs[name] = append(callIDs[name], callID)
+
+				arguments := functionCallResult.Get("args").Raw
+				if arguments == "" {
+					arguments = "{}"
+				}
+				toolCall, _ := sjson.Set(`{"id":"","type":"function","function":{"name":"","arguments":""}}`, "id", callID)
+				toolCall, _ = sjson.Set(toolCall, "function.name", name)
+				toolCall, _ = sjson.Set(toolCall, "function.arguments", arguments)
+				message, _ = sjson.SetRaw(message, "tool_calls.-1", toolCall)
+			} else if functionResponseResult := part.Get("functionResponse"); functionResponseResult.IsObject() {
+				name := functionResponseResult.Get("name").String()
+				callID := functionResponseResult.Get("id").String()
+				if callID == "" && len(callIDs[name]) > 0 {
+					callID = callIDs[name][0]
+					callIDs[name] = callIDs[name][1:]
+				}
+				toolMessage, _ := sjson.Set(`{"role":"tool","tool_call_id":"","content":""}`, "tool_call_id", callID)
+				toolMessage, _ = sjson.Set(toolMessage, "name", name)
+				toolMessage, _ = sjson.Set(toolMessage, "content", functionResponseResult.Get("response").Raw)
+				chatJson, _ = sjson.SetRaw(chatJson, "messages.-1", toolMessage)
+			}
+		}
+
+		if hasContent || gjson.Get(message, "tool_calls").Exists() {
+			if !hasContent {
+				message, _ = sjson.Set(message, "content", nil)
+			}
+			chatJson, _ = sjson.SetRaw(chatJson, "messages.-1", message)
+		}
+	}
+
+	// Tools
+	for _, tool := range root.Get("tools").Array() {
+		declarations := tool.Get("functionDeclarations")
+		if !declarations.Exists() {
+			declarations = tool.Get("function_declarations")
+		}
+		for _, declaration := range declarations.Array() {
+			function := `{"type":"function","function":{"name":""}}`
+			function, _ = sjson.Set(function, "function.name", declaration.Get("name").String())
+			if descriptionResult := declaration.Get("description"); descriptionResult.Type == gjson.String {
+				function, _ = sjson.Set(function, "function.description", descriptionResult.String())
+			}
+			if parametersResult := declaration.Get("parameters"); parametersResult.IsObject() {
+				function, _ = sjson.SetRaw(function, "function.parameters", parametersResult.Raw)
+			} else if parametersResult = declaration.Get("parametersJsonSchema"); parametersResult.IsObject() {
+				function, _ = sjson.SetRaw(function, "function.parameters", parametersResult.Raw)
+			}
+			chatJson, _ = sjson.SetRaw(chatJson, "tools.-1", function)
+		}
+	}
+	functionCallingConfig := root.Get("toolConfig.functionCallingConfig")
+	switch functionCallingConfig.Get("mode").String() {
+	case "AUTO":
+		chatJson, _ = sjson.Set(chatJson, "tool_choice", "auto")
+	case "NONE":
+		chatJson, _ = sjson.Set(chatJson, "tool_choice", "none")
+	case "ANY":
+		allowedNames := functionCallingConfig.Get("allowedFunctionNames").Array()
+		if len(allowedNames) == 1 {
+			toolChoice, _ := sjson.Set(`{"type":"function","function":{"name":""}}`, "function.name", allowedNames[0].String())
+			chatJson, _ = sjson.SetRaw(chatJson, "tool_choice", toolChoice)
+		} else {
+			chatJson, _ = sjson.Set(chatJson, "tool_choice", "required")
+		}
+	}
+
+	return chatJson, nil
+}
+
+// reasoningEffortFromBudget maps a thinking token budget onto an OpenAI reasoning effort
+func reasoningEffortFromBudget(budgetTokens int64) string {
+	if budgetTokens < 0 {
+		return "medium"
+	} else if budgetTokens <= 1024 {
+		return "low"
+	} else if budgetTokens <= 8192 {
+		return "medium"
+	}
+	return "high"
+}
+
+// geminiPartsText joins the text parts of a Gemini content
+func geminiPartsText(parts gjson.Result) string {
+	texts := make([]string, 0)
+	for _, part := range parts.Array() {
+		if textResult := part.Get("text"); textResult.Type == gjson.String {
+			texts = append(texts, textResult.String())
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// geminiFinishReason converts an OpenAI finish reason into a Gemini finish reason
+func geminiFinishReason(finishReason string) string {
+	if finishReason == "length" {
+		return "MAX_TOKENS"
+	}
+	return "STOP"
+}
+
+// geminiErrorJson builds a Gemini error json
+func geminiErrorJson(status int, message string) string {
+	errorJson, _ := sjson.Set(`{"error":{"code":0,"message":"","status":""}}`, "error.code", status)
+	errorJson, _ = sjson.Set(errorJson, "error.message", message)
+	errorStatus := "INTERNAL"
+	switch status {
+	case http.StatusBadRequest:
+		errorStatus = "INVALID_ARGUMENT"
+	case http.StatusUnauthorized:
+		errorStatus = "UNAUTHENTICATED"
+	case http.StatusForbidden:
+		errorStatus = "PERMISSION_DENIED"
+	case http.StatusNotFound:
+		errorStatus = "NOT_FOUND"
+	case http.StatusTooManyRequests:
+		errorStatus = "RESOURCE_EXHAUSTED"
+	case http.StatusServiceUnavailable:
+		errorStatus = "UNAVAILABLE"
+	case http.StatusRequestTimeout:
+		errorStatus = "DEADLINE_EXCEEDED"
+	}
+	errorJson, _ = sjson.Set(errorJson, "error.status", errorStatus)
+	return errorJson
+}
+
+func writeGeminiError(c *gin.Context, status int, message string) {
+	c.Header("Content-Type", "application/json")
+	c.String(status, geminiErrorJson(status, message))
+}
+
+// geminiToolCall is a tool call accumulated from chat completion chunks
+type geminiToolCall struct {
+	name      string
+	arguments string
+}
+
+// geminiWriter converts the chat processor output into Gemini generateContent responses
+type geminiWriter struct {
+	model     string
+	stream    bool
+	sse       bool
+	written   int
+	failed    bool
+	toolCalls []*geminiToolCall
+}
+
+func (w *geminiWriter) Begin(c *gin.Context) {
+	if w.stream && w.sse {
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("Access-Control-Allow-Origin", "*")
+	} else {
+		c.Header("Content-Type", "application/json")
+	}
+}
+
+// write writes a response, streamed responses are either server-sent events or elements of a json array
+func (w *geminiWriter) write(c *gin.Context, response string) {
+	if !w.stream {
+		c.Status(http.StatusOK)
+		_, _ = c.Writer.Write([]byte(response))
+	} else if w.sse {
+		_, _ = fmt.Fprintf(c.Writer, "data: %s\n\n", response)
+	} else if w.written == 0 {
+		_, _ = fmt.Fprintf(c.Writer, "[%s", response)
+	} else {
+		_, _ = fmt.Fprintf(c.Writer, ",\n%s", response)
+	}
+	w.written++
+}
+
+// candidate builds a response with a single candidate
+func (w *geminiWriter) candidate(parts []string, finishReason string, usage gjson.Result) string {
+	response := `{"candidates":[{"content":{"role":"model","parts":[]},"index":0}],"modelVersion":""}`
+	response, _ = sjson.Set(response, "modelVersion", w.model)
+	for _, part := range parts {
+		response, _ = sjson.SetRaw(response, "candidates.0.content.parts.-1", part)
+	}
+	if finishReason != "" {
+		response, _ = sjson.Set(response, "candidates.0.finishReason", finishReason)
+	}
+	if usage.IsObject() {
+		response, _ = sjson.Set(response, "usageMetadata.promptTokenCount", usage.Get("prompt_tokens").Int())
+		response, _ = sjson.Set(response, "usageMetadata.candidatesTokenCount", usage.Get("completion_tokens").Int())
+		response, _ = sjson.Set(response, "usageMetadata.totalTokenCount", usage.Get("total_tokens").Int())
+	}
+	return response
+}
+
+// functionCallParts builds the functionCall parts of the accumulated tool calls
+func (w *geminiWriter) functionCallParts() []string {
+	parts := make([]string, 0, len(w.toolCalls))
+	for _, toolCall := range w.toolCalls {
+		part, _ := sjson.Set(`{"functionCall":{"name":"","args":{}}}`, "functionCall.name", toolCall.name)
+		if arguments := gjson.Parse(toolCall.arguments); arguments.IsObject() {
+			part, _ = sjson.SetRaw(part, "functionCall.args", arguments.Raw)
+		}
+		parts = append(parts, part)
+	}
+	w.toolCalls = nil
+	return parts
+}
+
+func (w *geminiWriter) Chunk(c *gin.Context, chunk string) {
+	delta := gjson.Get(chunk, "choices.0.delta")
+	if !w.stream {
+		delta = gjson.Get(chunk, "choices.0.message")
+	}
+
+	parts := make([]string, 0)
+	if reasoningResult := delta.Get("reasoning_content"); reasoningResult.Type == gjson.String && reasoningResult.String() != "" {
+		part, _ := sjson.Set(`{"text":"","thought":true}`, "text", reasoningResult.String())
+		parts = append(parts, part)
+	}
+	if contentResult := delta.Get("content"); contentResult.Type == gjson.String && contentResult.String() != "" {
+		part, _ := sjson.Set(`{"text":""}`, "text", contentResult.String())
+		parts = append(parts, part)
+	}
+
+	// Gemini function calls are not streamed, collect the argument deltas until the call is complete
+	for _, toolCall := range delta.Get("tool_calls").Array() {
+		if toolCall.Get("id").String() != "" || toolCall.Get("function.name").String() != "" || len(w.toolCalls) == 0 {
+			w.toolCalls = append(w.toolCalls, &geminiToolCall{name: toolCall.Get("function.name").String()})
+		}
+		current := w.toolCalls[len(w.toolCalls)-1]
+		current.arguments = current.arguments + toolCall.Get("function.arguments").String()
+	}
+
+	finishReasonResult := gjson.Get(chunk, "choices.0.finish_reason")
+	finishReason := ""
+	if finishReasonResult.Type == gjson.String {
+		finishReason = geminiFinishReason(finishReasonResult.String())
+		parts = append(parts, w.functionCallParts()...)
+	}
+
+	if len(parts) > 0 || finishReason != "" {
+		w.write(c, w.candidate(parts, finishReason, gjson.Get(chunk, "usage")))
+	}
+}
+
+func (w *geminiWriter) Error(c *gin.Context, chunk string) {
+	w.failed = true
+	errorJson := geminiErrorJson(http.StatusInternalServerError, gjson.Get(chunk, "error.message").String())
+	if w.stream && w.written > 0 {
+		w.write(c, errorJson)
+		if !w.sse {
+			_, _ = c.Writer.Write([]byte("]"))
+		}
+		return
+	}
+	c.Status(http.StatusInternalServerError)
+	_, _ = c.Writer.Write([]byte(errorJson))
+}
+
+func (w *geminiWriter) Done(c *gin.Context) {
+	if w.failed {
+		return
+	}
+	if len(w.toolCalls) > 0 {
+		w.write(c, w.candidate(w.functionCallParts(), "STOP", gjson.Result{}))
+	}
+	if w.stream && !w.sse {
+		if w.written == 0 {
+			_, _ = c.Writer.Write([]byte("["))
+		}
+		_, _ = c.Writer.Write([]byte("]"))
+	}
+}
+
+func (w *geminiWriter) KeepAlive(c *gin.Context) {
+	if w.stream && w.sse {
+		_, _ = c.Writer.Write([]byte(": ANY-AI-PROXY-API PROCESSING\n\n"))
+	} else {
+		_, _ = c.Writer.Write([]byte("\n"))
+	}
+}
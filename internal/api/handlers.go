@@ -1,14 +1,24 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"github.com/chromedp/chromedp"
+	"github.com/luispater/anyAIProxyAPI/internal/auth"
+	"github.com/luispater/anyAIProxyAPI/internal/batch"
+	"github.com/luispater/anyAIProxyAPI/internal/blob"
 	"github.com/luispater/anyAIProxyAPI/internal/browser/chrome"
+	"github.com/luispater/anyAIProxyAPI/internal/cache"
 	"github.com/luispater/anyAIProxyAPI/internal/config"
+	"github.com/luispater/anyAIProxyAPI/internal/fetch"
+	"github.com/luispater/anyAIProxyAPI/internal/instance"
+	"github.com/luispater/anyAIProxyAPI/internal/ratelimit"
 	"github.com/luispater/anyAIProxyAPI/internal/runner"
 	log "github.com/sirupsen/logrus"
 	"github.com/tidwall/gjson"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -19,47 +29,208 @@ import (
 
 var ScreenshotMutex sync.Mutex
 
+// apiKeyContextKey is the gin context key of the authenticated API key
+const apiKeyContextKey = "api-key"
+
+// apiKeyFromContext returns the API key of the request, nil when authentication is disabled
+func apiKeyFromContext(c *gin.Context) *auth.Key {
+	if value, ok := c.Get(apiKeyContextKey); ok {
+		if key, isKey := value.(*auth.Key); isKey {
+			return key
+		}
+	}
+	return nil
+}
+
 // APIHandlers contains the handlers for API endpoints
 type APIHandlers struct {
-	queue     *RequestQueue
-	pages     map[string]*chrome.Page
-	debug     bool
-	appConfig *config.AppConfig
+	queue         *RequestQueue
+	pages         map[string]*chrome.Page
+	debug         bool
+	appConfig     *config.AppConfig
+	created       int64
+	limiter       *ratelimit.Limiter
+	authenticator *auth.Authenticator
+	batches       *batch.Store
+	batchSlots    map[string]chan struct{}
+	batchMu       sync.Mutex
+	batchCancels  map[string]context.CancelFunc
+	tasks         *taskRegistry
+	browser       *chrome.Manager
+	instances     *instance.Registry
+	consoleMu     sync.Mutex
+	consoles      map[string]*consoleSession
+	cache         *cache.Cache
+	images        *blob.Store
+	fetcher       *fetch.Fetcher
+	modelsMu      sync.RWMutex
+	models        map[string][]string
 }
 
 // NewAPIHandlers creates a new API handlers instance
-func NewAPIHandlers(appConfig *config.AppConfig, queue *RequestQueue, pages map[string]*chrome.Page, debug bool) *APIHandlers {
+func NewAPIHandlers(appConfig *config.AppConfig, queue *RequestQueue, pages map[string]*chrome.Page, browser *chrome.Manager, instances *instance.Registry, authenticator *auth.Authenticator, debug bool) *APIHandlers {
+	batchDir := appConfig.BatchDir
+	if batchDir == "" {
+		batchDir = "batches"
+	}
+	// Batch requests of an instance share its slots, whatever batch they belong to
+	batchSlots := make(map[string]chan struct{})
+	// The model catalogs are reloaded at runtime, so they are kept apart from the shared configuration
+	models := make(map[string][]string)
+	for _, instance := range appConfig.Instance {
+		batchSlots[instance.Name] = make(chan struct{}, max(1, instance.BatchConcurrency))
+		models[instance.Name] = instance.Models
+	}
+	imagesDir := appConfig.Images.Dir
+	if imagesDir == "" {
+		imagesDir = "images"
+	}
+	imagesTTL := time.Duration(appConfig.Images.TTLSeconds) * time.Second
+	if imagesTTL <= 0 {
+		imagesTTL = time.Hour
+	}
+
 	return &APIHandlers{
-		queue:     queue,
-		pages:     pages,
-		debug:     debug,
-		appConfig: appConfig,
+		queue:         queue,
+		pages:         pages,
+		debug:         debug,
+		appConfig:     appConfig,
+		created:       time.Now().Unix(),
+		limiter:       ratelimit.NewLimiter(),
+		authenticator: authenticator,
+		batches:       batch.NewStore(batchDir),
+		batchSlots:    batchSlots,
+		batchCancels:  make(map[string]context.CancelFunc),
+		tasks:         newTaskRegistry(),
+		browser:       browser,
+		instances:     instances,
+		consoles:      make(map[string]*consoleSession),
+		cache:         cache.New(appConfig.Cache),
+		images:        blob.NewStore(imagesDir, imagesTTL),
+		fetcher:       fetch.New(appConfig.Fetch),
+		models:        models,
 	}
 }
 
 func (h *APIHandlers) TakeScreenshot(c *gin.Context) {
 	defer ScreenshotMutex.Unlock()
 	ScreenshotMutex.Lock()
-	instanceName, ok := c.GetQuery("name")
+	page, ok := h.screenPage(c)
 	if !ok {
-		c.Status(http.StatusNotFound)
 		return
 	}
-	if page, hasKey := h.pages[instanceName]; hasKey {
-		var buf []byte
-		pageCtx := page.GetContext()
-		if err := chromedp.Run(pageCtx, chromedp.CaptureScreenshot(&buf)); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to take screenshot: %v", err), "code": 500})
+
+	options := chrome.ScreenshotOptions{
+		Format:   c.DefaultQuery("format", "png"),
+		FullPage: c.Query("full_page") == "true",
+		Selector: c.Query("selector"),
+	}
+	if quality := c.Query("quality"); quality != "" {
+		var err error
+		if options.Quality, err = strconv.Atoi(quality); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "quality must be a number between 0 and 100", "code": 400})
 			return
 		}
-		c.Header("Content-Type", "image/png")
-		c.Header("Cache-Control", "no-cache")
-		c.Header("Connection", "keep-alive")
-		c.Header("Access-Control-Allow-Origin", "*")
-		_, _ = c.Writer.Write(buf)
-	} else {
+	}
+
+	buf, err := chrome.CaptureScreenshot(page.GetContext(), options)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to take screenshot: %v", err), "code": 500})
+		return
+	}
+	c.Header("Content-Type", options.ContentType())
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("Access-Control-Allow-Origin", "*")
+	_, _ = c.Writer.Write(buf)
+}
+
+// screenPage returns the page of the instance in the name query parameter, if the API key may use it
+func (h *APIHandlers) screenPage(c *gin.Context) (*chrome.Page, bool) {
+	instanceName, ok := c.GetQuery("name")
+	if !ok {
+		c.Status(http.StatusNotFound)
+		return nil, false
+	}
+	if !apiKeyFromContext(c).AllowsInstance(instanceName) {
+		c.JSON(http.StatusForbidden, ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("The API key is not allowed to use instance %s", instanceName),
+				Type:    "permission_error",
+				Code:    "instance_not_allowed",
+			},
+		})
+		return nil, false
+	}
+	page, hasKey := h.pages[instanceName]
+	if !hasKey {
 		c.Status(http.StatusNotFound)
+		return nil, false
+	}
+	return page, true
+}
+
+// Models handles the /v1/models endpoint
+func (h *APIHandlers) Models(c *gin.Context) {
+	c.JSON(http.StatusOK, ModelList{
+		Object: "list",
+		Data:   h.listModels(c),
+	})
+}
+
+// RetrieveModel handles the /v1/models/{instance}/{model} endpoint
+func (h *APIHandlers) RetrieveModel(c *gin.Context) {
+	modelID := strings.TrimPrefix(c.Param("model"), "/")
+	for _, model := range h.listModels(c) {
+		if model.ID == modelID {
+			c.JSON(http.StatusOK, model)
+			return
+		}
+	}
+	c.JSON(http.StatusNotFound, ErrorResponse{
+		Error: ErrorDetail{
+			Message: fmt.Sprintf("The model '%s' does not exist", modelID),
+			Type:    "invalid_request_error",
+			Code:    "model_not_found",
+		},
+	})
+}
+
+// listModels builds the model list from the configured instances and their model catalogs,
+// limited to the models the API key of the request may use.
+// Model ids use the "instance/model" form the chat processor expects.
+func (h *APIHandlers) listModels(c *gin.Context) []ModelObject {
+	key := apiKeyFromContext(c)
+	models := make([]ModelObject, 0)
+	for i := 0; i < len(h.appConfig.Instance); i++ {
+		instance := h.appConfig.Instance[i]
+		for _, modelName := range h.instanceModels(instance.Name) {
+			if !key.AllowsModel(instance.Name + "/" + modelName) {
+				continue
+			}
+			models = append(models, ModelObject{
+				ID:      instance.Name + "/" + modelName,
+				Object:  "model",
+				Created: h.created,
+				OwnedBy: instance.Name,
+			})
+		}
 	}
+	return models
+}
+
+// instanceModels returns the model catalog of an instance
+func (h *APIHandlers) instanceModels(instanceName string) []string {
+	h.modelsMu.RLock()
+	defer h.modelsMu.RUnlock()
+	return h.models[instanceName]
+}
+
+// setInstanceModels replaces the model catalog of an instance, the returned catalogs are never modified
+func (h *APIHandlers) setInstanceModels(instanceName string, models []string) {
+	h.modelsMu.Lock()
+	defer h.modelsMu.Unlock()
+	h.models[instanceName] = models
 }
 
 // ChatCompletions handles the /v1/chat/completions endpoint
@@ -71,6 +242,74 @@ func (h *APIHandlers) ChatCompletions(c *gin.Context) {
 		return
 	}
 
+	if gjson.GetBytes(rawJson, "background").Type == gjson.True {
+		h.createJob(c, rawJson)
+		return
+	}
+
+	task, status, errResponse := h.dispatchTask(c, rawJson)
+	if errResponse != nil {
+		c.JSON(status, errResponse)
+		return
+	}
+	defer task.Release()
+
+	streamResult := gjson.GetBytes(rawJson, "stream")
+	if streamResult.Type == gjson.True {
+		h.writeResponse(c, task, &openAIStreamWriter{})
+	} else {
+		h.writeResponse(c, task, &openAIWriter{})
+	}
+}
+
+// DispatchedTask is a task that has been picked up by the processor.
+// The instance stays locked for the task until Release is called.
+type DispatchedTask struct {
+	ID            string
+	InstanceIndex int
+	Response      *TaskResponse
+	release       func()
+}
+
+// Release unlocks the instance of the task
+func (t *DispatchedTask) Release() {
+	if t.release != nil {
+		t.release()
+		t.release = nil
+	}
+}
+
+// dispatchTask queues an OpenAI chat completion request for the instance encoded in its model
+// and waits for the processor to start it. All API protocols funnel their requests through here.
+func (h *APIHandlers) dispatchTask(c *gin.Context, rawJson []byte) (*DispatchedTask, int, *ErrorResponse) {
+	admission, rateLimit, status, errResponse := h.admitTask(apiKeyFromContext(c), rawJson)
+	setRateLimitHeaders(c, rateLimit)
+	if errResponse != nil {
+		return nil, status, errResponse
+	}
+	if h.cache != nil {
+		return h.dispatchCachedTask(c, admission)
+	}
+	return h.startTask(admission, c)
+}
+
+// taskAdmission is a request that passed the permission and rate limit checks of its API key and instance.
+// The request is the one sent to the website, startTask downloads its remote images and files into it.
+// Images marks the admissions of the images endpoint, whose tasks run the images_generations runner.
+type taskAdmission struct {
+	instanceName  string
+	instanceIndex int
+	model         string
+	page          *chrome.Page
+	key           *auth.Key
+	request       []byte
+	images        bool
+	release       func()
+}
+
+// admitTask resolves the instance of a request and checks its attachments and the permissions and rate limits of the API key.
+// The rate limit slots are held until the task started from the admission is released.
+func (h *APIHandlers) admitTask(key *auth.Key, rawJson []byte) (*taskAdmission, ratelimit.Result, int, *ErrorResponse) {
 	instanceName := ""
 	modelResult := gjson.GetBytes(rawJson, "model")
 	if modelResult.Type == gjson.String {
@@ -83,72 +322,205 @@ func (h *APIHandlers) ChatCompletions(c *gin.Context) {
 			instanceIndex = i
 		}
 	}
-	if page, ok := h.pages[instanceName]; ok {
-		defer page.RequestMutex.Unlock()
-		page.RequestMutex.Lock()
-	} else {
-		c.JSON(http.StatusNotFound, ErrorResponse{
+	page, ok := h.pages[instanceName]
+	if !ok {
+		return nil, ratelimit.Result{}, http.StatusNotFound, &ErrorResponse{
 			Error: ErrorDetail{
 				Message: fmt.Sprintf("model \"%s\" not found.", modelResult),
 				Type:    "not_found",
 			},
-		})
-		return
+		}
+	}
+
+	if h.instances.Paused(instanceName) {
+		return nil, ratelimit.Result{}, http.StatusServiceUnavailable, &ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("Instance %s is paused and does not accept requests", instanceName),
+				Type:    "server_error",
+				Code:    "instance_paused",
+			},
+		}
+	}
+	if h.instances.Attached(instanceName) {
+		return nil, ratelimit.Result{}, http.StatusServiceUnavailable, &ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("Instance %s is controlled by an operator and does not accept requests", instanceName),
+				Type:    "server_error",
+				Code:    "instance_attached",
+			},
+		}
+	}
+
+	if !key.AllowsModel(modelResult.String()) {
+		log.Warnf("API key %s is not allowed to use model %s", key.Name(), modelResult.String())
+		return nil, ratelimit.Result{}, http.StatusForbidden, &ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("The API key is not allowed to use model %s", modelResult.String()),
+				Type:    "permission_error",
+				Code:    "model_not_allowed",
+			},
+		}
+	}
+
+	if attachmentError := checkAttachments(h.appConfig.Instance[instanceIndex], rawJson); attachmentError != nil {
+		log.Warnf("Rejected the attachments of a request to %s: %s", instanceName, attachmentError.Error.Message)
+		return nil, ratelimit.Result{}, http.StatusBadRequest, attachmentError
+	}
+
+	releaseRateLimit, rateLimit := h.limiter.Acquire(h.rateLimits(key, h.appConfig.Instance[instanceIndex])...)
+	if !rateLimit.Allowed {
+		log.Warnf("Rate limit %s exceeded by API key %s: %s", rateLimit.Name, key.Name(), rateLimit.Reason)
+		return nil, rateLimit, http.StatusTooManyRequests, &ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("Rate limit reached for %s on %s, please try again in %s", rateLimit.Name, rateLimit.Reason, rateLimit.RetryAfter.Round(time.Millisecond)),
+				Type:    "rate_limit_error",
+				Code:    "rate_limit_exceeded",
+			},
+		}
+	}
+
+	return &taskAdmission{
+		instanceName:  instanceName,
+		instanceIndex: instanceIndex,
+		model:         modelResult.String(),
+		page:          page,
+		key:           key,
+		request:       rawJson,
+		release:       releaseRateLimit,
+	}, rateLimit, http.StatusOK, nil
+}
+
+// startTask locks the instance of an admitted request, queues it and waits for the processor to start it.
+// The gin context is optional, background jobs run without one. Until it is released, the task can be
+// cancelled by id, the id is sent in the X-Task-Id header.
+func (h *APIHandlers) startTask(admission *taskAdmission, c *gin.Context) (*DispatchedTask, int, *ErrorResponse) {
+	// Remote files are only downloaded for admitted requests, within the rate limits of the key
+	ctx := context.Background()
+	if c != nil {
+		ctx = c.Request.Context()
+	}
+	if fetchError := h.inlineRemoteFiles(ctx, admission); fetchError != nil {
+		log.Warnf("Could not fetch the remote files of a request to %s: %s", admission.instanceName, fetchError.Error.Message)
+		admission.release()
+		return nil, http.StatusBadRequest, fetchError
 	}
 
 	// Generate unique task ID
 	taskID := uuid.New().String()
+	if c != nil {
+		c.Header("X-Task-Id", taskID)
+	}
 
 	// Create a task
-	task := &RequestTask{
-		ID:           taskID,
-		Request:      string(rawJson),
-		Response:     make(chan *TaskResponse, 1),
-		CreatedAt:    time.Now(),
-		Context:      c,
-		InstanceName: instanceName,
+	requestTask := &RequestTask{
+		ID:                taskID,
+		Request:           string(admission.request),
+		Response:          make(chan *TaskResponse, 1),
+		CreatedAt:         time.Now(),
+		Context:           c,
+		InstanceName:      admission.instanceName,
+		APIKey:            admission.key.Name(),
+		ImagesGenerations: admission.images,
+		Cancel:            make(chan struct{}),
+	}
+	tracked := h.tasks.add(requestTask, admission)
+
+	// Wait for the instance, a task cancelled meanwhile gives its turn up
+	locked := make(chan struct{})
+	go func() {
+		admission.page.RequestMutex.Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-requestTask.Cancel:
+		go func() {
+			<-locked
+			admission.page.RequestMutex.Unlock()
+		}()
+		return h.cancelledTask(admission, requestTask), http.StatusOK, nil
 	}
 
+	task := &DispatchedTask{
+		ID:            taskID,
+		InstanceIndex: admission.instanceIndex,
+		release: func() {
+			h.tasks.remove(taskID)
+			admission.page.RequestMutex.Unlock()
+			admission.release()
+		},
+	}
+	log.Infof("Task %s from API key %s queued for model %s", taskID, admission.key.Name(), admission.model)
+
 	// Add a task to queue
-	if err = h.queue.AddTask(task); err != nil {
-		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+	if err := h.queue.AddTask(requestTask); err != nil {
+		task.Release()
+		return nil, http.StatusServiceUnavailable, &ErrorResponse{
 			Error: ErrorDetail{
 				Message: fmt.Sprintf("Failed to queue request: %v", err),
 				Type:    "server_error",
 			},
-		})
-		return
+		}
 	}
 
 	// Wait for response
 	select {
-	case response := <-task.Response:
+	case response := <-requestTask.Response:
 		if !response.Success {
-			c.JSON(http.StatusInternalServerError, ErrorResponse{
+			task.Release()
+			if errors.Is(response.Error, errTaskCancelled) {
+				return h.cancelledTask(admission, requestTask), http.StatusOK, nil
+			}
+			return nil, http.StatusInternalServerError, &ErrorResponse{
 				Error: ErrorDetail{
 					Message: fmt.Sprintf("Processing failed: %v", response.Error),
 					Type:    "server_error",
 				},
-			})
-			return
+			}
 		}
-
-		streamResult := gjson.GetBytes(rawJson, "stream")
-		if streamResult.Type == gjson.True {
-			h.handleStreamingResponse(instanceIndex, c, response)
-		} else {
-			h.handleNonStreamingResponse(instanceIndex, c, response)
+		task.Response = response
+		if tracked.started(response) {
+			// Cancelled while the processor was starting the task
+			go h.stopTask(task.InstanceIndex, response)
 		}
-
+		return task, http.StatusOK, nil
 	case <-time.After(5 * time.Minute): // 5 minute timeout
-		c.JSON(http.StatusRequestTimeout, ErrorResponse{
+		task.Release()
+		return nil, http.StatusRequestTimeout, &ErrorResponse{
 			Error: ErrorDetail{
 				Message: "Request timeout",
 				Type:    "timeout_error",
 			},
-		})
+		}
+	}
+}
+
+// rateLimits returns the rate limits of the API key and of the instance a request has to pass
+func (h *APIHandlers) rateLimits(key *auth.Key, instance config.AppConfigInstance) []ratelimit.Request {
+	keyLimit := h.appConfig.RateLimit
+	if key != nil && key.RateLimit != nil {
+		keyLimit = *key.RateLimit
+	}
+	return []ratelimit.Request{
+		{Name: "key " + key.ID(), Limit: keyLimit},
+		{Name: "instance " + instance.Name, Limit: instance.RateLimit},
+	}
+}
+
+// setRateLimitHeaders writes the OpenAI style x-ratelimit-* headers, and Retry-After for rejected requests
+func setRateLimitHeaders(c *gin.Context, result ratelimit.Result) {
+	if result.Name == "" {
+		// The request was rejected before reaching the rate limits
 		return
 	}
+	if result.RequestsLimit > 0 {
+		c.Header("x-ratelimit-limit-requests", strconv.Itoa(result.RequestsLimit))
+		c.Header("x-ratelimit-remaining-requests", strconv.Itoa(result.RequestsRemaining))
+		c.Header("x-ratelimit-reset-requests", result.Reset.Round(time.Millisecond).String())
+	}
+	if !result.Allowed {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+	}
 }
 
 func (h *APIHandlers) handleContextCanceled(instanceIndex int) {
@@ -166,11 +538,22 @@ func (h *APIHandlers) handleContextCanceled(instanceIndex int) {
 	log.Debugf("all of the rules are executed.")
 }
 
-// handleNonStreamingResponse handles non-streaming responses
-func (h *APIHandlers) handleNonStreamingResponse(instanceIndex int, c *gin.Context, response *TaskResponse) {
-
-	c.Header("Content-Type", "application/json")
+// streamWriter renders the chunks produced by the chat processor in the wire format of an API protocol
+type streamWriter interface {
+	// Begin writes the response headers
+	Begin(c *gin.Context)
+	// Chunk writes a chat completion (chunk) json
+	Chunk(c *gin.Context, chunk string)
+	// Error writes an error json produced by the processor
+	Error(c *gin.Context, chunk string)
+	// Done is called when the processor closed the stream
+	Done(c *gin.Context)
+	// KeepAlive is called while waiting for the next chunk
+	KeepAlive(c *gin.Context)
+}
 
+// writeResponse forwards the processor stream of a task to the client through a stream writer
+func (h *APIHandlers) writeResponse(c *gin.Context, task *DispatchedTask, writer streamWriter) {
 	// Handle streaming manually
 	flusher, ok := c.Writer.(http.Flusher)
 	if !ok {
@@ -183,90 +566,88 @@ func (h *APIHandlers) handleNonStreamingResponse(instanceIndex int, c *gin.Conte
 		return
 	}
 
+	writer.Begin(c)
+	response := task.Response
 	for {
 		select {
 		case <-c.Request.Context().Done():
 			if c.Request.Context().Err().Error() == "context canceled" {
 				log.Debugf("Client disconnected: %v", c.Request.Context().Err())
-				h.handleContextCanceled(instanceIndex)
-				response.Runner.Abort()
+				h.stopTask(task.InstanceIndex, response)
 			}
 			return
 		case chunk, okStream := <-response.Stream:
-			if strings.HasPrefix(chunk, "{\"error\"") {
-				c.Status(500)
-				_, _ = fmt.Fprintf(c.Writer, chunk)
+			if !okStream {
+				writer.Done(c)
 				flusher.Flush()
 				return
 			}
 
-			if !okStream {
+			if strings.HasPrefix(chunk, "{\"error\"") {
+				writer.Error(c, chunk)
+				flusher.Flush()
 				return
 			}
 
-			c.Status(http.StatusOK)
-			_, _ = fmt.Fprintf(c.Writer, "%s", chunk)
+			writer.Chunk(c, chunk)
 			flusher.Flush()
 		case <-time.After(500 * time.Millisecond):
-			// Write processing tag
-			_, _ = c.Writer.Write([]byte("\n"))
+			writer.KeepAlive(c)
 			flusher.Flush()
 		}
 	}
 }
 
-// handleStreamingResponse handles streaming responses
-func (h *APIHandlers) handleStreamingResponse(instanceIndex int, c *gin.Context, response *TaskResponse) {
+// openAIWriter writes non-streaming chat completion responses
+type openAIWriter struct {
+}
+
+func (w *openAIWriter) Begin(c *gin.Context) {
+	c.Header("Content-Type", "application/json")
+}
+
+func (w *openAIWriter) Chunk(c *gin.Context, chunk string) {
+	c.Status(http.StatusOK)
+	_, _ = fmt.Fprintf(c.Writer, "%s", chunk)
+}
+
+func (w *openAIWriter) Error(c *gin.Context, chunk string) {
+	c.Status(http.StatusInternalServerError)
+	_, _ = fmt.Fprintf(c.Writer, "%s", chunk)
+}
+
+func (w *openAIWriter) Done(_ *gin.Context) {
+}
+
+func (w *openAIWriter) KeepAlive(c *gin.Context) {
+	// Write processing tag
+	_, _ = c.Writer.Write([]byte("\n"))
+}
+
+// openAIStreamWriter writes chat completion chunks as server-sent events
+type openAIStreamWriter struct {
+}
+
+func (w *openAIStreamWriter) Begin(c *gin.Context) {
 	c.Header("Content-Type", "text/event-stream")
 	c.Header("Cache-Control", "no-cache")
 	c.Header("Connection", "keep-alive")
 	c.Header("Access-Control-Allow-Origin", "*")
+}
 
-	// Handle streaming manually
-	flusher, ok := c.Writer.(http.Flusher)
-	if !ok {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Error: ErrorDetail{
-				Message: "Streaming not supported",
-				Type:    "server_error",
-			},
-		})
-		return
-	}
-
-	for {
-		select {
-		case <-c.Request.Context().Done():
-			if c.Request.Context().Err().Error() == "context canceled" {
-				log.Debugf("Client disconnected: %v", c.Request.Context().Err())
-				h.handleContextCanceled(instanceIndex)
-				response.Runner.Abort()
-			}
-			return
-		case chunk, okStream := <-response.Stream:
-			if strings.HasPrefix(chunk, "{\"error\"") {
-				c.Status(500)
-				_, _ = fmt.Fprintf(c.Writer, chunk)
-				flusher.Flush()
-				return
-			}
+func (w *openAIStreamWriter) Chunk(c *gin.Context, chunk string) {
+	_, _ = fmt.Fprintf(c.Writer, "data: %s\n\n", chunk)
+}
 
-			if !okStream {
-				_, _ = fmt.Fprintf(c.Writer, "data: [DONE]\n\n")
-				flusher.Flush()
-				return
-			}
+func (w *openAIStreamWriter) Error(c *gin.Context, chunk string) {
+	c.Status(http.StatusInternalServerError)
+	_, _ = fmt.Fprintf(c.Writer, "%s", chunk)
+}
 
-			_, _ = fmt.Fprintf(c.Writer, "data: %s\n\n", chunk)
-			flusher.Flush()
+func (w *openAIStreamWriter) Done(c *gin.Context) {
+	_, _ = fmt.Fprintf(c.Writer, "data: [DONE]\n\n")
+}
 
-		case <-time.After(300 * time.Second):
-			_, _ = fmt.Fprintf(c.Writer, "data: [DONE]\n\n")
-			flusher.Flush()
-			return
-		case <-time.After(500 * time.Millisecond):
-			_, _ = c.Writer.Write([]byte(": ANY-AI-PROXY-API PROCESSING\n\n"))
-			flusher.Flush()
-		}
-	}
+func (w *openAIStreamWriter) KeepAlive(c *gin.Context) {
+	_, _ = c.Writer.Write([]byte(": ANY-AI-PROXY-API PROCESSING\n\n"))
 }
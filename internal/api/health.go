@@ -0,0 +1,125 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/sjson"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultFailureThreshold = 3
+	// authCheckStaleAfter is how long a readiness probe trusts the last auth check, main.go runs it every 5 seconds
+	authCheckStaleAfter = 30 * time.Second
+)
+
+// Healthz handles the GET /healthz endpoint, it only reports that the process serves requests
+func (h *APIHandlers) Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Readyz handles the GET /readyz endpoint. The server is ready while at least one instance is ready,
+// "degraded" tells that some instances are not.
+func (h *APIHandlers) Readyz(c *gin.Context) {
+	browserConnected := h.browser != nil && h.browser.Connected()
+
+	readyz := `{"status":"","instances":[]}`
+	readyCount := 0
+	for i := range h.appConfig.Instance {
+		ready, readinessJson := h.instanceReadiness(i, browserConnected)
+		if ready {
+			readyCount++
+		}
+		readyz, _ = sjson.SetRaw(readyz, "instances.-1", readinessJson)
+	}
+
+	status := http.StatusOK
+	switch {
+	case readyCount == 0:
+		readyz, _ = sjson.Set(readyz, "status", "unavailable")
+		status = http.StatusServiceUnavailable
+	case readyCount < len(h.appConfig.Instance):
+		readyz, _ = sjson.Set(readyz, "status", "degraded")
+	default:
+		readyz, _ = sjson.Set(readyz, "status", "ready")
+	}
+	c.Header("Content-Type", "application/json")
+	c.Header("Cache-Control", "no-cache")
+	c.String(status, readyz)
+}
+
+// ReadyzInstance handles the GET /readyz/{name} endpoint, it answers 503 when the instance is not ready
+func (h *APIHandlers) ReadyzInstance(c *gin.Context) {
+	for i, instanceConfig := range h.appConfig.Instance {
+		if instanceConfig.Name != c.Param("name") {
+			continue
+		}
+		ready, readinessJson := h.instanceReadiness(i, h.browser != nil && h.browser.Connected())
+		status := http.StatusOK
+		if !ready {
+			status = http.StatusServiceUnavailable
+		}
+		c.Header("Content-Type", "application/json")
+		c.Header("Cache-Control", "no-cache")
+		c.String(status, readinessJson)
+		return
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Instance %s not found", c.Param("name")), "code": 404})
+}
+
+// instanceReadiness checks whether an instance can take requests: its page is open in a connected browser,
+// the Auth.Check selector was found by the last auth probe, it is not paused or attached, and its last
+// requests did not all fail. The reasons list every failed check.
+func (h *APIHandlers) instanceReadiness(index int, browserConnected bool) (bool, string) {
+	instanceConfig := h.appConfig.Instance[index]
+	status := h.instances.Status(instanceConfig.Name)
+	failureThreshold := h.appConfig.Health.FailureThreshold
+	if failureThreshold <= 0 {
+		failureThreshold = defaultFailureThreshold
+	}
+
+	reasons := make([]string, 0)
+	if page, ok := h.pages[instanceConfig.Name]; !ok {
+		reasons = append(reasons, "page_not_open")
+	} else if page.Closed() {
+		reasons = append(reasons, "page_closed")
+	}
+	if !browserConnected {
+		reasons = append(reasons, "browser_disconnected")
+	}
+	if instanceConfig.Auth.Check != "" {
+		switch {
+		case !status.AuthChecked:
+			reasons = append(reasons, "auth_not_checked")
+		case !status.LoggedIn:
+			reasons = append(reasons, "logged_out")
+		case time.Since(status.AuthCheckedAt) > authCheckStaleAfter:
+			reasons = append(reasons, "auth_check_stale")
+		}
+	}
+	if status.Paused {
+		reasons = append(reasons, "paused")
+	}
+	if status.Attached {
+		reasons = append(reasons, "attached")
+	}
+	if status.ConsecutiveFailures >= failureThreshold {
+		reasons = append(reasons, "failing_requests")
+	}
+
+	readinessJson := `{"name":"","ready":false,"reasons":[],"consecutive_failures":0,"last_success_at":null,"last_failure_at":null}`
+	readinessJson, _ = sjson.Set(readinessJson, "name", instanceConfig.Name)
+	readinessJson, _ = sjson.Set(readinessJson, "ready", len(reasons) == 0)
+	for _, reason := range reasons {
+		readinessJson, _ = sjson.Set(readinessJson, "reasons.-1", reason)
+	}
+	readinessJson, _ = sjson.Set(readinessJson, "consecutive_failures", status.ConsecutiveFailures)
+	if !status.LastSuccessAt.IsZero() {
+		readinessJson, _ = sjson.Set(readinessJson, "last_success_at", status.LastSuccessAt.Unix())
+	}
+	if !status.LastFailureAt.IsZero() {
+		readinessJson, _ = sjson.Set(readinessJson, "last_failure_at", status.LastFailureAt.Unix())
+	}
+	return len(reasons) == 0, readinessJson
+}
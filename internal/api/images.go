@@ -0,0 +1,183 @@
+package api
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"net/http"
+	"strings"
+)
+
+// ImagesGenerations handles the POST /v1/images/generations endpoint. The prompt is sent as a user message
+// to the images_generations runner of the instance, the captured images are answered as b64_json or as
+// URLs served from the local image store.
+func (h *APIHandlers) ImagesGenerations(c *gin.Context) {
+	rawJson, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("Invalid request: %v", err),
+				Type:    "invalid_request_error",
+			},
+		})
+		return
+	}
+
+	badRequest := func(message string) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetail{
+				Message: message,
+				Type:    "invalid_request_error",
+			},
+		})
+	}
+	prompt := gjson.GetBytes(rawJson, "prompt")
+	if prompt.Type != gjson.String || prompt.String() == "" {
+		badRequest("prompt is required")
+		return
+	}
+	responseFormat := gjson.GetBytes(rawJson, "response_format").String()
+	if responseFormat == "" {
+		responseFormat = "b64_json"
+	}
+	if responseFormat != "b64_json" && responseFormat != "url" {
+		badRequest(fmt.Sprintf("Invalid response_format %s, expected b64_json or url", responseFormat))
+		return
+	}
+	n := int64(1)
+	if nResult := gjson.GetBytes(rawJson, "n"); nResult.Exists() {
+		n = nResult.Int()
+		if n < 1 || n > 10 {
+			badRequest("n must be between 1 and 10")
+			return
+		}
+	}
+
+	model := gjson.GetBytes(rawJson, "model").String()
+	for _, instanceConfig := range h.appConfig.Instance {
+		if instanceConfig.Name == strings.Split(model, "/")[0] && instanceConfig.Runner.ImagesGenerations == "" {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error: ErrorDetail{
+					Message: fmt.Sprintf("Model %s does not generate images, instance %s has no images_generations runner", model, instanceConfig.Name),
+					Type:    "invalid_request_error",
+					Code:    "model_not_supported",
+				},
+			})
+			return
+		}
+	}
+
+	imagesJson := `{"model":"","messages":[{"role":"user","content":""}],"stream":false,"n":1}`
+	imagesJson, _ = sjson.Set(imagesJson, "model", model)
+	imagesJson, _ = sjson.Set(imagesJson, "messages.0.content", prompt.String())
+	imagesJson, _ = sjson.Set(imagesJson, "n", n)
+	for _, field := range []string{"size", "quality", "style", "background", "output_format"} {
+		if value := gjson.GetBytes(rawJson, field); value.Exists() {
+			imagesJson, _ = sjson.SetRaw(imagesJson, field, value.Raw)
+		}
+	}
+
+	admission, rateLimit, status, admissionError := h.admitTask(apiKeyFromContext(c), []byte(imagesJson))
+	setRateLimitHeaders(c, rateLimit)
+	if admissionError != nil {
+		c.JSON(status, admissionError)
+		return
+	}
+	admission.images = true
+	task, status, taskError := h.startTask(admission, c)
+	if taskError != nil {
+		c.JSON(status, taskError)
+		return
+	}
+	defer task.Release()
+
+	var output string
+	select {
+	case output = <-task.Response.Stream:
+	case <-c.Request.Context().Done():
+		h.stopTask(task.InstanceIndex, task.Response)
+		return
+	}
+	if strings.HasPrefix(output, "{\"error\"") {
+		c.Header("Content-Type", "application/json")
+		c.String(http.StatusInternalServerError, output)
+		return
+	}
+	if !gjson.Get(output, "data").IsArray() {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: ErrorDetail{
+				Message: "The image generation was cancelled",
+				Type:    "server_error",
+			},
+		})
+		return
+	}
+
+	images := `{"created":0,"data":[]}`
+	images, _ = sjson.Set(images, "created", gjson.Get(output, "created").Int())
+	for i, image := range gjson.Get(output, "data").Array() {
+		if int64(i) == n {
+			break
+		}
+		imageJson := `{}`
+		if responseFormat == "url" {
+			imageURL, errStore := h.storeImage(c, image)
+			if errStore != nil {
+				c.JSON(http.StatusInternalServerError, ErrorResponse{
+					Error: ErrorDetail{
+						Message: fmt.Sprintf("Failed to store the image: %v", errStore),
+						Type:    "server_error",
+					},
+				})
+				return
+			}
+			imageJson, _ = sjson.Set(imageJson, "url", imageURL)
+		} else {
+			imageJson, _ = sjson.Set(imageJson, "b64_json", image.Get("b64_json").String())
+		}
+		images, _ = sjson.SetRaw(images, "data.-1", imageJson)
+	}
+	c.Header("Content-Type", "application/json")
+	c.String(http.StatusOK, images)
+}
+
+// ImageFile handles the GET /images/{id} endpoint serving the stored images. It needs no API key,
+// the random id of an image is its access check, and the image expires with the store TTL.
+func (h *APIHandlers) ImageFile(c *gin.Context) {
+	path, err := h.images.Path(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: ErrorDetail{
+				Message: "Image not found or expired",
+				Type:    "invalid_request_error",
+			},
+		})
+		return
+	}
+	c.Header("Cache-Control", "private")
+	c.File(path)
+}
+
+// storeImage stores a generated image and returns its URL
+func (h *APIHandlers) storeImage(c *gin.Context, image gjson.Result) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(image.Get("b64_json").String())
+	if err != nil {
+		return "", err
+	}
+	id, err := h.images.Put(data, image.Get("mime_type").String())
+	if err != nil {
+		return "", err
+	}
+
+	baseURL := h.appConfig.Images.BaseURL
+	if baseURL == "" {
+		scheme := "http"
+		if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+			scheme = "https"
+		}
+		baseURL = scheme + "://" + c.Request.Host
+	}
+	return strings.TrimSuffix(baseURL, "/") + "/images/" + id, nil
+}
@@ -0,0 +1,363 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/luispater/anyAIProxyAPI/internal/fetch"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	JobQueued     = "queued"
+	JobInProgress = "in_progress"
+	JobCompleted  = "completed"
+	JobFailed     = "failed"
+
+	// jobRetention is how long finished jobs can be fetched
+	jobRetention = 24 * time.Hour
+	// jobIdleTimeout aborts a job or batch request when the website produces nothing for that long
+	jobIdleTimeout = 10 * time.Minute
+)
+
+// Job is a chat completion running in the background, independent of the HTTP connection that created it
+type Job struct {
+	ID         string
+	Model      string
+	Owner      string
+	WebhookURL string
+	CreatedAt  time.Time
+
+	mu          sync.Mutex
+	status      string
+	output      *completionAccumulator
+	errorJson   string
+	completedAt time.Time
+}
+
+// Status returns the status of the job
+func (j *Job) Status() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status
+}
+
+// JSON renders the job with its partial or final output
+func (j *Job) JSON() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	jobJson := `{"id":"","object":"chat.completion.job","created_at":0,"status":"","model":"","output":null,"error":null,"completed_at":null}`
+	jobJson, _ = sjson.Set(jobJson, "id", j.ID)
+	jobJson, _ = sjson.Set(jobJson, "created_at", j.CreatedAt.Unix())
+	jobJson, _ = sjson.Set(jobJson, "status", j.status)
+	jobJson, _ = sjson.Set(jobJson, "model", j.Model)
+	if j.output != nil {
+		jobJson, _ = sjson.SetRaw(jobJson, "output", j.output.completion(j.Model))
+	}
+	if j.errorJson != "" {
+		jobJson, _ = sjson.SetRaw(jobJson, "error", gjson.Get(j.errorJson, "error").Raw)
+	}
+	if !j.completedAt.IsZero() {
+		jobJson, _ = sjson.Set(jobJson, "completed_at", j.completedAt.Unix())
+	}
+	if j.WebhookURL != "" {
+		jobJson, _ = sjson.Set(jobJson, "webhook_url", j.WebhookURL)
+	}
+	return jobJson
+}
+
+func (j *Job) setStatus(status string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status = status
+}
+
+func (j *Job) addChunk(chunk string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.output == nil {
+		j.output = &completionAccumulator{}
+	}
+	j.output.add(chunk)
+}
+
+// finish sets the final status of the job, errorJson is an OpenAI error json or empty
+func (j *Job) finish(status, errorJson string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status = status
+	j.errorJson = errorJson
+	j.completedAt = time.Now()
+}
+
+// JobStore keeps the background jobs of the queue
+type JobStore struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+// NewJobStore creates an empty job store
+func NewJobStore() *JobStore {
+	return &JobStore{
+		jobs: make(map[string]*Job),
+	}
+}
+
+// Add stores a job and forgets the jobs finished longer than the retention ago
+func (s *JobStore) Add(job *Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, item := range s.jobs {
+		item.mu.Lock()
+		expired := !item.completedAt.IsZero() && time.Since(item.completedAt) > jobRetention
+		item.mu.Unlock()
+		if expired {
+			delete(s.jobs, id)
+		}
+	}
+	s.jobs[job.ID] = job
+}
+
+// Get returns a job by id
+func (s *JobStore) Get(id string) (*Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	return job, ok
+}
+
+// CreateJob handles the /v1/jobs endpoint
+func (h *APIHandlers) CreateJob(c *gin.Context) {
+	rawJson, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("Invalid request: %v", err),
+				Type:    "invalid_request_error",
+			},
+		})
+		return
+	}
+	h.createJob(c, rawJson)
+}
+
+// GetJob handles the /v1/jobs/{id} endpoint
+func (h *APIHandlers) GetJob(c *gin.Context) {
+	job, ok := h.queue.Jobs().Get(c.Param("id"))
+	if !ok || job.Owner != apiKeyFromContext(c).ID() {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("No job found with id '%s'", c.Param("id")),
+				Type:    "invalid_request_error",
+				Code:    "job_not_found",
+			},
+		})
+		return
+	}
+	c.Header("Content-Type", "application/json")
+	c.String(http.StatusOK, job.JSON())
+}
+
+// createJob admits a chat completion request and runs it in the background.
+// It answers immediately with the queued job, the output is fetched from /v1/jobs/{id}.
+func (h *APIHandlers) createJob(c *gin.Context, rawJson []byte) {
+	webhookURL := gjson.GetBytes(rawJson, "webhook_url").String()
+	if webhookURL != "" {
+		// The webhook receives the job output, it may only be a public address
+		if errCheck := fetch.CheckURL(c.Request.Context(), webhookURL); errCheck != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error: ErrorDetail{
+					Message: fmt.Sprintf("Invalid webhook_url '%s': %v", webhookURL, errCheck),
+					Type:    "invalid_request_error",
+				},
+			})
+			return
+		}
+	}
+
+	// The job streams internally, so its partial output can be fetched while it runs
+	request := string(rawJson)
+	request, _ = sjson.Delete(request, "background")
+	request, _ = sjson.Delete(request, "webhook_url")
+	request, _ = sjson.Set(request, "stream", true)
+
+	admission, rateLimit, status, errResponse := h.admitTask(apiKeyFromContext(c), []byte(request))
+	setRateLimitHeaders(c, rateLimit)
+	if errResponse != nil {
+		c.JSON(status, errResponse)
+		return
+	}
+
+	job := &Job{
+		ID:         "job_" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+		Model:      admission.model,
+		Owner:      admission.key.ID(),
+		WebhookURL: webhookURL,
+		CreatedAt:  time.Now(),
+		status:     JobQueued,
+	}
+	h.queue.Jobs().Add(job)
+	log.Infof("Job %s from API key %s created for model %s", job.ID, admission.key.Name(), job.Model)
+
+	go h.runJob(job, admission)
+
+	c.Header("Content-Type", "application/json")
+	c.String(http.StatusAccepted, job.JSON())
+}
+
+// runJob runs a job to completion and calls its webhook
+func (h *APIHandlers) runJob(job *Job, admission *taskAdmission) {
+	defer h.notifyWebhook(job)
+
+	task, _, errResponse := h.startTask(admission, nil)
+	if errResponse != nil {
+		job.finish(JobFailed, errorResponseJson(errResponse))
+		return
+	}
+	defer task.Release()
+
+	job.setStatus(JobInProgress)
+	if errorJson := h.collectTask(task, job.addChunk); errorJson != "" {
+		job.finish(JobFailed, errorJson)
+		log.Infof("Job %s failed: %s", job.ID, gjson.Get(errorJson, "error.message").String())
+		return
+	}
+	job.finish(JobCompleted, "")
+	log.Infof("Job %s completed", job.ID)
+}
+
+// collectTask consumes the processor stream of a task without a client connection.
+// It returns the error json of a failed task, or an empty string when the stream completed.
+func (h *APIHandlers) collectTask(task *DispatchedTask, add func(chunk string)) string {
+	for {
+		select {
+		case chunk, ok := <-task.Response.Stream:
+			if !ok {
+				return ""
+			}
+			if strings.HasPrefix(chunk, "{\"error\"") {
+				return chunk
+			}
+			add(chunk)
+		case <-time.After(jobIdleTimeout):
+			log.Infof("No output for %s, aborting the task", jobIdleTimeout)
+			h.stopTask(task.InstanceIndex, task.Response)
+			return processorError(fmt.Errorf("no output for %s, the task was aborted", jobIdleTimeout))
+		}
+	}
+}
+
+// notifyWebhook posts the finished job to its webhook, retrying failed deliveries. The webhook is only
+// called on a public address and its redirects are not followed.
+func (h *APIHandlers) notifyWebhook(job *Job) {
+	if job.WebhookURL == "" {
+		return
+	}
+
+	client := fetch.NewPublicClient(10*time.Second, false)
+	body := job.JSON()
+	for attempt := 1; attempt <= 3; attempt++ {
+		req, err := http.NewRequest(http.MethodPost, job.WebhookURL, strings.NewReader(body))
+		if err != nil {
+			log.Warnf("Job %s webhook request failed: %v", job.ID, err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Job-Id", job.ID)
+
+		resp, errDo := client.Do(req)
+		if errDo == nil {
+			_ = resp.Body.Close()
+			if resp.StatusCode < 300 {
+				log.Debugf("Job %s webhook delivered", job.ID)
+				return
+			}
+			errDo = fmt.Errorf("status %d", resp.StatusCode)
+		}
+		log.Warnf("Job %s webhook delivery %d failed: %v", job.ID, attempt, errDo)
+		time.Sleep(time.Duration(attempt) * 5 * time.Second)
+	}
+}
+
+// completionAccumulator merges the chunks of the chat processor into a chat completion
+type completionAccumulator struct {
+	id           string
+	created      int64
+	content      strings.Builder
+	reasoning    strings.Builder
+	toolCalls    toolCallStream
+	finishReason string
+	usage        string
+}
+
+func (a *completionAccumulator) add(chunk string) {
+	root := gjson.Parse(chunk)
+	if a.id == "" {
+		a.id = root.Get("id").String()
+		a.created = root.Get("created").Int()
+	}
+	if usageResult := root.Get("usage"); usageResult.IsObject() {
+		a.usage = usageResult.Raw
+	}
+
+	choice := root.Get("choices.0")
+	delta := choice.Get("delta")
+	if !delta.Exists() {
+		delta = choice.Get("message")
+	}
+	if contentResult := delta.Get("content"); contentResult.Type == gjson.String {
+		a.content.WriteString(contentResult.String())
+	}
+	if reasoningResult := delta.Get("reasoning_content"); reasoningResult.Type == gjson.String {
+		a.reasoning.WriteString(reasoningResult.String())
+	}
+	for _, toolCall := range delta.Get("tool_calls").Array() {
+		index := int(toolCall.Get("index").Int())
+		for len(a.toolCalls.calls) <= index {
+			a.toolCalls.calls = append(a.toolCalls.calls, &streamedToolCall{})
+		}
+		call := a.toolCalls.calls[index]
+		if id := toolCall.Get("id").String(); id != "" {
+			call.id = id
+		}
+		if name := toolCall.Get("function.name").String(); name != "" {
+			call.name = name
+		}
+		call.arguments = call.arguments + toolCall.Get("function.arguments").String()
+	}
+	if finishReasonResult := choice.Get("finish_reason"); finishReasonResult.Type == gjson.String {
+		a.finishReason = finishReasonResult.String()
+	}
+}
+
+// completion renders the chat completion merged so far
+func (a *completionAccumulator) completion(model string) string {
+	output := `{"id":"","object":"chat.completion","created":0,"model":"","choices":[{"index":0,"message":{"role":"assistant","content":null,"reasoning_content":null},"finish_reason":null}]}`
+	output, _ = sjson.Set(output, "id", a.id)
+	output, _ = sjson.Set(output, "created", a.created)
+	output, _ = sjson.Set(output, "model", model)
+	if a.content.Len() > 0 {
+		output, _ = sjson.Set(output, "choices.0.message.content", a.content.String())
+	}
+	if a.reasoning.Len() > 0 {
+		output, _ = sjson.Set(output, "choices.0.message.reasoning_content", a.reasoning.String())
+	}
+	if len(a.toolCalls.calls) > 0 {
+		output, _ = sjson.SetRaw(output, "choices.0.message.tool_calls", a.toolCalls.toolCalls())
+	}
+	if a.finishReason != "" {
+		output, _ = sjson.Set(output, "choices.0.finish_reason", a.finishReason)
+	}
+	if a.usage != "" {
+		output, _ = sjson.SetRaw(output, "usage", a.usage)
+	}
+	return output
+}
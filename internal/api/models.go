@@ -24,14 +24,42 @@ type ErrorDetail struct {
 	Code    string `json:"code,omitempty"`
 }
 
+// ModelList represents the response of the models endpoint
+type ModelList struct {
+	Object string        `json:"object"`
+	Data   []ModelObject `json:"data"`
+}
+
+// ModelObject represents a model served by an instance
+type ModelObject struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
 // RequestTask represents a queued request task
 type RequestTask struct {
-	ID           string             `json:"id"`
-	Request      string             `json:"request"`
-	Response     chan *TaskResponse `json:"-"`
-	CreatedAt    time.Time          `json:"created_at"`
-	Context      *gin.Context       `json:"context"`
-	InstanceName string             `json:"instance_name"`
+	ID                string             `json:"id"`
+	Request           string             `json:"request"`
+	Response          chan *TaskResponse `json:"-"`
+	CreatedAt         time.Time          `json:"created_at"`
+	StartedAt         time.Time          `json:"started_at"`
+	Context           *gin.Context       `json:"context"`
+	InstanceName      string             `json:"instance_name"`
+	APIKey            string             `json:"api_key"`
+	ImagesGenerations bool               `json:"images_generations"`
+	Cancel            chan struct{}      `json:"-"`
+}
+
+// Cancelled reports whether the task was cancelled by id
+func (t *RequestTask) Cancelled() bool {
+	select {
+	case <-t.Cancel:
+		return true
+	default:
+		return false
+	}
 }
 
 // TaskResponse represents the response from processing a task
@@ -0,0 +1,369 @@
+package api
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OllamaVersion is the Ollama API version reported to clients
+const OllamaVersion = "0.9.0"
+
+// OllamaTags handles the Ollama compatible /api/tags endpoint
+func (h *APIHandlers) OllamaTags(c *gin.Context) {
+	modifiedAt := time.Unix(h.created, 0).UTC().Format(time.RFC3339)
+	tags := `{"models":[]}`
+	for _, model := range h.listModels(c) {
+		tag := `{"name":"","model":"","modified_at":"","size":0,"digest":"","details":{"format":"","family":"","families":null,"parameter_size":"","quantization_level":""}}`
+		tag, _ = sjson.Set(tag, "name", model.ID)
+		tag, _ = sjson.Set(tag, "model", model.ID)
+		tag, _ = sjson.Set(tag, "modified_at", modifiedAt)
+		tag, _ = sjson.Set(tag, "details.family", model.OwnedBy)
+		tags, _ = sjson.SetRaw(tags, "models.-1", tag)
+	}
+	c.Header("Content-Type", "application/json")
+	c.String(http.StatusOK, tags)
+}
+
+// OllamaShow handles the Ollama compatible /api/show endpoint
+func (h *APIHandlers) OllamaShow(c *gin.Context) {
+	rawJson, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid request: %v", err)})
+		return
+	}
+	modelName := gjson.GetBytes(rawJson, "model").String()
+	if modelName == "" {
+		modelName = gjson.GetBytes(rawJson, "name").String()
+	}
+	modelName = ollamaModelName(modelName)
+
+	for _, model := range h.listModels(c) {
+		if model.ID == modelName {
+			show := `{"modelfile":"","parameters":"","template":"","details":{"format":"","family":"","families":null,"parameter_size":"","quantization_level":""},"model_info":{},"capabilities":["completion","tools","thinking","vision"]}`
+			show, _ = sjson.Set(show, "details.family", model.OwnedBy)
+			c.Header("Content-Type", "application/json")
+			c.String(http.StatusOK, show)
+			return
+		}
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", modelName)})
+}
+
+// OllamaVersion handles the Ollama compatible /api/version endpoint
+func (h *APIHandlers) OllamaVersion(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"version": OllamaVersion})
+}
+
+// OllamaChat handles the Ollama compatible /api/chat endpoint
+func (h *APIHandlers) OllamaChat(c *gin.Context) {
+	h.ollamaGenerate(c, false)
+}
+
+// OllamaGenerate handles the Ollama compatible /api/generate endpoint
+func (h *APIHandlers) OllamaGenerate(c *gin.Context) {
+	h.ollamaGenerate(c, true)
+}
+
+func (h *APIHandlers) ollamaGenerate(c *gin.Context, generate bool) {
+	rawJson, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid request: %v", err)})
+		return
+	}
+
+	chatJson, err := convertOllamaRequest(rawJson, generate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	task, status, errResponse := h.dispatchTask(c, []byte(chatJson))
+	if errResponse != nil {
+		c.JSON(status, gin.H{"error": errResponse.Error.Message})
+		return
+	}
+	defer task.Release()
+
+	h.writeResponse(c, task, &ollamaWriter{
+		model:    gjson.Get(chatJson, "model").String(),
+		stream:   gjson.Get(chatJson, "stream").Type == gjson.True,
+		generate: generate,
+	})
+}
+
+// ollamaModelName strips the default tag Ollama clients append to model names
+func ollamaModelName(model string) string {
+	return strings.TrimSuffix(model, ":latest")
+}
+
+// ollamaImageURL converts a raw base64 Ollama image into a data URI
+func ollamaImageURL(image string) string {
+	header := image
+	if len(header) > 512 {
+		header = header[:512]
+	}
+	rawData, _ := base64.StdEncoding.DecodeString(header[:len(header)/4*4])
+	return fmt.Sprintf("data:%s;base64,%s", http.DetectContentType(rawData), image)
+}
+
+// convertOllamaRequest converts an Ollama chat or generate request into the internal chat completion request
+func convertOllamaRequest(rawJson []byte, generate bool) (string, error) {
+	root := gjson.ParseBytes(rawJson)
+
+	chatJson := `{"model":"","messages":[],"stream":true}`
+	chatJson, _ = sjson.Set(chatJson, "model", ollamaModelName(root.Get("model").String()))
+	// Ollama streams unless told otherwise
+	if root.Get("stream").Type == gjson.False {
+		chatJson, _ = sjson.Set(chatJson, "stream", false)
+	}
+
+	options := root.Get("options")
+	if temperatureResult := options.Get("temperature"); temperatureResult.Type == gjson.Number {
+		chatJson, _ = sjson.Set(chatJson, "temperature", temperatureResult.Float())
+	}
+	if topPResult := options.Get("top_p"); topPResult.Type == gjson.Number {
+		chatJson, _ = sjson.Set(chatJson, "top_p", topPResult.Float())
+	}
+	if numPredictResult := options.Get("num_predict"); numPredictResult.Type == gjson.Number && numPredictResult.Int() > 0 {
+		chatJson, _ = sjson.Set(chatJson, "max_tokens", numPredictResult.Int())
+	}
+	if stopResult := options.Get("stop"); stopResult.IsArray() {
+		stops := stopResult.Array()
+		if len(stops) == 1 {
+			chatJson, _ = sjson.Set(chatJson, "stop", stops[0].String())
+		} else if len(stops) > 1 {
+			chatJson, _ = sjson.SetRaw(chatJson, "stop", stopResult.Raw)
+		}
+	}
+	// think is a level like "high", or a boolean turning the thinking on at the default level or off
+	thinkResult := root.Get("think")
+	switch thinkResult.Type {
+	case gjson.String:
+		chatJson, _ = sjson.Set(chatJson, "reasoning_effort", thinkResult.String())
+	case gjson.True:
+		chatJson, _ = sjson.Set(chatJson, "reasoning_effort", "medium")
+	case gjson.False:
+		chatJson, _ = sjson.Set(chatJson, "reasoning_effort", "none")
+	}
+
+	if generate {
+		if systemResult := root.Get("system"); systemResult.String() != "" {
+			chatJson, _ = sjson.SetRaw(chatJson, "messages.-1", systemMessage(systemResult.String()))
+		}
+		message := ollamaMessage("user", root.Get("prompt").String(), root.Get("images"))
+		chatJson, _ = sjson.SetRaw(chatJson, "messages.-1", message)
+		return chatJson, nil
+	}
+
+	if !root.Get("messages").IsArray() {
+		return "", fmt.Errorf("messages is required")
+	}
+	callCount := 0
+	lastCallIDs := make(map[string][]string)
+	for _, msg := range root.Get("messages").Array() {
+		role := msg.Get("role").String()
+		switch role {
+		case "system", "user":
+			chatJson, _ = sjson.SetRaw(chatJson, "messages.-1", ollamaMessage(role, msg.Get("content").String(), msg.Get("images")))
+		case "assistant":
+			message := ollamaMessage(role, msg.Get("content").String(), gjson.Result{})
+			for _, toolCall := range msg.Get("tool_calls").Array() {
+				name := toolCall.Get("function.name").String()
+				callCount++
+				callID := fmt.Sprintf("call_%s_%d", name, callCount)
+				lastCallIDs[name] = append(lastCallIDs[name], callID)
+
+				arguments := toolCall.Get("function.arguments").Raw
+				if arguments == "" {
+					arguments = "{}"
+				}
+				openAIToolCall, _ := sjson.Set(`{"id":"","type":"function","function":{"name":"","arguments":""}}`, "id", callID)
+				openAIToolCall, _ = sjson.Set(openAIToolCall, "function.name", name)
+				openAIToolCall, _ = sjson.Set(openAIToolCall, "function.arguments", arguments)
+				message, _ = sjson.SetRaw(message, "tool_calls.-1", openAIToolCall)
+			}
+			chatJson, _ = sjson.SetRaw(chatJson, "messages.-1", message)
+		case "tool":
+			// Ollama pairs tool results with calls by function name
+			name := msg.Get("tool_name").String()
+			if name == "" {
+				name = msg.Get("name").String()
+			}
+			callID := ""
+			if len(lastCallIDs[name]) > 0 {
+				callID = lastCallIDs[name][0]
+				lastCallIDs[name] = lastCallIDs[name][1:]
+			}
+			toolMessage, _ := sjson.Set(`{"role":"tool","tool_call_id":"","content":""}`, "tool_call_id", callID)
+			toolMessage, _ = sjson.Set(toolMessage, "name", name)
+			toolMessage, _ = sjson.Set(toolMessage, "content", msg.Get("content").String())
+			chatJson, _ = sjson.SetRaw(chatJson, "messages.-1", toolMessage)
+		default:
+			return "", fmt.Errorf("unexpected role \"%s\"", role)
+		}
+	}
+
+	// Ollama tools already use the OpenAI format
+	if toolsResult := root.Get("tools"); toolsResult.IsArray() {
+		chatJson, _ = sjson.SetRaw(chatJson, "tools", toolsResult.Raw)
+	}
+
+	return chatJson, nil
+}
+
+// ollamaMessage builds an OpenAI message from an Ollama content and its base64 images
+func ollamaMessage(role, content string, images gjson.Result) string {
+	if len(images.Array()) == 0 {
+		message, _ := sjson.Set(`{"role":"","content":""}`, "role", role)
+		message, _ = sjson.Set(message, "content", content)
+		return message
+	}
+
+	message, _ := sjson.Set(`{"role":"","content":[]}`, "role", role)
+	part, _ := sjson.Set(`{"type":"text","text":""}`, "text", content)
+	message, _ = sjson.SetRaw(message, "content.-1", part)
+	for _, image := range images.Array() {
+		part, _ = sjson.Set(`{"type":"image_url","image_url":{"url":""}}`, "image_url.url", ollamaImageURL(image.String()))
+		message, _ = sjson.SetRaw(message, "content.-1", part)
+	}
+	return message
+}
+
+// ollamaWriter converts the chat processor output into Ollama NDJSON responses
+type ollamaWriter struct {
+	model     string
+	stream    bool
+	generate  bool
+	startedAt time.Time
+	toolCalls []string
+	usage     gjson.Result
+	reason    string
+	content   strings.Builder
+	thinking  strings.Builder
+	failed    bool
+}
+
+func (w *ollamaWriter) Begin(c *gin.Context) {
+	w.startedAt = time.Now()
+	w.reason = "stop"
+	if w.stream {
+		c.Header("Content-Type", "application/x-ndjson")
+	} else {
+		c.Header("Content-Type", "application/json")
+	}
+}
+
+// response builds an Ollama response with the given content and thinking
+func (w *ollamaWriter) response(content, thinking string, toolCalls []string, done bool) string {
+	response := `{"model":"","created_at":""}`
+	response, _ = sjson.Set(response, "model", w.model)
+	response, _ = sjson.Set(response, "created_at", time.Now().UTC().Format(time.RFC3339Nano))
+	if w.generate {
+		response, _ = sjson.Set(response, "response", content)
+		if thinking != "" {
+			response, _ = sjson.Set(response, "thinking", thinking)
+		}
+	} else {
+		response, _ = sjson.Set(response, "message.role", "assistant")
+		response, _ = sjson.Set(response, "message.content", content)
+		if thinking != "" {
+			response, _ = sjson.Set(response, "message.thinking", thinking)
+		}
+		for _, toolCall := range toolCalls {
+			response, _ = sjson.SetRaw(response, "message.tool_calls.-1", toolCall)
+		}
+	}
+	response, _ = sjson.Set(response, "done", done)
+	if done {
+		response, _ = sjson.Set(response, "done_reason", w.reason)
+		response, _ = sjson.Set(response, "total_duration", time.Since(w.startedAt).Nanoseconds())
+		response, _ = sjson.Set(response, "load_duration", 0)
+		response, _ = sjson.Set(response, "prompt_eval_count", w.usage.Get("prompt_tokens").Int())
+		response, _ = sjson.Set(response, "prompt_eval_duration", 0)
+		response, _ = sjson.Set(response, "eval_count", w.usage.Get("completion_tokens").Int())
+		response, _ = sjson.Set(response, "eval_duration", time.Since(w.startedAt).Nanoseconds())
+	}
+	return response
+}
+
+// collectToolCalls collects OpenAI tool call deltas into complete Ollama tool calls
+func (w *ollamaWriter) collectToolCalls(toolCalls gjson.Result) {
+	for _, toolCall := range toolCalls.Array() {
+		if toolCall.Get("id").String() != "" || toolCall.Get("function.name").String() != "" || len(w.toolCalls) == 0 {
+			ollamaToolCall, _ := sjson.Set(`{"function":{"name":"","arguments":""}}`, "function.name", toolCall.Get("function.name").String())
+			w.toolCalls = append(w.toolCalls, ollamaToolCall)
+		}
+		last := len(w.toolCalls) - 1
+		arguments := gjson.Get(w.toolCalls[last], "function.arguments").String() + toolCall.Get("function.arguments").String()
+		w.toolCalls[last], _ = sjson.Set(w.toolCalls[last], "function.arguments", arguments)
+	}
+}
+
+// finishToolCalls converts the collected argument strings into json objects
+func (w *ollamaWriter) finishToolCalls() []string {
+	toolCalls := make([]string, 0, len(w.toolCalls))
+	for _, toolCall := range w.toolCalls {
+		arguments := gjson.Parse(gjson.Get(toolCall, "function.arguments").String())
+		if arguments.IsObject() {
+			toolCall, _ = sjson.SetRaw(toolCall, "function.arguments", arguments.Raw)
+		} else {
+			toolCall, _ = sjson.SetRaw(toolCall, "function.arguments", "{}")
+		}
+		toolCalls = append(toolCalls, toolCall)
+	}
+	w.toolCalls = nil
+	return toolCalls
+}
+
+func (w *ollamaWriter) Chunk(c *gin.Context, chunk string) {
+	if usageResult := gjson.Get(chunk, "usage"); usageResult.IsObject() {
+		w.usage = usageResult
+	}
+	if finishReasonResult := gjson.Get(chunk, "choices.0.finish_reason"); finishReasonResult.Type == gjson.String && finishReasonResult.String() == "length" {
+		w.reason = "length"
+	}
+
+	delta := gjson.Get(chunk, "choices.0.delta")
+	if !w.stream {
+		delta = gjson.Get(chunk, "choices.0.message")
+	}
+	content := delta.Get("content").String()
+	thinking := delta.Get("reasoning_content").String()
+	w.collectToolCalls(delta.Get("tool_calls"))
+
+	if !w.stream {
+		w.content.WriteString(content)
+		w.thinking.WriteString(thinking)
+		return
+	}
+	if content != "" || thinking != "" {
+		_, _ = fmt.Fprintf(c.Writer, "%s\n", w.response(content, thinking, nil, false))
+	}
+}
+
+func (w *ollamaWriter) Error(c *gin.Context, chunk string) {
+	w.failed = true
+	errorJson, _ := sjson.Set(`{"error":""}`, "error", gjson.Get(chunk, "error.message").String())
+	if !w.stream {
+		c.Status(http.StatusInternalServerError)
+	}
+	_, _ = fmt.Fprintf(c.Writer, "%s\n", errorJson)
+}
+
+func (w *ollamaWriter) Done(c *gin.Context) {
+	if w.failed {
+		return
+	}
+	c.Status(http.StatusOK)
+	_, _ = fmt.Fprintf(c.Writer, "%s\n", w.response(w.content.String(), w.thinking.String(), w.finishToolCalls(), true))
+}
+
+func (w *ollamaWriter) KeepAlive(_ *gin.Context) {
+	// NDJSON clients cannot parse keep-alive lines
+}
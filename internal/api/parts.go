@@ -0,0 +1,114 @@
+package api
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/luispater/anyAIProxyAPI/internal/adapter"
+	"github.com/luispater/anyAIProxyAPI/internal/browser/chrome"
+	"github.com/luispater/anyAIProxyAPI/internal/toolcall"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// contentPartsKey is the chat completion request flag asking for the output parts of the website,
+// rendered as a content part array and url_citation annotations
+const contentPartsKey = "content_parts"
+
+// partsRequested reports whether the request opted in to the output parts
+func partsRequested(requestJson string) bool {
+	return gjson.Get(requestJson, contentPartsKey).Bool()
+}
+
+// contentParts renders the parts of a response as a chat completion content array. The text comes from the
+// text parts, in their order among the other parts, unless the adapter has none or tool calls are parsed
+// out of the content. Without includeText only the other parts are rendered, for a stream whose text was sent
+// as deltas. Images and files that could not be downloaded are left out.
+func contentParts(requestJson string, data *adapter.AdapterResponse, includeText bool) string {
+	partsJson := "[]"
+	textFromParts := includeText && !toolcall.Active(requestJson) && len(data.PartsOfType(adapter.PartText)) > 0
+	if includeText && !textFromParts && data.Content != "" {
+		partsJson, _ = sjson.SetRaw(partsJson, "-1", textPart(data.Content))
+	}
+
+	for _, part := range data.Parts {
+		partJson := ""
+		switch part.Type {
+		case adapter.PartText:
+			if !textFromParts {
+				continue
+			}
+			partJson = textPart(part.Text)
+		case adapter.PartImage:
+			if part.Data == nil {
+				continue
+			}
+			partJson, _ = sjson.Set(`{"type":"image_url","image_url":{"url":""}}`, "image_url.url", dataURL(part))
+		case adapter.PartFile:
+			if part.Data == nil {
+				continue
+			}
+			partJson, _ = sjson.Set(`{"type":"file","file":{"filename":"","file_data":""}}`, "file.filename", part.Filename)
+			partJson, _ = sjson.Set(partJson, "file.file_data", dataURL(part))
+		case adapter.PartCode:
+			partJson, _ = sjson.Set(`{"type":"code","code":{"language":"","code":""}}`, "code.language", part.Language)
+			partJson, _ = sjson.Set(partJson, "code.code", part.Text)
+		case adapter.PartExecutionResult:
+			partJson, _ = sjson.Set(`{"type":"execution_result","execution_result":{"outcome":"","output":""}}`, "execution_result.outcome", part.Outcome)
+			partJson, _ = sjson.Set(partJson, "execution_result.output", part.Text)
+		default:
+			continue
+		}
+		partsJson, _ = sjson.SetRaw(partsJson, "-1", partJson)
+	}
+	return partsJson
+}
+
+// annotations renders the citations of a response as url_citation annotations, it returns "" without citations
+func annotations(data *adapter.AdapterResponse) string {
+	citations := data.PartsOfType(adapter.PartCitation)
+	if len(citations) == 0 {
+		return ""
+	}
+	annotationsJson := "[]"
+	for _, citation := range citations {
+		annotation := `{"type":"url_citation","url_citation":{"url":"","title":"","start_index":0,"end_index":0}}`
+		annotation, _ = sjson.Set(annotation, "url_citation.url", citation.URL)
+		annotation, _ = sjson.Set(annotation, "url_citation.title", citation.Title)
+		annotation, _ = sjson.Set(annotation, "url_citation.start_index", citation.StartIndex)
+		annotation, _ = sjson.Set(annotation, "url_citation.end_index", citation.EndIndex)
+		annotationsJson, _ = sjson.SetRaw(annotationsJson, "-1", annotation)
+	}
+	return annotationsJson
+}
+
+func textPart(text string) string {
+	partJson, _ := sjson.Set(`{"type":"text","text":""}`, "text", text)
+	return partJson
+}
+
+func dataURL(part adapter.Part) string {
+	return fmt.Sprintf("data:%s;base64,%s", part.MimeType, base64.StdEncoding.EncodeToString(part.Data))
+}
+
+// downloadParts fetches the data of the images and files referenced by URL with the cookies of the page.
+// It downloads every part it can and returns the last error.
+func (cp *ChatProcessor) downloadParts(page *chrome.Page, parts []adapter.Part) error {
+	var lastErr error
+	for i := range parts {
+		if parts[i].Data != nil || parts[i].URL == "" || (parts[i].Type != adapter.PartImage && parts[i].Type != adapter.PartFile) {
+			continue
+		}
+		if page == nil {
+			lastErr = fmt.Errorf("no page to download %s", parts[i].URL)
+			continue
+		}
+		data, mimeType, err := chrome.FetchResource(page.GetContext(), parts[i].URL)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		parts[i].Data = data
+		parts[i].MimeType = mimeType
+	}
+	return lastErr
+}
@@ -2,11 +2,20 @@ package api
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"github.com/chromedp/chromedp"
 	"github.com/luispater/anyAIProxyAPI/internal/adapter"
+	"github.com/luispater/anyAIProxyAPI/internal/audit"
 	"github.com/luispater/anyAIProxyAPI/internal/browser/chrome"
 	"github.com/luispater/anyAIProxyAPI/internal/config"
+	"github.com/luispater/anyAIProxyAPI/internal/conversation"
+	"github.com/luispater/anyAIProxyAPI/internal/instance"
 	"github.com/luispater/anyAIProxyAPI/internal/runner"
+	"github.com/luispater/anyAIProxyAPI/internal/toolcall"
+	"github.com/luispater/anyAIProxyAPI/internal/usage"
 	log "github.com/sirupsen/logrus"
 	"github.com/tidwall/gjson"
 	"github.com/tidwall/sjson"
@@ -15,25 +24,38 @@ import (
 	"time"
 )
 
+// tokenReportTimeout is how long to wait for the token numbers reported by the website
+const tokenReportTimeout = 5 * time.Second
+
+// conversationTTL is how long a web conversation can be continued after its last turn
+const conversationTTL = 24 * time.Hour
+
 // ChatProcessor implements TaskProcessor interface
 type ChatProcessor struct {
-	pages     map[string]*chrome.Page
-	debug     bool
-	appConfig *config.AppConfig
+	pages         map[string]*chrome.Page
+	debug         bool
+	appConfig     *config.AppConfig
+	conversations *conversation.Store
+	instances     *instance.Registry
+	audit         *audit.Logger
 }
 
 // NewChatProcessor creates a new chat processor
-func NewChatProcessor(appConfig *config.AppConfig, pages map[string]*chrome.Page, debug bool) *ChatProcessor {
+func NewChatProcessor(appConfig *config.AppConfig, pages map[string]*chrome.Page, instances *instance.Registry, auditLogger *audit.Logger, debug bool) *ChatProcessor {
 	return &ChatProcessor{
-		pages:     pages,
-		debug:     debug,
-		appConfig: appConfig,
+		pages:         pages,
+		debug:         debug,
+		appConfig:     appConfig,
+		conversations: conversation.NewStore(conversationTTL),
+		instances:     instances,
+		audit:         auditLogger,
 	}
 }
 
 // ProcessTask processes a chat completion task
 func (cp *ChatProcessor) ProcessTask(ctx context.Context, task *RequestTask) *TaskResponse {
 	log.Debugf("Starting to process task %s", task.ID)
+	task.StartedAt = time.Now()
 
 	instanceName := ""
 	modelResult := gjson.Get(task.Request, "model")
@@ -46,13 +68,22 @@ func (cp *ChatProcessor) ProcessTask(ctx context.Context, task *RequestTask) *Ta
 		}
 	}
 
+	// Only the instance configuration turns the emulation on, never the client
+	task.Request, _ = sjson.Delete(task.Request, toolcall.EmulationKey)
 	var appConfigRunner config.AppConfigRunner
 	for i := 0; i < len(cp.appConfig.Instance); i++ {
 		if cp.appConfig.Instance[i].Name == instanceName {
 			appConfigRunner = cp.appConfig.Instance[i].Runner
+			if cp.appConfig.Instance[i].ToolEmulation {
+				task.Request, _ = sjson.Set(task.Request, toolcall.EmulationKey, true)
+			}
 		}
 	}
 
+	if task.ImagesGenerations {
+		return cp.processImagesTask(instanceName, appConfigRunner, ctx, task)
+	}
+
 	streamResult := gjson.Get(task.Request, "stream")
 	if streamResult.Type == gjson.True {
 		return cp.processStreamingTask(instanceName, appConfigRunner, ctx, task)
@@ -64,7 +95,6 @@ func (cp *ChatProcessor) ProcessTask(ctx context.Context, task *RequestTask) *Ta
 // processNonStreamingTask processes a non-streaming request
 func (cp *ChatProcessor) processNonStreamingTask(instanceName string, appConfigRunner config.AppConfigRunner, ctx context.Context, task *RequestTask) *TaskResponse {
 
-	var fullResponse strings.Builder
 	var done bool
 	channel := make(chan *adapter.AdapterResponse)
 	errChannel := make(chan error)
@@ -74,13 +104,11 @@ func (cp *ChatProcessor) processNonStreamingTask(instanceName string, appConfigR
 	r, errNewRunnerManager := runner.NewRunnerManager(instanceName, appConfigRunner, page, cp.debug)
 	go func() {
 		if errNewRunnerManager != nil {
+			errChannel <- errNewRunnerManager
 			log.Debug(errNewRunnerManager)
 			return
 		}
-		r.SetVariable("REQUEST", task.Request, "string")
-		r.SetVariable("PAGE", page, "ptr")
-		r.SetVariable("PAGE-DATA-CHANNEL", channel, "ptr")
-		err := r.Run("chat_completions")
+		err := cp.runChatCompletions(r, instanceName, appConfigRunner, page, task, channel)
 		if err != nil {
 			errChannel <- err
 			log.Debug(err)
@@ -91,58 +119,97 @@ func (cp *ChatProcessor) processNonStreamingTask(instanceName string, appConfigR
 
 	go func() {
 		defer close(streamChan)
+		lastData := &adapter.AdapterResponse{}
 		for !done {
+			var data *adapter.AdapterResponse
+			nativeFinishReason := ""
 			select {
 			case err := <-errChannel:
-				streamChan <- fmt.Sprintf(`{"error": %v}`, err)
+				cp.instances.RecordResult(instanceName, err)
+				cp.auditTask(task, instanceName, r, nil, nil, "", err)
+				streamChan <- processorError(err)
 				return
 			case <-ctx.Done():
 				return
-			case data := <-channel:
-				done = data.Done
-				if data.Done {
-					randomStr := generateRandomString(7)
-					timestamp := time.Now().Unix()
-					chatCmplId := fmt.Sprintf("chatcmpl-%s-%d", randomStr, timestamp)
-
-					jsonTemplate := `{"id":"","object":"chat.completion","created":123456,"model":"model","choices":[{"index":0,"message":{"role":"assistant","content":null,"reasoning_content":null,"tool_calls":null},"finish_reason":null,"native_finish_reason":null}]}`
-					jsonOutput, _ := sjson.Set(jsonTemplate, "id", chatCmplId)
-					jsonOutput, _ = sjson.Set(jsonOutput, "created", timestamp)
-
-					if data.Content != "" {
-						jsonOutput, _ = sjson.Set(jsonOutput, "choices.0.message.content", data.Content)
-					} else {
-						jsonOutput, _ = sjson.Set(jsonOutput, "choices.0.message.content", nil)
-					}
+			case <-task.Cancel:
+				// Answer with what the website generated so far
+				go drainResponses(channel)
+				data = &adapter.AdapterResponse{Content: lastData.Content, ReasoningContent: lastData.ReasoningContent, ToolCalls: lastData.ToolCalls, Done: true}
+				nativeFinishReason = "cancelled"
+			case data = <-channel:
+			}
+			lastData = data
 
-					if data.ReasoningContent != "" {
-						jsonOutput, _ = sjson.Set(jsonOutput, "choices.0.message.reasoning_content", data.ReasoningContent)
-					} else {
-						jsonOutput, _ = sjson.Set(jsonOutput, "choices.0.message.reasoning_content", nil)
-					}
+			done = data.Done
+			if data.Done {
+				emulateToolCalls(task.Request, data)
 
-					if data.ToolCalls != "" {
-						jsonOutput, _ = sjson.SetRaw(jsonOutput, "choices.0.message.tool_calls", data.ToolCalls)
-					} else {
-						jsonOutput, _ = sjson.Set(jsonOutput, "choices.0.message.tool_calls", nil)
-					}
+				finishReason := "stop"
+				var toolCalls toolCallStream
+				toolCalls.update(data.ToolCalls, true)
+				if len(toolCalls.calls) > 0 {
+					data.ToolCalls = toolCalls.toolCalls()
+					finishReason = "tool_calls"
+				}
+				if nativeFinishReason == "" {
+					nativeFinishReason = finishReason
+				}
+
+				timestamp := time.Now().Unix()
+				chatCmplId := completionID(task)
 
-					jsonOutput, _ = sjson.Set(jsonOutput, "choices.0.finish_reason", "stop")
-					jsonOutput, _ = sjson.Set(jsonOutput, "choices.0.native_finish_reason", "stop")
+				jsonTemplate := `{"id":"","object":"chat.completion","created":123456,"model":"model","choices":[{"index":0,"message":{"role":"assistant","content":null,"reasoning_content":null,"tool_calls":null},"finish_reason":null,"native_finish_reason":null}]}`
+				jsonOutput, _ := sjson.Set(jsonTemplate, "id", chatCmplId)
+				jsonOutput, _ = sjson.Set(jsonOutput, "created", timestamp)
 
-					streamChan <- jsonOutput
-					break
+				if data.Content != "" {
+					jsonOutput, _ = sjson.Set(jsonOutput, "choices.0.message.content", data.Content)
+				} else {
+					jsonOutput, _ = sjson.Set(jsonOutput, "choices.0.message.content", nil)
 				}
-			}
-		}
 
-		// Create response
-		response := fullResponse.String()
-		if r.NeedReportToken("chat_completions") {
-			promptTokens, completionTokens, totalTokens := r.GetTokenReport()
-			response, _ = sjson.Set(response, "usage.prompt_tokens", promptTokens)
-			response, _ = sjson.Set(response, "usage.completion_tokens", completionTokens)
-			response, _ = sjson.Set(response, "usage.total_tokens", totalTokens)
+				if data.ReasoningContent != "" {
+					jsonOutput, _ = sjson.Set(jsonOutput, "choices.0.message.reasoning_content", data.ReasoningContent)
+				} else {
+					jsonOutput, _ = sjson.Set(jsonOutput, "choices.0.message.reasoning_content", nil)
+				}
+
+				if finishReason == "tool_calls" {
+					jsonOutput, _ = sjson.SetRaw(jsonOutput, "choices.0.message.tool_calls", data.ToolCalls)
+				} else {
+					jsonOutput, _ = sjson.Set(jsonOutput, "choices.0.message.tool_calls", nil)
+				}
+
+				if partsRequested(task.Request) {
+					if err := cp.downloadParts(page, data.Parts); err != nil {
+						log.Warnf("Failed to download the output parts of task %s: %v", task.ID, err)
+					}
+					if partsJson := contentParts(task.Request, data, true); len(gjson.Parse(partsJson).Array()) > 0 {
+						jsonOutput, _ = sjson.SetRaw(jsonOutput, "choices.0.message.content", partsJson)
+					}
+					if annotationsJson := annotations(data); annotationsJson != "" {
+						jsonOutput, _ = sjson.SetRaw(jsonOutput, "choices.0.message.annotations", annotationsJson)
+					}
+				}
+
+				jsonOutput, _ = sjson.Set(jsonOutput, "choices.0.finish_reason", finishReason)
+				jsonOutput, _ = sjson.Set(jsonOutput, "choices.0.native_finish_reason", nativeFinishReason)
+				var taskUsage usage.Usage
+				if nativeFinishReason == "cancelled" {
+					taskUsage = usage.Estimate(task.Request, data)
+				} else {
+					taskUsage = cp.taskUsage(r, task, data)
+					cp.instances.RecordResult(instanceName, nil)
+					if conversationURL, ok := cp.conversationURL(appConfigRunner, page, task, data); ok {
+						go cp.rememberConversation(instanceName, task, data, conversationURL)
+					}
+				}
+				jsonOutput = usage.SetUsage(jsonOutput, taskUsage)
+				cp.auditTask(task, instanceName, r, data, &taskUsage, nativeFinishReason, nil)
+
+				streamChan <- jsonOutput
+				break
+			}
 		}
 	}()
 
@@ -164,13 +231,11 @@ func (cp *ChatProcessor) processStreamingTask(instanceName string, appConfigRunn
 	r, errNewRunnerManager := runner.NewRunnerManager(instanceName, appConfigRunner, page, cp.debug)
 	go func() {
 		if errNewRunnerManager != nil {
+			errChannel <- errNewRunnerManager
 			log.Debug(errNewRunnerManager)
 			return
 		}
-		r.SetVariable("REQUEST", task.Request, "string")
-		r.SetVariable("PAGE", page, "ptr")
-		r.SetVariable("PAGE-DATA-CHANNEL", channel, "ptr")
-		err := r.Run("chat_completions")
+		err := cp.runChatCompletions(r, instanceName, appConfigRunner, page, task, channel)
 		if err != nil {
 			errChannel <- err
 			log.Debug(err)
@@ -186,54 +251,197 @@ func (cp *ChatProcessor) processStreamingTask(instanceName string, appConfigRunn
 		lastContext := ""
 		lastReasoningContent := ""
 
-		randomStr := generateRandomString(7)
 		timestamp := time.Now().Unix()
-		chatCmplId := fmt.Sprintf("chatcmpl-%s-%d", randomStr, timestamp)
+		chatCmplId := completionID(task)
 
 		jsonTemplate := `{"id":"","object":"chat.completion.chunk","created":12345,"model":"model","choices":[{"index":0,"delta":{"role":null,"content":null,"reasoning_content":null,"tool_calls":null},"finish_reason":null,"native_finish_reason":null}]}`
 		jsonTemplate, _ = sjson.Set(jsonTemplate, "id", chatCmplId)
 		jsonTemplate, _ = sjson.Set(jsonTemplate, "created", timestamp)
 
+		includeUsage := gjson.Get(task.Request, "stream_options.include_usage").Bool()
+		var toolCalls toolCallStream
+
 		var done bool
 		for !done {
+			var data *adapter.AdapterResponse
+			nativeFinishReason := ""
 			select {
 			case err := <-errChannel:
-				streamChan <- fmt.Sprintf(`{"error": %v}`, err)
+				cp.instances.RecordResult(instanceName, err)
+				cp.auditTask(task, instanceName, r, nil, nil, "", err)
+				streamChan <- processorError(err)
 				return
 			case <-ctx.Done():
 				return
-			case data := <-channel:
-				done = data.Done
-				jsonOutput := ""
-
-				if len(data.ReasoningContent) > len(lastReasoningContent) {
-					jsonOutput, _ = sjson.Set(jsonTemplate, "choices.0.delta.reasoning_content", data.ReasoningContent[len(lastReasoningContent):])
-					lastReasoningContent = data.ReasoningContent
-				} else if len(data.Content) > len(lastContext) {
-					jsonOutput, _ = sjson.Set(jsonTemplate, "choices.0.delta.content", data.Content[len(lastContext):])
-					lastContext = data.Content
-				} else if data.Done {
-					jsonOutput, _ = sjson.Set(jsonTemplate, "choices.0.finish_reason", "stop")
-					jsonOutput, _ = sjson.Set(jsonOutput, "choices.0.native_finish_reason", "stop")
-					if len(data.ToolCalls) > 0 {
-						jsonOutput, _ = sjson.SetRaw(jsonOutput, "choices.0.delta.tool_calls", data.ToolCalls)
+			case <-task.Cancel:
+				// Close the stream after the deltas sent so far
+				go drainResponses(channel)
+				data = &adapter.AdapterResponse{Content: lastContext, ReasoningContent: lastReasoningContent, Done: true}
+				nativeFinishReason = "cancelled"
+			case data = <-channel:
+			}
+
+			done = data.Done
+			emulateToolCalls(task.Request, data)
+			outputs := make([]string, 0)
+
+			if len(data.ReasoningContent) > len(lastReasoningContent) {
+				jsonOutput, _ := sjson.Set(jsonTemplate, "choices.0.delta.reasoning_content", data.ReasoningContent[len(lastReasoningContent):])
+				outputs = append(outputs, jsonOutput)
+				lastReasoningContent = data.ReasoningContent
+			}
+			if len(data.Content) > len(lastContext) {
+				jsonOutput, _ := sjson.Set(jsonTemplate, "choices.0.delta.content", data.Content[len(lastContext):])
+				outputs = append(outputs, jsonOutput)
+				lastContext = data.Content
+			}
+			for _, toolCallDelta := range toolCalls.update(data.ToolCalls, data.Done) {
+				jsonOutput, _ := sjson.SetRaw(jsonTemplate, "choices.0.delta.tool_calls", "["+toolCallDelta+"]")
+				outputs = append(outputs, jsonOutput)
+			}
+
+			usageOutput := ""
+			if data.Done {
+				finishReason := "stop"
+				if len(toolCalls.calls) > 0 {
+					finishReason = "tool_calls"
+				}
+				if nativeFinishReason == "" {
+					nativeFinishReason = finishReason
+				}
+				if partsRequested(task.Request) {
+					// The text went out as deltas, the other parts follow in one delta before the finish
+					if err := cp.downloadParts(page, data.Parts); err != nil {
+						log.Warnf("Failed to download the output parts of task %s: %v", task.ID, err)
 					}
+					partsOutput := jsonTemplate
+					if partsJson := contentParts(task.Request, data, false); len(gjson.Parse(partsJson).Array()) > 0 {
+						partsOutput, _ = sjson.SetRaw(partsOutput, "choices.0.delta.content", partsJson)
+					}
+					if annotationsJson := annotations(data); annotationsJson != "" {
+						partsOutput, _ = sjson.SetRaw(partsOutput, "choices.0.delta.annotations", annotationsJson)
+					}
+					if partsOutput != jsonTemplate {
+						outputs = append(outputs, partsOutput)
+					}
+				}
+				jsonOutput, _ := sjson.Set(jsonTemplate, "choices.0.finish_reason", finishReason)
+				jsonOutput, _ = sjson.Set(jsonOutput, "choices.0.native_finish_reason", nativeFinishReason)
 
-					if r.NeedReportToken("chat_completions") {
-						promptTokens, completionTokens, totalTokens := r.GetTokenReport()
-						jsonOutput, _ = sjson.Set(jsonOutput, "usage.prompt_tokens", promptTokens)
-						jsonOutput, _ = sjson.Set(jsonOutput, "usage.completion_tokens", completionTokens)
-						jsonOutput, _ = sjson.Set(jsonOutput, "usage.total_tokens", totalTokens)
+				if len(toolCalls.calls) > 0 {
+					data.ToolCalls = toolCalls.toolCalls()
+				}
+				var taskUsage usage.Usage
+				if nativeFinishReason == "cancelled" {
+					taskUsage = usage.Estimate(task.Request, data)
+				} else {
+					taskUsage = cp.taskUsage(r, task, data)
+					cp.instances.RecordResult(instanceName, nil)
+					if conversationURL, ok := cp.conversationURL(appConfigRunner, page, task, data); ok {
+						go cp.rememberConversation(instanceName, task, data, conversationURL)
 					}
 				}
+				cp.auditTask(task, instanceName, r, data, &taskUsage, nativeFinishReason, nil)
+				if includeUsage {
+					// The usage is sent in an extra chunk with empty choices, as OpenAI does
+					usageOutput, _ = sjson.SetRaw(jsonTemplate, "choices", "[]")
+					usageOutput = usage.SetUsage(usageOutput, taskUsage)
+				} else {
+					jsonOutput = usage.SetUsage(jsonOutput, taskUsage)
+				}
+				outputs = append(outputs, jsonOutput)
+			}
 
-				if jsonOutput != "" {
-					if isFirst {
-						jsonOutput, _ = sjson.Set(jsonOutput, "choices.0.delta.role", "assistant")
-						isFirst = false
+			for _, jsonOutput := range outputs {
+				if isFirst {
+					jsonOutput, _ = sjson.Set(jsonOutput, "choices.0.delta.role", "assistant")
+					isFirst = false
+				}
+				streamChan <- jsonOutput
+			}
+			if usageOutput != "" {
+				streamChan <- usageOutput
+			}
+		}
+	}()
+
+	return &TaskResponse{
+		Success: true,
+		Stream:  streamChan,
+		Runner:  r,
+	}
+}
+
+// processImagesTask processes an images generations request. The images are collected from the
+// responses of the whole images_generations workflow, the images referenced by URL are downloaded by the page.
+func (cp *ChatProcessor) processImagesTask(instanceName string, appConfigRunner config.AppConfigRunner, ctx context.Context, task *RequestTask) *TaskResponse {
+	streamChan := make(chan string, 1)
+	channel := make(chan *adapter.AdapterResponse)
+	finished := make(chan error, 1)
+
+	page := cp.pages[instanceName]
+	r, errNewRunnerManager := runner.NewRunnerManager(instanceName, appConfigRunner, page, cp.debug)
+	go func() {
+		if errNewRunnerManager != nil {
+			finished <- errNewRunnerManager
+			return
+		}
+		r.SetVariable("PAGE", page, "ptr")
+		r.SetVariable("REQUEST", task.Request, "string")
+		r.SetVariable("PAGE-DATA-CHANNEL", channel, "ptr")
+		finished <- r.Run("images_generations")
+	}()
+
+	go func() {
+		defer close(streamChan)
+		data := &adapter.AdapterResponse{}
+		images := make([]adapter.Part, 0)
+		seen := make(map[string]bool)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case response := <-channel:
+				if response.Content != "" {
+					data.Content = response.Content
+				}
+				for _, image := range response.PartsOfType(adapter.PartImage) {
+					// The sniffed responses are cumulative, they repeat the images found so far
+					key := image.URL
+					if key == "" {
+						sum := sha256.Sum256(image.Data)
+						key = hex.EncodeToString(sum[:])
 					}
-					streamChan <- jsonOutput
+					if !seen[key] {
+						seen[key] = true
+						images = append(images, image)
+					}
+				}
+			case err := <-finished:
+				if err == nil {
+					err = cp.downloadParts(page, images)
 				}
+				if err == nil && len(images) == 0 {
+					err = fmt.Errorf("the website returned no image")
+				}
+				if err != nil {
+					cp.instances.RecordResult(instanceName, err)
+					cp.auditTask(task, instanceName, r, data, nil, "", err)
+					streamChan <- processorError(err)
+					return
+				}
+
+				imagesJson := `{"created":0,"data":[]}`
+				imagesJson, _ = sjson.Set(imagesJson, "created", time.Now().Unix())
+				for _, image := range images {
+					imageJson, _ := sjson.Set(`{"b64_json":"","mime_type":""}`, "b64_json", base64.StdEncoding.EncodeToString(image.Data))
+					imageJson, _ = sjson.Set(imageJson, "mime_type", image.MimeType)
+					imagesJson, _ = sjson.SetRaw(imagesJson, "data.-1", imageJson)
+				}
+				cp.instances.RecordResult(instanceName, nil)
+				cp.auditTask(task, instanceName, r, data, nil, "stop", nil)
+				streamChan <- imagesJson
+				return
 			}
 		}
 	}()
@@ -245,6 +453,162 @@ func (cp *ChatProcessor) processStreamingTask(instanceName string, appConfigRunn
 	}
 }
 
+// runChatCompletions runs the chat completions workflow. When the request extends the history of a known web
+// conversation by a single user turn, the conversation is opened and only the new turn is sent to the website.
+func (cp *ChatProcessor) runChatCompletions(r *runner.RunnerManager, instanceName string, appConfigRunner config.AppConfigRunner, page *chrome.Page, task *RequestTask, channel chan *adapter.AdapterResponse) error {
+	request := task.Request
+	r.SetVariable("PAGE", page, "ptr")
+	r.SetVariable("CONVERSATION-URL", "", "string")
+	r.SetVariable("CONVERSATION-ID", "", "string")
+
+	if appConfigRunner.OpenConversation != "" && r.HasWorkflow("open_conversation") {
+		if conv, ok := cp.conversations.Continue(instanceName, task.Request); ok {
+			r.SetVariable("CONVERSATION-URL", conv.URL, "string")
+			r.SetVariable("CONVERSATION-ID", conv.ID, "string")
+			err := r.Run("open_conversation")
+			if err != nil {
+				log.Debugf("open conversation %s failed, fall back to a new chat: %v", conv.URL, err)
+				r.SetVariable("CONVERSATION-URL", "", "string")
+				r.SetVariable("CONVERSATION-ID", "", "string")
+			} else {
+				log.Debugf("task %s continues conversation %s", task.ID, conv.URL)
+				request = conversation.LastTurn(task.Request)
+			}
+		}
+	}
+
+	r.SetVariable("REQUEST", request, "string")
+	r.SetVariable("PAGE-DATA-CHANNEL", channel, "ptr")
+	return r.Run("chat_completions")
+}
+
+// conversationURL reads the URL of the web conversation of a finished task. It runs before the final chunk
+// is sent, while the task still holds the page: the next task may navigate it as soon as the client has the
+// final chunk. It returns false when there is no conversation to remember.
+func (cp *ChatProcessor) conversationURL(appConfigRunner config.AppConfigRunner, page *chrome.Page, task *RequestTask, data *adapter.AdapterResponse) (string, bool) {
+	if appConfigRunner.OpenConversation == "" || page == nil {
+		return "", false
+	}
+
+	var currentURL string
+	ctx, cancel := context.WithTimeout(page.GetContext(), 5*time.Second)
+	defer cancel()
+	err := chromedp.Run(ctx, chromedp.Location(&currentURL))
+	if err != nil {
+		log.Debugf("get conversation url of task %s failed: %v", task.ID, err)
+		return "", false
+	}
+	if currentURL == page.URL && data.ConversationID == "" {
+		// The website did not move to a conversation page, there is nothing to reopen
+		return "", false
+	}
+	return currentURL, true
+}
+
+// rememberConversation records the web conversation holding the history of a finished task,
+// so the next turn of the history can continue it
+func (cp *ChatProcessor) rememberConversation(instanceName string, task *RequestTask, data *adapter.AdapterResponse, conversationURL string) {
+	reply := `{"role":"assistant","content":""}`
+	reply, _ = sjson.Set(reply, "content", data.Content)
+	if data.ToolCalls != "" {
+		reply, _ = sjson.SetRaw(reply, "tool_calls", data.ToolCalls)
+	}
+	cp.conversations.Save(instanceName, task.Request, reply, &conversation.Conversation{
+		URL: conversationURL,
+		ID:  data.ConversationID,
+	})
+}
+
+// emulateToolCalls moves the tool call blocks of the generated text into the tool calls of the response
+// when the instance emulates function calling
+func emulateToolCalls(requestJson string, data *adapter.AdapterResponse) {
+	if !toolcall.Active(requestJson) {
+		return
+	}
+
+	content, calls := toolcall.Extract(data.Content, data.Done)
+	data.Content = content
+	if len(calls) == 0 {
+		return
+	}
+
+	toolCalls := "[]"
+	for _, call := range calls {
+		toolCall, _ := sjson.Set(`{"type":"function","function":{"name":"","arguments":""}}`, "function.name", call.Name)
+		toolCall, _ = sjson.Set(toolCall, "function.arguments", call.Arguments)
+		toolCalls, _ = sjson.SetRaw(toolCalls, "-1", toolCall)
+	}
+	data.ToolCalls = toolCalls
+}
+
+// taskUsage returns the token usage of a finished task, reported by the website when the runner
+// supports it and estimated otherwise
+func (cp *ChatProcessor) taskUsage(r *runner.RunnerManager, task *RequestTask, data *adapter.AdapterResponse) usage.Usage {
+	if r.NeedReportToken("chat_completions") {
+		promptTokens, completionTokens, totalTokens, ok := r.GetTokenReport(tokenReportTimeout)
+		if ok {
+			return usage.Usage{
+				PromptTokens:     promptTokens,
+				CompletionTokens: completionTokens,
+				TotalTokens:      totalTokens,
+			}
+		}
+		log.Debugf("token report of task %s is not available, estimating usage", task.ID)
+	}
+	return usage.Estimate(task.Request, data)
+}
+
+// auditTask writes the audit record of a finished task, data is nil when the task failed
+func (cp *ChatProcessor) auditTask(task *RequestTask, instanceName string, r *runner.RunnerManager, data *adapter.AdapterResponse, taskUsage *usage.Usage, finishReason string, err error) {
+	record := audit.Record{
+		TaskID:       task.ID,
+		APIKey:       task.APIKey,
+		Instance:     instanceName,
+		Model:        gjson.Get(task.Request, "model").String(),
+		Stream:       gjson.Get(task.Request, "stream").Bool(),
+		CreatedAt:    task.CreatedAt,
+		StartedAt:    task.StartedAt,
+		FinishedAt:   time.Now(),
+		Prompt:       r.Prompt(),
+		Usage:        taskUsage,
+		FinishReason: finishReason,
+	}
+	if data != nil {
+		record.Content = data.Content
+		record.ReasoningContent = data.ReasoningContent
+		record.ToolCalls = data.ToolCalls
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	cp.audit.Write(record)
+}
+
+// completionID returns the chat completion id of a task, it carries the task id used to cancel it
+func completionID(task *RequestTask) string {
+	return "chatcmpl-" + task.ID
+}
+
+// drainResponses consumes the adapter responses of a cancelled task, so the aborted runner is not blocked sending them
+func drainResponses(channel chan *adapter.AdapterResponse) {
+	for {
+		select {
+		case data := <-channel:
+			if data.Done {
+				return
+			}
+		case <-time.After(30 * time.Second):
+			return
+		}
+	}
+}
+
+// processorError renders a runner error as an OpenAI error json
+func processorError(err error) string {
+	errorJson, _ := sjson.Set(`{"error":{"message":"","type":"server_error"}}`, "error.message", err.Error())
+	return errorJson
+}
+
 func generateRandomString(length int) string {
 	const charset = "abcdefghijklmnopqrstuvwxyz0123456789"
 	b := make([]byte, length)
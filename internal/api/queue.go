@@ -17,6 +17,7 @@ type RequestQueue struct {
 	cancel    context.CancelFunc
 	wg        sync.WaitGroup
 	processor TaskProcessor
+	jobs      *JobStore
 }
 
 // TaskProcessor defines the interface for processing tasks
@@ -32,6 +33,7 @@ func NewRequestQueue(processor TaskProcessor) *RequestQueue {
 		ctx:       ctx,
 		cancel:    cancel,
 		processor: processor,
+		jobs:      NewJobStore(),
 	}
 }
 
@@ -101,6 +103,13 @@ func (q *RequestQueue) processLoop() {
 				return
 			}
 
+			if task.Cancelled() {
+				// The task was cancelled while waiting, drop it without touching the browser
+				log.Debugf("Task %s was cancelled in the queue", task.ID)
+				task.Response <- &TaskResponse{Success: false, Error: errTaskCancelled}
+				continue
+			}
+
 			log.Debugf("Processing task %s", task.ID)
 			startTime := time.Now()
 
@@ -127,6 +136,11 @@ func (q *RequestQueue) processLoop() {
 	}
 }
 
+// Jobs returns the background jobs of the queue
+func (q *RequestQueue) Jobs() *JobStore {
+	return q.jobs
+}
+
 // GetQueueLength returns the current number of tasks in the queue
 func (q *RequestQueue) GetQueueLength() int {
 	return len(q.tasks)
@@ -0,0 +1,437 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"net/http"
+	"time"
+)
+
+// Responses handles the OpenAI /v1/responses endpoint
+func (h *APIHandlers) Responses(c *gin.Context) {
+	rawJson, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("Invalid request: %v", err),
+				Type:    "invalid_request_error",
+			},
+		})
+		return
+	}
+
+	chatJson, err := convertResponsesRequest(rawJson)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetail{
+				Message: err.Error(),
+				Type:    "invalid_request_error",
+			},
+		})
+		return
+	}
+
+	task, status, errResponse := h.dispatchTask(c, []byte(chatJson))
+	if errResponse != nil {
+		c.JSON(status, errResponse)
+		return
+	}
+	defer task.Release()
+
+	writer := &responsesWriter{
+		stream:       gjson.GetBytes(rawJson, "stream").Type == gjson.True,
+		model:        gjson.GetBytes(rawJson, "model").String(),
+		instructions: gjson.GetBytes(rawJson, "instructions").String(),
+	}
+	h.writeResponse(c, task, writer)
+}
+
+// convertResponsesRequest converts an OpenAI responses request into the internal chat completion request
+func convertResponsesRequest(rawJson []byte) (string, error) {
+	root := gjson.ParseBytes(rawJson)
+
+	chatJson := `{"model":"","messages":[]}`
+	chatJson, _ = sjson.Set(chatJson, "model", root.Get("model").String())
+	if root.Get("stream").Type == gjson.True {
+		chatJson, _ = sjson.Set(chatJson, "stream", true)
+	}
+	if maxTokensResult := root.Get("max_output_tokens"); maxTokensResult.Type == gjson.Number {
+		chatJson, _ = sjson.Set(chatJson, "max_tokens", maxTokensResult.Int())
+	}
+	if temperatureResult := root.Get("temperature"); temperatureResult.Type == gjson.Number {
+		chatJson, _ = sjson.Set(chatJson, "temperature", temperatureResult.Float())
+	}
+	if topPResult := root.Get("top_p"); topPResult.Type == gjson.Number {
+		chatJson, _ = sjson.Set(chatJson, "top_p", topPResult.Float())
+	}
+	if effortResult := root.Get("reasoning.effort"); effortResult.Type == gjson.String {
+		chatJson, _ = sjson.Set(chatJson, "reasoning_effort", effortResult.String())
+	}
+
+	if instructionsResult := root.Get("instructions"); instructionsResult.Type == gjson.String && instructionsResult.String() != "" {
+		chatJson, _ = sjson.SetRaw(chatJson, "messages.-1", systemMessage(instructionsResult.String()))
+	}
+
+	inputResult := root.Get("input")
+	if inputResult.Type == gjson.String {
+		message, _ := sjson.Set(`{"role":"user","content":""}`, "content", inputResult.String())
+		chatJson, _ = sjson.SetRaw(chatJson, "messages.-1", message)
+	} else if inputResult.IsArray() {
+		// Consecutive function calls belong to the same assistant message
+		pendingToolCalls := ""
+		flushToolCalls := func() {
+			if pendingToolCalls != "" {
+				message, _ := sjson.SetRaw(`{"role":"assistant","content":null}`, "tool_calls", "["+pendingToolCalls+"]")
+				chatJson, _ = sjson.SetRaw(chatJson, "messages.-1", message)
+				pendingToolCalls = ""
+			}
+		}
+
+		for _, item := range inputResult.Array() {
+			itemType := item.Get("type").String()
+			if itemType == "" && item.Get("role").Exists() {
+				itemType = "message"
+			}
+
+			switch itemType {
+			case "message":
+				flushToolCalls()
+				message, err := convertResponsesMessage(item)
+				if err != nil {
+					return "", err
+				}
+				chatJson, _ = sjson.SetRaw(chatJson, "messages.-1", message)
+			case "function_call":
+				toolCall, _ := sjson.Set(`{"id":"","type":"function","function":{"name":"","arguments":""}}`, "id", item.Get("call_id").String())
+				toolCall, _ = sjson.Set(toolCall, "function.name", item.Get("name").String())
+				toolCall, _ = sjson.Set(toolCall, "function.arguments", item.Get("arguments").String())
+				if pendingToolCalls != "" {
+					pendingToolCalls = pendingToolCalls + ","
+				}
+				pendingToolCalls = pendingToolCalls + toolCall
+			case "function_call_output":
+				flushToolCalls()
+				output := item.Get("output")
+				toolMessage, _ := sjson.Set(`{"role":"tool","tool_call_id":"","content":""}`, "tool_call_id", item.Get("call_id").String())
+				if output.Type == gjson.String {
+					toolMessage, _ = sjson.Set(toolMessage, "content", output.String())
+				} else {
+					toolMessage, _ = sjson.Set(toolMessage, "content", output.Raw)
+				}
+				chatJson, _ = sjson.SetRaw(chatJson, "messages.-1", toolMessage)
+			}
+		}
+		flushToolCalls()
+	} else {
+		return "", fmt.Errorf("input: field required")
+	}
+
+	// Tools
+	for _, tool := range root.Get("tools").Array() {
+		if tool.Get("type").String() != "function" {
+			continue
+		}
+		function := `{"type":"function","function":{"name":""}}`
+		function, _ = sjson.Set(function, "function.name", tool.Get("name").String())
+		if descriptionResult := tool.Get("description"); descriptionResult.Type == gjson.String {
+			function, _ = sjson.Set(function, "function.description", descriptionResult.String())
+		}
+		if parametersResult := tool.Get("parameters"); parametersResult.IsObject() {
+			function, _ = sjson.SetRaw(function, "function.parameters", parametersResult.Raw)
+		}
+		chatJson, _ = sjson.SetRaw(chatJson, "tools.-1", function)
+	}
+	toolChoiceResult := root.Get("tool_choice")
+	if toolChoiceResult.Type == gjson.String {
+		chatJson, _ = sjson.Set(chatJson, "tool_choice", toolChoiceResult.String())
+	} else if toolChoiceResult.Get("type").String() == "function" {
+		toolChoice, _ := sjson.Set(`{"type":"function","function":{"name":""}}`, "function.name", toolChoiceResult.Get("name").String())
+		chatJson, _ = sjson.SetRaw(chatJson, "tool_choice", toolChoice)
+	}
+
+	return chatJson, nil
+}
+
+// convertResponsesMessage converts a message input item into an OpenAI chat message
+func convertResponsesMessage(item gjson.Result) (string, error) {
+	role := item.Get("role").String()
+	if role == "developer" {
+		role = "system"
+	}
+	if role != "system" && role != "user" && role != "assistant" {
+		return "", fmt.Errorf("input: unexpected role \"%s\"", role)
+	}
+
+	contentResult := item.Get("content")
+	if contentResult.Type == gjson.String {
+		message, _ := sjson.Set(`{"role":"","content":""}`, "role", role)
+		message, _ = sjson.Set(message, "content", contentResult.String())
+		return message, nil
+	}
+
+	message, _ := sjson.Set(`{"role":"","content":[]}`, "role", role)
+	for _, part := range contentResult.Array() {
+		switch part.Get("type").String() {
+		case "input_text", "output_text", "text":
+			contentPart, _ := sjson.Set(`{"type":"text","text":""}`, "text", part.Get("text").String())
+			message, _ = sjson.SetRaw(message, "content.-1", contentPart)
+		case "input_image":
+			imageURL := part.Get("image_url").String()
+			if imageURL == "" {
+				return "", fmt.Errorf("input: input_image without image_url is not supported")
+			}
+			contentPart, _ := sjson.Set(`{"type":"image_url","image_url":{"url":""}}`, "image_url.url", imageURL)
+			message, _ = sjson.SetRaw(message, "content.-1", contentPart)
+		}
+	}
+	return message, nil
+}
+
+// responsesWriter converts the chat processor output into a responses object or a responses event stream
+type responsesWriter struct {
+	stream       bool
+	model        string
+	instructions string
+	id           string
+	createdAt    int64
+	sequence     int
+	output       []string
+
+	// the output item currently being streamed
+	itemType     string
+	itemID       string
+	itemText     string
+	itemCallID   string
+	itemName     string
+	itemTool     int64
+	inputTokens  int64
+	outputTokens int64
+	failed       bool
+}
+
+func (w *responsesWriter) Begin(c *gin.Context) {
+	w.id = fmt.Sprintf("resp_%s%d", generateRandomString(16), time.Now().Unix())
+	w.createdAt = time.Now().Unix()
+	w.output = make([]string, 0)
+	if !w.stream {
+		c.Header("Content-Type", "application/json")
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("Access-Control-Allow-Origin", "*")
+	w.event(c, "response.created", `{"type":"response.created"}`, "response", w.response("in_progress"))
+	w.event(c, "response.in_progress", `{"type":"response.in_progress"}`, "response", w.response("in_progress"))
+}
+
+// response builds the response object with the output items finished so far
+func (w *responsesWriter) response(status string) string {
+	response := `{"id":"","object":"response","created_at":0,"status":"","model":"","output":[],"usage":null}`
+	response, _ = sjson.Set(response, "id", w.id)
+	response, _ = sjson.Set(response, "created_at", w.createdAt)
+	response, _ = sjson.Set(response, "status", status)
+	response, _ = sjson.Set(response, "model", w.model)
+	if w.instructions != "" {
+		response, _ = sjson.Set(response, "instructions", w.instructions)
+	}
+	for _, item := range w.output {
+		response, _ = sjson.SetRaw(response, "output.-1", item)
+	}
+	if status == "completed" {
+		response, _ = sjson.Set(response, "usage.input_tokens", w.inputTokens)
+		response, _ = sjson.Set(response, "usage.output_tokens", w.outputTokens)
+		response, _ = sjson.Set(response, "usage.total_tokens", w.inputTokens+w.outputTokens)
+	}
+	return response
+}
+
+// event writes a server-sent event, the raw value is set at path of the event data
+func (w *responsesWriter) event(c *gin.Context, name, data, path, raw string) {
+	if !w.stream {
+		return
+	}
+	data, _ = sjson.Set(data, "sequence_number", w.sequence)
+	w.sequence++
+	if path != "" {
+		data, _ = sjson.SetRaw(data, path, raw)
+	}
+	_, _ = fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", name, data)
+}
+
+// itemEvent builds the common fields of an output item event
+func (w *responsesWriter) itemEvent(eventType string) string {
+	data, _ := sjson.Set(`{"type":""}`, "type", eventType)
+	data, _ = sjson.Set(data, "item_id", w.itemID)
+	data, _ = sjson.Set(data, "output_index", len(w.output))
+	return data
+}
+
+// item builds the output item currently being streamed
+func (w *responsesWriter) item(status string) string {
+	item := ""
+	switch w.itemType {
+	case "reasoning":
+		item = `{"id":"","type":"reasoning","summary":[]}`
+		if status == "completed" {
+			summary, _ := sjson.Set(`{"type":"summary_text","text":""}`, "text", w.itemText)
+			item, _ = sjson.SetRaw(item, "summary.-1", summary)
+		}
+	case "message":
+		item, _ = sjson.Set(`{"id":"","type":"message","status":"","role":"assistant","content":[]}`, "status", status)
+		if status == "completed" {
+			content, _ := sjson.Set(`{"type":"output_text","text":"","annotations":[]}`, "text", w.itemText)
+			item, _ = sjson.SetRaw(item, "content.-1", content)
+		}
+	case "function_call":
+		item, _ = sjson.Set(`{"id":"","type":"function_call","status":"","call_id":"","name":"","arguments":""}`, "status", status)
+		item, _ = sjson.Set(item, "call_id", w.itemCallID)
+		item, _ = sjson.Set(item, "name", w.itemName)
+		if status == "completed" {
+			item, _ = sjson.Set(item, "arguments", w.itemText)
+		}
+	}
+	item, _ = sjson.Set(item, "id", w.itemID)
+	return item
+}
+
+// openItem finishes the current output item and starts a new one
+func (w *responsesWriter) openItem(c *gin.Context, itemType, prefix string) {
+	w.closeItem(c)
+	w.itemType = itemType
+	w.itemID = fmt.Sprintf("%s_%s", prefix, generateRandomString(24))
+	w.itemText = ""
+
+	added, _ := sjson.Set(`{"type":"response.output_item.added"}`, "output_index", len(w.output))
+	w.event(c, "response.output_item.added", added, "item", w.item("in_progress"))
+	switch itemType {
+	case "reasoning":
+		data, _ := sjson.Set(w.itemEvent("response.reasoning_summary_part.added"), "summary_index", 0)
+		w.event(c, "response.reasoning_summary_part.added", data, "part", `{"type":"summary_text","text":""}`)
+	case "message":
+		data, _ := sjson.Set(w.itemEvent("response.content_part.added"), "content_index", 0)
+		w.event(c, "response.content_part.added", data, "part", `{"type":"output_text","text":"","annotations":[]}`)
+	}
+}
+
+func (w *responsesWriter) closeItem(c *gin.Context) {
+	if w.itemType == "" {
+		return
+	}
+	switch w.itemType {
+	case "reasoning":
+		data, _ := sjson.Set(w.itemEvent("response.reasoning_summary_text.done"), "summary_index", 0)
+		data, _ = sjson.Set(data, "text", w.itemText)
+		w.event(c, "response.reasoning_summary_text.done", data, "", "")
+		part, _ := sjson.Set(`{"type":"summary_text","text":""}`, "text", w.itemText)
+		data, _ = sjson.Set(w.itemEvent("response.reasoning_summary_part.done"), "summary_index", 0)
+		w.event(c, "response.reasoning_summary_part.done", data, "part", part)
+	case "message":
+		data, _ := sjson.Set(w.itemEvent("response.output_text.done"), "content_index", 0)
+		data, _ = sjson.Set(data, "text", w.itemText)
+		w.event(c, "response.output_text.done", data, "", "")
+		part, _ := sjson.Set(`{"type":"output_text","text":"","annotations":[]}`, "text", w.itemText)
+		data, _ = sjson.Set(w.itemEvent("response.content_part.done"), "content_index", 0)
+		w.event(c, "response.content_part.done", data, "part", part)
+	case "function_call":
+		data, _ := sjson.Set(w.itemEvent("response.function_call_arguments.done"), "arguments", w.itemText)
+		w.event(c, "response.function_call_arguments.done", data, "", "")
+	}
+
+	item := w.item("completed")
+	done, _ := sjson.Set(`{"type":"response.output_item.done"}`, "output_index", len(w.output))
+	w.event(c, "response.output_item.done", done, "item", item)
+	w.output = append(w.output, item)
+	w.itemType = ""
+}
+
+func (w *responsesWriter) Chunk(c *gin.Context, chunk string) {
+	if usageResult := gjson.Get(chunk, "usage"); usageResult.IsObject() {
+		w.inputTokens = usageResult.Get("prompt_tokens").Int()
+		w.outputTokens = usageResult.Get("completion_tokens").Int()
+	}
+
+	// Non-streaming requests produce a single chat completion, replay it as deltas
+	delta := gjson.Get(chunk, "choices.0.delta")
+	if !w.stream {
+		delta = gjson.Get(chunk, "choices.0.message")
+	}
+
+	if reasoningResult := delta.Get("reasoning_content"); reasoningResult.Type == gjson.String && reasoningResult.String() != "" {
+		if w.itemType != "reasoning" {
+			w.openItem(c, "reasoning", "rs")
+		}
+		w.itemText = w.itemText + reasoningResult.String()
+		data, _ := sjson.Set(w.itemEvent("response.reasoning_summary_text.delta"), "summary_index", 0)
+		data, _ = sjson.Set(data, "delta", reasoningResult.String())
+		w.event(c, "response.reasoning_summary_text.delta", data, "", "")
+	}
+	if contentResult := delta.Get("content"); contentResult.Type == gjson.String && contentResult.String() != "" {
+		if w.itemType != "message" {
+			w.openItem(c, "message", "msg")
+		}
+		w.itemText = w.itemText + contentResult.String()
+		data, _ := sjson.Set(w.itemEvent("response.output_text.delta"), "content_index", 0)
+		data, _ = sjson.Set(data, "delta", contentResult.String())
+		w.event(c, "response.output_text.delta", data, "", "")
+	}
+	for _, toolCall := range delta.Get("tool_calls").Array() {
+		index := toolCall.Get("index").Int()
+		// The first delta of a tool call carries its id and name
+		if w.itemType != "function_call" || index != w.itemTool || toolCall.Get("id").String() != "" || toolCall.Get("function.name").String() != "" {
+			w.closeItem(c)
+			w.itemCallID = toolCall.Get("id").String()
+			w.itemName = toolCall.Get("function.name").String()
+			w.itemTool = index
+			w.openItem(c, "function_call", "fc")
+		}
+		if arguments := toolCall.Get("function.arguments").String(); arguments != "" {
+			w.itemText = w.itemText + arguments
+			data, _ := sjson.Set(w.itemEvent("response.function_call_arguments.delta"), "delta", arguments)
+			w.event(c, "response.function_call_arguments.delta", data, "", "")
+		}
+	}
+}
+
+func (w *responsesWriter) Error(c *gin.Context, chunk string) {
+	w.failed = true
+	message := gjson.Get(chunk, "error.message").String()
+	if !w.stream {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: ErrorDetail{
+				Message: message,
+				Type:    "server_error",
+			},
+		})
+		return
+	}
+	data, _ := sjson.Set(`{"type":"error","code":"server_error","message":""}`, "message", message)
+	w.event(c, "error", data, "", "")
+	response, _ := sjson.SetRaw(w.response("failed"), "error", `{"code":"server_error","message":""}`)
+	response, _ = sjson.Set(response, "error.message", message)
+	w.event(c, "response.failed", `{"type":"response.failed"}`, "response", response)
+}
+
+func (w *responsesWriter) Done(c *gin.Context) {
+	if w.failed {
+		return
+	}
+	w.closeItem(c)
+	if !w.stream {
+		c.Status(http.StatusOK)
+		_, _ = c.Writer.Write([]byte(w.response("completed")))
+		return
+	}
+	w.event(c, "response.completed", `{"type":"response.completed"}`, "response", w.response("completed"))
+}
+
+func (w *responsesWriter) KeepAlive(c *gin.Context) {
+	if w.stream {
+		_, _ = c.Writer.Write([]byte(": ANY-AI-PROXY-API PROCESSING\n\n"))
+	} else {
+		_, _ = c.Writer.Write([]byte("\n"))
+	}
+}
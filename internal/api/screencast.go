@@ -0,0 +1,104 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/luispater/anyAIProxyAPI/internal/browser/chrome"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultScreencastQuality = 60
+	defaultScreencastMaxFPS  = 5
+	screencastBoundary       = "frame"
+)
+
+// Screencast handles the GET /screencast endpoint, it streams the page of an instance as MJPEG
+// (multipart/x-mixed-replace) that browsers show in an <img> tag. Viewers share one CDP screencast
+// per page, each one limited to the fps query parameter and the configured max-fps.
+func (h *APIHandlers) Screencast(c *gin.Context) {
+	page, ok := h.screenPage(c)
+	if !ok {
+		return
+	}
+	h.streamScreencast(c, page, c.Query("name"))
+}
+
+// streamScreencast writes the MJPEG stream of a page until the client disconnects
+func (h *APIHandlers) streamScreencast(c *gin.Context, page *chrome.Page, instanceName string) {
+	quality := h.appConfig.Screencast.Quality
+	if quality <= 0 || quality > 100 {
+		quality = defaultScreencastQuality
+	}
+	maxFPS := h.appConfig.Screencast.MaxFPS
+	if maxFPS <= 0 {
+		maxFPS = defaultScreencastMaxFPS
+	}
+	fps := maxFPS
+	if value := c.Query("fps"); value != "" {
+		requested, err := strconv.Atoi(value)
+		if err != nil || requested <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "fps must be a positive number", "code": 400})
+			return
+		}
+		fps = min(requested, maxFPS)
+	}
+
+	flusher, ok := c.Writer.(http.Flusher)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Streaming not supported", "code": 500})
+		return
+	}
+
+	frames, stop, err := page.WatchScreencast(quality)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to start screencast: %v", err), "code": 500})
+		return
+	}
+	defer stop()
+
+	log.Debugf("Screencast viewer %s connected to %s at %d fps", c.ClientIP(), instanceName, fps)
+	c.Header("Content-Type", "multipart/x-mixed-replace; boundary="+screencastBoundary)
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+	flusher.Flush()
+
+	interval := time.Second / time.Duration(fps)
+	var lastWrite time.Time
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			log.Debugf("Screencast viewer %s disconnected from %s", c.ClientIP(), instanceName)
+			return
+		case frame := <-frames:
+			if wait := interval - time.Since(lastWrite); wait > 0 {
+				// Keep the newest frame of the interval
+				select {
+				case <-c.Request.Context().Done():
+					return
+				case <-time.After(wait):
+				}
+				select {
+				case frame = <-frames:
+				default:
+				}
+			}
+			_, err = fmt.Fprintf(c.Writer, "--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", screencastBoundary, len(frame))
+			if err == nil {
+				_, err = c.Writer.Write(frame)
+			}
+			if err == nil {
+				_, err = c.Writer.Write([]byte("\r\n"))
+			}
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+			lastWrite = time.Now()
+		}
+	}
+}
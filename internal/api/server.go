@@ -4,28 +4,39 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/luispater/anyAIProxyAPI/internal/audit"
+	"github.com/luispater/anyAIProxyAPI/internal/auth"
 	"github.com/luispater/anyAIProxyAPI/internal/browser/chrome"
 	"github.com/luispater/anyAIProxyAPI/internal/config"
+	"github.com/luispater/anyAIProxyAPI/internal/instance"
 	log "github.com/sirupsen/logrus"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 // Server represents the API server
 type Server struct {
-	engine    *gin.Engine
-	server    *http.Server
-	queue     *RequestQueue
-	processor *ChatProcessor
-	handlers  *APIHandlers
+	engine        *gin.Engine
+	server        *http.Server
+	queue         *RequestQueue
+	processor     *ChatProcessor
+	handlers      *APIHandlers
+	authenticator *auth.Authenticator
+	adminKey      string
 }
 
 // ServerConfig contains configuration for the API server
 type ServerConfig struct {
-	Port  string
-	Debug bool
-	Pages *map[string]*chrome.Page
+	Port      string
+	Debug     bool
+	Pages     *map[string]*chrome.Page
+	Browser   *chrome.Manager
+	Instances *instance.Registry
+	Audit     *audit.Logger
 }
 
 // NewServer creates a new API server instance
@@ -35,29 +46,43 @@ func NewServer(config *ServerConfig, appConfig *config.AppConfig) *Server {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
+	// Create the instance registry shared by the processor and the handlers
+	instances := config.Instances
+	if instances == nil {
+		instances = instance.NewRegistry()
+	}
+
 	// Create processor
-	processor := NewChatProcessor(appConfig, *config.Pages, config.Debug)
+	processor := NewChatProcessor(appConfig, *config.Pages, instances, config.Audit, config.Debug)
 
 	// Create queue
 	queue := NewRequestQueue(processor)
 
+	// Create authenticator
+	authenticator := auth.NewAuthenticator(appConfig.ApiKeys)
+	if !authenticator.Enabled() {
+		log.Warn("No API keys configured, the API is open to anyone who can reach it")
+	}
+
 	// Create handlers
-	handlers := NewAPIHandlers(appConfig, queue, *config.Pages, config.Debug)
+	handlers := NewAPIHandlers(appConfig, queue, *config.Pages, config.Browser, instances, authenticator, config.Debug)
 
 	// Create gin engine
 	engine := gin.New()
 
 	// Add middleware
-	engine.Use(gin.Logger())
+	engine.Use(gin.LoggerWithConfig(gin.LoggerConfig{Formatter: redactedLogFormatter}))
 	engine.Use(gin.Recovery())
 	engine.Use(corsMiddleware())
 
 	// Create server instance
 	s := &Server{
-		engine:    engine,
-		queue:     queue,
-		processor: processor,
-		handlers:  handlers,
+		engine:        engine,
+		queue:         queue,
+		processor:     processor,
+		handlers:      handlers,
+		authenticator: authenticator,
+		adminKey:      appConfig.AdminKey,
 	}
 
 	// Setup routes
@@ -75,11 +100,74 @@ func NewServer(config *ServerConfig, appConfig *config.AppConfig) *Server {
 // setupRoutes configures the API routes
 func (s *Server) setupRoutes() {
 	// OpenAI compatible API routes
-	v1 := s.engine.Group("/v1")
+	v1 := s.engine.Group("/v1", authMiddleware(s.authenticator))
 	{
 		v1.POST("/chat/completions", s.handlers.ChatCompletions)
+		v1.DELETE("/chat/completions/:id", s.handlers.CancelChatCompletion)
+		v1.GET("/models", s.handlers.Models)
+		v1.GET("/models/*model", s.handlers.RetrieveModel)
+		v1.POST("/responses", s.handlers.Responses)
+		v1.POST("/images/generations", s.handlers.ImagesGenerations)
+		v1.POST("/jobs", s.handlers.CreateJob)
+		v1.GET("/jobs/:id", s.handlers.GetJob)
+		v1.POST("/files", s.handlers.UploadFile)
+		v1.GET("/files", s.handlers.ListFiles)
+		v1.GET("/files/:id", s.handlers.RetrieveFile)
+		v1.GET("/files/:id/content", s.handlers.RetrieveFileContent)
+		v1.DELETE("/files/:id", s.handlers.DeleteFile)
+		v1.POST("/batches", s.handlers.CreateBatch)
+		v1.GET("/batches", s.handlers.ListBatches)
+		v1.GET("/batches/:id", s.handlers.RetrieveBatch)
+		v1.POST("/batches/:id/cancel", s.handlers.CancelBatch)
+
+		// Anthropic compatible API routes
+		v1.POST("/messages", s.handlers.ClaudeMessages)
+	}
+
+	// Gemini compatible API routes
+	v1beta := s.engine.Group("/v1beta", authMiddleware(s.authenticator))
+	{
+		v1beta.GET("/models", s.handlers.GeminiModels)
+		v1beta.GET("/models/*action", s.handlers.GeminiGetModel)
+		v1beta.POST("/models/*action", s.handlers.GeminiGenerateContent)
+	}
+
+	// Ollama compatible API routes
+	ollama := s.engine.Group("/api", authMiddleware(s.authenticator))
+	{
+		ollama.POST("/chat", s.handlers.OllamaChat)
+		ollama.POST("/generate", s.handlers.OllamaGenerate)
+		ollama.GET("/tags", s.handlers.OllamaTags)
+		ollama.POST("/show", s.handlers.OllamaShow)
+		ollama.GET("/version", s.handlers.OllamaVersion)
+	}
+
+	// Admin API routes, only served when an admin key is configured
+	if s.adminKey != "" {
+		admin := s.engine.Group("/admin", adminMiddleware(s.adminKey))
+		{
+			admin.GET("/instances", s.handlers.AdminInstances)
+			admin.GET("/instances/:name", s.handlers.AdminInstance)
+			admin.POST("/instances/:name/:action", s.handlers.AdminInstanceAction)
+			admin.POST("/instances/:name/input", s.handlers.AdminInput)
+			admin.POST("/browser/clear-cookies", s.handlers.AdminClearCookies)
+		}
+		// The console page holds no secret, the operator enters the admin key in it. Its screencast image
+		// authenticates with the cookie of the attach action, an image cannot send the key in a header.
+		s.engine.GET("/admin/instances/:name/console", s.handlers.AdminConsole)
+		s.engine.GET("/admin/instances/:name/screencast", consoleMiddleware(s.adminKey, s.handlers), s.handlers.AdminScreencast)
+	} else {
+		log.Info("No admin key configured, the admin API is disabled")
 	}
 
+	// Generated images, their random ids are the access check
+	s.engine.GET("/images/:id", s.handlers.ImageFile)
+
+	// Health probes, open to load balancers without an API key
+	s.engine.GET("/healthz", s.handlers.Healthz)
+	s.engine.GET("/readyz", s.handlers.Readyz)
+	s.engine.GET("/readyz/:name", s.handlers.ReadyzInstance)
+
 	// Root endpoint
 	s.engine.GET("/", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
@@ -87,11 +175,38 @@ func (s *Server) setupRoutes() {
 			"version": "1.0.0",
 			"endpoints": []string{
 				"POST /v1/chat/completions",
+				"DELETE /v1/chat/completions/{id}",
+				"GET /v1/models",
+				"GET /v1/models/{instance}/{model}",
+				"POST /v1/responses",
+				"POST /v1/images/generations",
+				"POST /v1/jobs",
+				"GET /v1/jobs/{id}",
+				"POST /v1/files",
+				"GET /v1/files/{id}/content",
+				"POST /v1/batches",
+				"GET /v1/batches/{id}",
+				"POST /v1/batches/{id}/cancel",
+				"POST /v1/messages",
+				"GET /v1beta/models",
+				"POST /v1beta/models/{instance}/{model}:generateContent",
+				"POST /v1beta/models/{instance}/{model}:streamGenerateContent",
+				"POST /api/chat",
+				"POST /api/generate",
+				"GET /api/tags",
+				"GET /healthz",
+				"GET /readyz",
+				"GET /readyz/{name}",
+				"GET /admin/instances",
+				"POST /admin/instances/{name}/{action}",
+				"GET /admin/instances/{name}/console",
+				"POST /admin/browser/clear-cookies",
 			},
 		})
 	})
 
-	s.engine.GET("/screenshot", s.handlers.TakeScreenshot)
+	s.engine.GET("/screenshot", authMiddleware(s.authenticator), s.handlers.TakeScreenshot)
+	s.engine.GET("/screencast", authMiddleware(s.authenticator), s.handlers.Screencast)
 }
 
 // Start starts the API server
@@ -101,6 +216,9 @@ func (s *Server) Start() error {
 		return fmt.Errorf("failed to start request queue: %v", err)
 	}
 
+	// Resume the batches interrupted by the last shutdown
+	s.handlers.ResumeBatches()
+
 	log.Debugf("Starting API server on %s", s.server.Addr)
 
 	// Start the HTTP server
@@ -129,12 +247,43 @@ func (s *Server) Stop(ctx context.Context) error {
 	return nil
 }
 
+// redactedLogFormatter is the default gin access log format, with the key query parameter of the
+// Gemini SDKs redacted so the API keys do not end up in the logs
+func redactedLogFormatter(param gin.LogFormatterParams) string {
+	if path, rawQuery, found := strings.Cut(param.Path, "?"); found {
+		if query, err := url.ParseQuery(rawQuery); err == nil && query.Has("key") {
+			query.Set("key", "REDACTED")
+			param.Path = path + "?" + query.Encode()
+		}
+	}
+
+	var statusColor, methodColor, resetColor string
+	if param.IsOutputColor() {
+		statusColor = param.StatusCodeColor()
+		methodColor = param.MethodColor()
+		resetColor = param.ResetColor()
+	}
+	if param.Latency > time.Minute {
+		param.Latency = param.Latency.Truncate(time.Second)
+	}
+	return fmt.Sprintf("[GIN] %v |%s %3d %s| %13v | %15s |%s %-7s %s %#v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		statusColor, param.StatusCode, resetColor,
+		param.Latency,
+		param.ClientIP,
+		methodColor, param.Method, resetColor,
+		param.Path,
+		param.ErrorMessage,
+	)
+}
+
 // corsMiddleware adds CORS headers
 func corsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, Cache-Control, X-Api-Key, Anthropic-Version, Anthropic-Beta, X-Goog-Api-Key")
+		c.Header("Access-Control-Expose-Headers", "X-Task-Id, X-Cache, Retry-After, X-Ratelimit-Limit-Requests, X-Ratelimit-Remaining-Requests, X-Ratelimit-Reset-Requests")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(http.StatusNoContent)
@@ -144,3 +293,72 @@ func corsMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// authMiddleware rejects requests without a valid API key and stores the key in the context
+func authMiddleware(authenticator *auth.Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authenticator.Enabled() {
+			c.Next()
+			return
+		}
+
+		apiKey := auth.KeyFromRequest(c.Request)
+		if apiKey == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{
+				Error: ErrorDetail{
+					Message: "You didn't provide an API key. Provide it in the Authorization header using Bearer auth.",
+					Type:    "authentication_error",
+					Code:    "missing_api_key",
+				},
+			})
+			return
+		}
+
+		key, ok := authenticator.Authenticate(apiKey)
+		if !ok {
+			log.Warnf("Rejected request to %s from %s: incorrect API key", c.Request.URL.Path, c.ClientIP())
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{
+				Error: ErrorDetail{
+					Message: "Incorrect API key provided.",
+					Type:    "authentication_error",
+					Code:    "invalid_api_key",
+				},
+			})
+			return
+		}
+
+		c.Set(apiKeyContextKey, key)
+		c.Next()
+	}
+}
+
+// consoleMiddleware accepts the console cookie issued to the operator attached to the instance,
+// or the admin key
+func consoleMiddleware(adminKey string, h *APIHandlers) gin.HandlerFunc {
+	admin := adminMiddleware(adminKey)
+	return func(c *gin.Context) {
+		if token, err := c.Cookie(consoleCookieName); err == nil && h.validConsoleToken(c.Param("name"), token) {
+			c.Next()
+			return
+		}
+		admin(c)
+	}
+}
+
+// adminMiddleware rejects requests without the admin key
+func adminMiddleware(adminKey string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !auth.Equal(auth.KeyFromRequest(c.Request), adminKey) {
+			log.Warnf("Rejected admin request to %s from %s: incorrect admin key", c.Request.URL.Path, c.ClientIP())
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{
+				Error: ErrorDetail{
+					Message: "Incorrect admin key provided.",
+					Type:    "authentication_error",
+					Code:    "invalid_admin_key",
+				},
+			})
+			return
+		}
+		c.Next()
+	}
+}
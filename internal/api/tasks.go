@@ -0,0 +1,189 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/luispater/anyAIProxyAPI/internal/adapter"
+	"github.com/luispater/anyAIProxyAPI/internal/usage"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errTaskCancelled is the error of a task cancelled before the processor started it
+var errTaskCancelled = errors.New("task cancelled")
+
+// taskRegistry tracks the tasks from their queueing to their release, so they can be cancelled by id
+type taskRegistry struct {
+	mu    sync.Mutex
+	tasks map[string]*trackedTask
+}
+
+type trackedTask struct {
+	mu            sync.Mutex
+	request       *RequestTask
+	owner         string
+	keyName       string
+	model         string
+	instanceIndex int
+	response      *TaskResponse
+	startedAt     time.Time
+	cancelled     bool
+}
+
+func newTaskRegistry() *taskRegistry {
+	return &taskRegistry{
+		tasks: make(map[string]*trackedTask),
+	}
+}
+
+func (r *taskRegistry) add(request *RequestTask, admission *taskAdmission) *trackedTask {
+	tracked := &trackedTask{
+		request:       request,
+		owner:         admission.key.ID(),
+		keyName:       admission.key.Name(),
+		model:         admission.model,
+		instanceIndex: admission.instanceIndex,
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tasks[request.ID] = tracked
+	return tracked
+}
+
+func (r *taskRegistry) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.tasks, id)
+}
+
+func (r *taskRegistry) get(id string) (*trackedTask, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tracked, ok := r.tasks[id]
+	return tracked, ok
+}
+
+// instanceTasks returns the json of the task running on an instance, or null, and the number of tasks waiting for it
+func (r *taskRegistry) instanceTasks(instanceIndex int) (string, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	current := "null"
+	waiting := 0
+	for _, tracked := range r.tasks {
+		if tracked.instanceIndex != instanceIndex {
+			continue
+		}
+		tracked.mu.Lock()
+		if tracked.response != nil {
+			current = `{"id":"","model":"","api_key":"","created_at":0,"started_at":0,"cancelled":false}`
+			current, _ = sjson.Set(current, "id", tracked.request.ID)
+			current, _ = sjson.Set(current, "model", tracked.model)
+			current, _ = sjson.Set(current, "api_key", tracked.keyName)
+			current, _ = sjson.Set(current, "created_at", tracked.request.CreatedAt.Unix())
+			current, _ = sjson.Set(current, "started_at", tracked.startedAt.Unix())
+			current, _ = sjson.Set(current, "cancelled", tracked.cancelled)
+		} else {
+			waiting++
+		}
+		tracked.mu.Unlock()
+	}
+	return current, waiting
+}
+
+// started records the response of the processor, it reports whether the task was cancelled meanwhile
+func (t *trackedTask) started(response *TaskResponse) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.response = response
+	t.startedAt = time.Now()
+	return t.cancelled
+}
+
+// cancel signals the task to stop. It returns the processor response of a started task,
+// and false when the task was already cancelled.
+func (t *trackedTask) cancel() (*TaskResponse, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cancelled {
+		return nil, false
+	}
+	t.cancelled = true
+	close(t.request.Cancel)
+	return t.response, true
+}
+
+// CancelChatCompletion handles the DELETE /v1/chat/completions/{id} endpoint. The id is the X-Task-Id header
+// of the request or the id of its chunks. A queued task is removed from the queue, a running one is stopped
+// in the browser and its stream is closed with the output generated so far.
+func (h *APIHandlers) CancelChatCompletion(c *gin.Context) {
+	key := apiKeyFromContext(c)
+	taskID := strings.TrimPrefix(c.Param("id"), "chatcmpl-")
+	tracked, ok := h.tasks.get(taskID)
+	if !ok || tracked.owner != key.ID() {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: ErrorDetail{
+				Message: fmt.Sprintf("No running chat completion found with id '%s'", c.Param("id")),
+				Type:    "invalid_request_error",
+				Code:    "task_not_found",
+			},
+		})
+		return
+	}
+
+	if response, first := tracked.cancel(); first {
+		log.Infof("Task %s cancelled by API key %s", taskID, key.Name())
+		if response != nil {
+			h.stopTask(tracked.instanceIndex, response)
+		}
+	}
+
+	deleted, _ := sjson.Set(`{"id":"","object":"chat.completion.deleted","deleted":true}`, "id", completionID(tracked.request))
+	c.Header("Content-Type", "application/json")
+	c.String(http.StatusOK, deleted)
+}
+
+// stopTask stops the generation of a started task in the browser and aborts its runner
+func (h *APIHandlers) stopTask(instanceIndex int, response *TaskResponse) {
+	// A task without a runner never ran in the browser, it was cancelled before starting or replayed from the cache
+	if response.Runner == nil {
+		return
+	}
+	h.handleContextCanceled(instanceIndex)
+	response.Runner.Abort()
+}
+
+// cancelledTask returns a task cancelled before it started, its stream only holds the cancelled finish
+func (h *APIHandlers) cancelledTask(admission *taskAdmission, requestTask *RequestTask) *DispatchedTask {
+	log.Debugf("Task %s was cancelled before it started", requestTask.ID)
+
+	output := `{"id":"","object":"chat.completion","created":0,"model":"model","choices":[{"index":0,"message":{"role":"assistant","content":null},"finish_reason":"stop","native_finish_reason":"cancelled"}]}`
+	if gjson.Get(requestTask.Request, "stream").Type == gjson.True {
+		output = `{"id":"","object":"chat.completion.chunk","created":0,"model":"model","choices":[{"index":0,"delta":{"role":"assistant","content":null},"finish_reason":"stop","native_finish_reason":"cancelled"}]}`
+	}
+	output, _ = sjson.Set(output, "id", completionID(requestTask))
+	output, _ = sjson.Set(output, "created", time.Now().Unix())
+	output = usage.SetUsage(output, usage.Estimate(requestTask.Request, &adapter.AdapterResponse{}))
+
+	stream := make(chan string, 1)
+	stream <- output
+	close(stream)
+
+	return &DispatchedTask{
+		ID:            requestTask.ID,
+		InstanceIndex: admission.instanceIndex,
+		Response: &TaskResponse{
+			Success: true,
+			Stream:  stream,
+		},
+		release: func() {
+			h.tasks.remove(requestTask.ID)
+			admission.release()
+		},
+	}
+}
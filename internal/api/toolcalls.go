@@ -0,0 +1,91 @@
+package api
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"strings"
+)
+
+// toolCallStream turns the cumulative tool calls of the adapter responses into OpenAI tool call deltas.
+// Every call keeps the index it was first seen at and gets a generated id when the adapter has none.
+type toolCallStream struct {
+	calls []*streamedToolCall
+}
+
+type streamedToolCall struct {
+	id        string
+	name      string
+	arguments string
+	announced bool
+	sent      string
+	rewritten bool
+}
+
+// update records the tool calls of an adapter response and returns the deltas not sent yet. The first delta of
+// a call carries its name, the following ones the arguments the adapter added since, as they arrive. The closing
+// brackets ending the arguments are held back until the call is finished, when a later call starts or the
+// response is done, since an adapter re-serializing the JSON arguments moves them on every update.
+// When an adapter rewrites arguments already sent, a delta can not take them back: the rewrite is logged,
+// the call gets no further fragments and the complete arguments are only reported by toolCalls.
+func (s *toolCallStream) update(toolCalls string, done bool) []string {
+	for i, toolCall := range gjson.Parse(toolCalls).Array() {
+		if i >= len(s.calls) {
+			id := toolCall.Get("id").String()
+			if id == "" {
+				id = "call_" + generateRandomString(24)
+			}
+			s.calls = append(s.calls, &streamedToolCall{id: id})
+		}
+		call := s.calls[i]
+		if name := toolCall.Get("function.name").String(); name != "" && !call.announced {
+			call.name = name
+		}
+		call.arguments = toolCall.Get("function.arguments").String()
+		if !call.rewritten && !strings.HasPrefix(call.arguments, call.sent) {
+			log.Warnf("Tool call %d rewrote the arguments already streamed, its last arguments are not sent", i)
+			call.rewritten = true
+		}
+	}
+
+	deltas := make([]string, 0)
+	for i, call := range s.calls {
+		delta := ""
+		if !call.announced && call.name != "" {
+			delta, _ = sjson.Set(`{"index":0,"id":"","type":"function","function":{"name":"","arguments":""}}`, "index", i)
+			delta, _ = sjson.Set(delta, "id", call.id)
+			delta, _ = sjson.Set(delta, "function.name", call.name)
+			call.announced = true
+		}
+
+		arguments := call.arguments
+		if !done && i == len(s.calls)-1 {
+			arguments = strings.TrimRight(arguments, "}] \t\r\n")
+		}
+		if !call.rewritten && len(arguments) > len(call.sent) && strings.HasPrefix(arguments, call.sent) {
+			if delta == "" {
+				delta, _ = sjson.Set(`{"index":0,"function":{"arguments":""}}`, "index", i)
+			}
+			delta, _ = sjson.Set(delta, "function.arguments", arguments[len(call.sent):])
+			call.sent = arguments
+		}
+
+		if delta != "" {
+			deltas = append(deltas, delta)
+		}
+	}
+	return deltas
+}
+
+// toolCalls returns the complete tool calls recorded so far
+func (s *toolCallStream) toolCalls() string {
+	toolCalls := "[]"
+	for i, call := range s.calls {
+		toolCall, _ := sjson.Set(`{"id":"","index":0,"type":"function","function":{"name":"","arguments":""}}`, "id", call.id)
+		toolCall, _ = sjson.Set(toolCall, "index", i)
+		toolCall, _ = sjson.Set(toolCall, "function.name", call.name)
+		toolCall, _ = sjson.Set(toolCall, "function.arguments", call.arguments)
+		toolCalls, _ = sjson.SetRaw(toolCalls, "-1", toolCall)
+	}
+	return toolCalls
+}
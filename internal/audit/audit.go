@@ -0,0 +1,271 @@
+package audit
+
+import (
+	"fmt"
+	"github.com/luispater/anyAIProxyAPI/internal/config"
+	"github.com/luispater/anyAIProxyAPI/internal/usage"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFile = "logs/audit.jsonl"
+	redacted    = "[REDACTED]"
+)
+
+// Record is the audit entry of a task sent to a website
+type Record struct {
+	TaskID           string
+	APIKey           string
+	Instance         string
+	Model            string
+	Stream           bool
+	CreatedAt        time.Time
+	StartedAt        time.Time
+	FinishedAt       time.Time
+	Prompt           string
+	Content          string
+	ReasoningContent string
+	ToolCalls        string
+	Usage            *usage.Usage
+	FinishReason     string
+	Error            string
+}
+
+// Logger appends the audit records to a JSONL file, rotated by size and age
+type Logger struct {
+	mu           sync.Mutex
+	path         string
+	file         *os.File
+	size         int64
+	openedAt     time.Time
+	maxSize      int64
+	rotateEvery  time.Duration
+	retention    time.Duration
+	maxBackups   int
+	metadataOnly bool
+	patterns     []*regexp.Regexp
+	replacements []string
+	fields       []string
+}
+
+// NewLogger opens the audit log of the configuration. It returns nil when auditing is disabled,
+// the methods of a nil logger do nothing.
+func NewLogger(auditConfig config.AppConfigAudit) (*Logger, error) {
+	if !auditConfig.Enabled {
+		return nil, nil
+	}
+
+	l := &Logger{
+		path:         auditConfig.File,
+		maxSize:      int64(auditConfig.MaxSizeMB) * 1024 * 1024,
+		rotateEvery:  time.Duration(auditConfig.RotateHours) * time.Hour,
+		retention:    time.Duration(auditConfig.RetentionDays) * 24 * time.Hour,
+		maxBackups:   auditConfig.MaxBackups,
+		metadataOnly: auditConfig.MetadataOnly,
+		fields:       auditConfig.RedactFields,
+	}
+	if l.path == "" {
+		l.path = defaultFile
+	}
+	for _, rule := range auditConfig.Redact {
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid audit redact pattern %s: %w", rule.Pattern, err)
+		}
+		replacement := rule.Replacement
+		if replacement == "" {
+			replacement = redacted
+		}
+		l.patterns = append(l.patterns, pattern)
+		l.replacements = append(l.replacements, replacement)
+	}
+
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	l.cleanup()
+	log.Infof("Audit log enabled, writing to %s", l.path)
+	return l, nil
+}
+
+// Write appends a record, a failed write is logged and does not fail the task
+func (l *Logger) Write(record Record) {
+	if l == nil {
+		return
+	}
+	line := l.render(record) + "\n"
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.needsRotation(int64(len(line))) {
+		if err := l.rotate(); err != nil {
+			log.Errorf("Failed to rotate the audit log: %v", err)
+		}
+	}
+	if l.file == nil {
+		if err := l.open(); err != nil {
+			log.Errorf("Failed to open the audit log: %v", err)
+			return
+		}
+	}
+	n, err := l.file.WriteString(line)
+	l.size += int64(n)
+	if err != nil {
+		log.Errorf("Failed to write the audit record of task %s: %v", record.TaskID, err)
+	}
+}
+
+// render builds the JSON line of a record with the redaction rules applied
+func (l *Logger) render(record Record) string {
+	recordJson := `{"id":"","api_key":"","instance":"","model":"","stream":false,"created_at":"","started_at":null,"finished_at":"","queue_ms":0,"duration_ms":0,"prompt":null,"response":{"content":null,"reasoning_content":null,"tool_calls":null},"usage":null,"finish_reason":null,"error":null}`
+	recordJson, _ = sjson.Set(recordJson, "id", record.TaskID)
+	recordJson, _ = sjson.Set(recordJson, "api_key", record.APIKey)
+	recordJson, _ = sjson.Set(recordJson, "instance", record.Instance)
+	recordJson, _ = sjson.Set(recordJson, "model", record.Model)
+	recordJson, _ = sjson.Set(recordJson, "stream", record.Stream)
+	recordJson, _ = sjson.Set(recordJson, "created_at", record.CreatedAt.Format(time.RFC3339Nano))
+	recordJson, _ = sjson.Set(recordJson, "finished_at", record.FinishedAt.Format(time.RFC3339Nano))
+	if !record.StartedAt.IsZero() {
+		recordJson, _ = sjson.Set(recordJson, "started_at", record.StartedAt.Format(time.RFC3339Nano))
+		recordJson, _ = sjson.Set(recordJson, "queue_ms", record.StartedAt.Sub(record.CreatedAt).Milliseconds())
+		recordJson, _ = sjson.Set(recordJson, "duration_ms", record.FinishedAt.Sub(record.StartedAt).Milliseconds())
+	}
+
+	if !l.metadataOnly {
+		if record.Prompt != "" {
+			recordJson, _ = sjson.Set(recordJson, "prompt", l.redact(record.Prompt))
+		}
+		if record.Content != "" {
+			recordJson, _ = sjson.Set(recordJson, "response.content", l.redact(record.Content))
+		}
+		if record.ReasoningContent != "" {
+			recordJson, _ = sjson.Set(recordJson, "response.reasoning_content", l.redact(record.ReasoningContent))
+		}
+		if gjson.Valid(record.ToolCalls) && gjson.Parse(record.ToolCalls).IsArray() {
+			toolCalls := record.ToolCalls
+			gjson.Parse(record.ToolCalls).ForEach(func(key, toolCall gjson.Result) bool {
+				arguments := toolCall.Get("function.arguments")
+				if arguments.Type == gjson.String {
+					toolCalls, _ = sjson.Set(toolCalls, key.String()+".function.arguments", l.redact(arguments.String()))
+				}
+				return true
+			})
+			recordJson, _ = sjson.SetRaw(recordJson, "response.tool_calls", toolCalls)
+		} else if record.ToolCalls != "" {
+			recordJson, _ = sjson.Set(recordJson, "response.tool_calls", l.redact(record.ToolCalls))
+		}
+	}
+
+	if record.Usage != nil {
+		recordJson = usage.SetUsage(recordJson, *record.Usage)
+		recordJson, _ = sjson.Set(recordJson, "usage.estimated", record.Usage.Estimated)
+	}
+	if record.FinishReason != "" {
+		recordJson, _ = sjson.Set(recordJson, "finish_reason", record.FinishReason)
+	}
+	if record.Error != "" {
+		recordJson, _ = sjson.Set(recordJson, "error", l.redact(record.Error))
+	}
+
+	for _, field := range l.fields {
+		if value := gjson.Get(recordJson, field); value.Exists() && value.Type != gjson.Null {
+			recordJson, _ = sjson.Set(recordJson, field, redacted)
+		}
+	}
+	return recordJson
+}
+
+// redact applies the redaction patterns to a captured text
+func (l *Logger) redact(text string) string {
+	for i, pattern := range l.patterns {
+		text = pattern.ReplaceAllString(text, l.replacements[i])
+	}
+	return text
+}
+
+// open opens the audit file for appending, the caller holds the lock or owns the logger
+func (l *Logger) open() error {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	l.file = file
+	l.size = info.Size()
+	// A reopened file keeps the age it had, the time of its last write is the closest known
+	l.openedAt = time.Now()
+	if info.Size() > 0 {
+		l.openedAt = info.ModTime()
+	}
+	return nil
+}
+
+func (l *Logger) needsRotation(lineSize int64) bool {
+	if l.size == 0 {
+		return false
+	}
+	if l.maxSize > 0 && l.size+lineSize > l.maxSize {
+		return true
+	}
+	return l.rotateEvery > 0 && time.Since(l.openedAt) >= l.rotateEvery
+}
+
+// rotate renames the current file with its rotation time and starts a new one
+func (l *Logger) rotate() error {
+	if l.file != nil {
+		_ = l.file.Close()
+		l.file = nil
+	}
+	extension := filepath.Ext(l.path)
+	rotated := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(l.path, extension), time.Now().Format("20060102T150405.000"), extension)
+	if err := os.Rename(l.path, rotated); err != nil {
+		return err
+	}
+	if err := l.open(); err != nil {
+		return err
+	}
+	go l.cleanup()
+	return nil
+}
+
+// cleanup deletes the rotated files older than the retention and the oldest ones beyond max backups
+func (l *Logger) cleanup() {
+	extension := filepath.Ext(l.path)
+	rotated, err := filepath.Glob(strings.TrimSuffix(l.path, extension) + "-*" + extension)
+	if err != nil {
+		return
+	}
+	// The rotation time in the names sorts them from the oldest
+	sort.Strings(rotated)
+	for i, path := range rotated {
+		remove := l.maxBackups > 0 && len(rotated)-i > l.maxBackups
+		if !remove && l.retention > 0 {
+			if info, errStat := os.Stat(path); errStat == nil && time.Since(info.ModTime()) > l.retention {
+				remove = true
+			}
+		}
+		if remove {
+			if err = os.Remove(path); err != nil {
+				log.Errorf("Failed to delete the audit log %s: %v", path, err)
+			} else {
+				log.Debugf("Deleted the audit log %s", path)
+			}
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"github.com/luispater/anyAIProxyAPI/internal/config"
+	"net/http"
+	"strings"
+)
+
+// Key is an API key allowed to call the server
+type Key struct {
+	Label string
+	// RateLimit overrides the default rate limit of the keys when set
+	RateLimit *config.AppConfigRateLimit
+	allow     []string
+	hash      [32]byte
+	mask      string
+}
+
+// Authenticator checks the API keys of incoming requests
+type Authenticator struct {
+	keys []*Key
+}
+
+// NewAuthenticator creates an authenticator for the configured API keys.
+// Without keys, authentication is disabled and every request is allowed.
+func NewAuthenticator(apiKeys []config.AppConfigApiKey) *Authenticator {
+	keys := make([]*Key, 0, len(apiKeys))
+	for _, apiKey := range apiKeys {
+		if apiKey.Key == "" {
+			continue
+		}
+		keys = append(keys, &Key{
+			Label:     apiKey.Label,
+			RateLimit: apiKey.RateLimit,
+			allow:     apiKey.Allow,
+			hash:      sha256.Sum256([]byte(apiKey.Key)),
+			mask:      maskKey(apiKey.Key),
+		})
+	}
+	return &Authenticator{keys: keys}
+}
+
+// Enabled reports whether API keys are configured
+func (a *Authenticator) Enabled() bool {
+	return len(a.keys) > 0
+}
+
+// Authenticate returns the key matching the presented API key
+func (a *Authenticator) Authenticate(apiKey string) (*Key, bool) {
+	// Compare hashes in constant time, so the response time does not leak key prefixes
+	hash := sha256.Sum256([]byte(apiKey))
+	var matched *Key
+	for _, key := range a.keys {
+		if subtle.ConstantTimeCompare(hash[:], key.hash[:]) == 1 {
+			matched = key
+		}
+	}
+	return matched, matched != nil
+}
+
+// Lookup returns the key with the given ID, for work that outlives the request that authenticated it
+func (a *Authenticator) Lookup(id string) (*Key, bool) {
+	for _, key := range a.keys {
+		if key.ID() == id {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// Equal compares a presented key with an expected one in constant time
+func Equal(presented, expected string) bool {
+	presentedHash := sha256.Sum256([]byte(presented))
+	expectedHash := sha256.Sum256([]byte(expected))
+	return subtle.ConstantTimeCompare(presentedHash[:], expectedHash[:]) == 1
+}
+
+// KeyFromRequest extracts the API key of a request. Besides the OpenAI Authorization: Bearer header,
+// the headers and query parameter of the Anthropic and Gemini SDKs are accepted.
+func KeyFromRequest(r *http.Request) string {
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		if len(authorization) > 7 && strings.EqualFold(authorization[:7], "Bearer ") {
+			return strings.TrimSpace(authorization[7:])
+		}
+	}
+	if apiKey := r.Header.Get("X-Api-Key"); apiKey != "" {
+		return apiKey
+	}
+	if apiKey := r.Header.Get("X-Goog-Api-Key"); apiKey != "" {
+		return apiKey
+	}
+	return r.URL.Query().Get("key")
+}
+
+// Name returns the label of the key for logs, or the masked key when it has no label
+func (k *Key) Name() string {
+	if k == nil {
+		return "anonymous"
+	}
+	if k.Label != "" {
+		return k.Label
+	}
+	return k.mask
+}
+
+// ID returns a stable identifier of the key that does not reveal it
+func (k *Key) ID() string {
+	if k == nil {
+		return "anonymous"
+	}
+	return hex.EncodeToString(k.hash[:8])
+}
+
+// AllowsInstance reports whether the key may use the instance. A nil key allows everything.
+func (k *Key) AllowsInstance(instanceName string) bool {
+	if k == nil || len(k.allow) == 0 {
+		return true
+	}
+	for _, allow := range k.allow {
+		if allow == "*" || allow == instanceName || strings.HasPrefix(allow, instanceName+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsModel reports whether the key may use the model, given as "instance/model". A nil key allows everything.
+func (k *Key) AllowsModel(model string) bool {
+	if k == nil || len(k.allow) == 0 {
+		return true
+	}
+	instanceName := strings.Split(model, "/")[0]
+	for _, allow := range k.allow {
+		if allow == "*" || allow == instanceName || allow == model {
+			return true
+		}
+	}
+	return false
+}
+
+func maskKey(apiKey string) string {
+	if len(apiKey) <= 8 {
+		return "****"
+	}
+	return apiKey[:3] + "..." + apiKey[len(apiKey)-4:]
+}
@@ -0,0 +1,75 @@
+package batch
+
+import (
+	"fmt"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"os"
+	"strings"
+)
+
+// Request is a chat completion request of a batch input file
+type Request struct {
+	Line     int
+	CustomID string
+	Body     string
+}
+
+// ReadRequests parses a batch input file. Every line is either an OpenAI batch request
+// ({"custom_id", "method", "url", "body"}) or a bare chat completion body, optionally with a custom_id.
+// Lines without custom_id are named after their line number.
+func ReadRequests(path, endpoint string) ([]Request, []Error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, []Error{{Code: "invalid_file", Message: fmt.Sprintf("Error reading the input file: %v", err)}}
+	}
+
+	requests := make([]Request, 0)
+	errors := make([]Error, 0)
+	customIDs := make(map[string]bool)
+	for i, line := range strings.Split(string(data), "\n") {
+		lineNumber := i + 1
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !gjson.Valid(line) || !gjson.Parse(line).IsObject() {
+			errors = append(errors, Error{Code: "invalid_json_line", Message: "This line is not parseable as valid JSON.", Line: lineNumber})
+			continue
+		}
+
+		request := Request{Line: lineNumber, CustomID: gjson.Get(line, "custom_id").String()}
+		if bodyResult := gjson.Get(line, "body"); bodyResult.Exists() {
+			if method := gjson.Get(line, "method").String(); method != "" && method != "POST" {
+				errors = append(errors, Error{Code: "invalid_method", Message: fmt.Sprintf("The method '%s' is not supported, only POST is.", method), Line: lineNumber})
+				continue
+			}
+			if url := gjson.Get(line, "url").String(); url != "" && url != endpoint {
+				errors = append(errors, Error{Code: "mismatched_endpoint", Message: fmt.Sprintf("The url '%s' does not match the batch endpoint '%s'.", url, endpoint), Line: lineNumber})
+				continue
+			}
+			request.Body = bodyResult.Raw
+		} else {
+			request.Body, _ = sjson.Delete(line, "custom_id")
+		}
+
+		if gjson.Get(request.Body, "model").String() == "" {
+			errors = append(errors, Error{Code: "missing_required_parameter", Message: "The request body has no model.", Line: lineNumber})
+			continue
+		}
+		if request.CustomID == "" {
+			request.CustomID = fmt.Sprintf("request-%d", lineNumber)
+		}
+		if customIDs[request.CustomID] {
+			errors = append(errors, Error{Code: "duplicate_custom_id", Message: fmt.Sprintf("The custom_id '%s' is used by several requests.", request.CustomID), Line: lineNumber})
+			continue
+		}
+		customIDs[request.CustomID] = true
+		requests = append(requests, request)
+	}
+
+	if len(requests) == 0 && len(errors) == 0 {
+		errors = append(errors, Error{Code: "empty_file", Message: "The input file has no requests."})
+	}
+	return requests, errors
+}
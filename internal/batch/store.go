@@ -0,0 +1,430 @@
+package batch
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	StatusValidating = "validating"
+	StatusFailed     = "failed"
+	StatusInProgress = "in_progress"
+	StatusFinalizing = "finalizing"
+	StatusCompleted  = "completed"
+	StatusCancelling = "cancelling"
+	StatusCancelled  = "cancelled"
+)
+
+// File is an uploaded or generated JSONL file
+type File struct {
+	ID        string `json:"id"`
+	Object    string `json:"object"`
+	Bytes     int64  `json:"bytes"`
+	CreatedAt int64  `json:"created_at"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose"`
+	Owner     string `json:"owner"`
+}
+
+// Batch is a set of chat completion requests processed in the background
+type Batch struct {
+	ID               string            `json:"id"`
+	Object           string            `json:"object"`
+	Endpoint         string            `json:"endpoint"`
+	Errors           *Errors           `json:"errors"`
+	InputFileID      string            `json:"input_file_id"`
+	CompletionWindow string            `json:"completion_window"`
+	Status           string            `json:"status"`
+	OutputFileID     string            `json:"output_file_id,omitempty"`
+	CreatedAt        int64             `json:"created_at"`
+	InProgressAt     int64             `json:"in_progress_at,omitempty"`
+	FinalizingAt     int64             `json:"finalizing_at,omitempty"`
+	CompletedAt      int64             `json:"completed_at,omitempty"`
+	FailedAt         int64             `json:"failed_at,omitempty"`
+	CancellingAt     int64             `json:"cancelling_at,omitempty"`
+	CancelledAt      int64             `json:"cancelled_at,omitempty"`
+	RequestCounts    RequestCounts     `json:"request_counts"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+	Owner            string            `json:"owner"`
+}
+
+// Errors lists the validation errors of a failed batch
+type Errors struct {
+	Object string  `json:"object"`
+	Data   []Error `json:"data"`
+}
+
+// Error is a validation error of a batch input line
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Line    int    `json:"line,omitempty"`
+}
+
+// RequestCounts is the progress of a batch
+type RequestCounts struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+}
+
+// Finished reports whether the batch reached a final status
+func (b Batch) Finished() bool {
+	return b.Status == StatusCompleted || b.Status == StatusFailed || b.Status == StatusCancelled
+}
+
+// JSON renders the file object of the API, without its owner
+func (f File) JSON() string {
+	data, _ := json.Marshal(f)
+	fileJson, _ := sjson.Delete(string(data), "owner")
+	return fileJson
+}
+
+// JSON renders the batch object of the API, without its owner
+func (b Batch) JSON() string {
+	data, _ := json.Marshal(b)
+	batchJson, _ := sjson.Delete(string(data), "owner")
+	return batchJson
+}
+
+// Store keeps the files and batches on disk, so batches survive a restart.
+// Files are stored as <dir>/files/<id>.jsonl with a <id>.json description, batches as <dir>/batches/<id>.json.
+type Store struct {
+	dir     string
+	mu      sync.Mutex
+	files   map[string]*File
+	batches map[string]*Batch
+}
+
+// NewStore opens the store in dir, loading the files and batches of previous runs
+func NewStore(dir string) *Store {
+	s := &Store{
+		dir:     dir,
+		files:   make(map[string]*File),
+		batches: make(map[string]*Batch),
+	}
+	for _, subDir := range []string{"files", "batches"} {
+		if err := os.MkdirAll(filepath.Join(dir, subDir), 0755); err != nil {
+			log.Errorf("Error creating batch directory: %v", err)
+		}
+	}
+
+	descriptions, _ := filepath.Glob(filepath.Join(dir, "files", "*.json"))
+	for _, description := range descriptions {
+		var file File
+		if err := readJSON(description, &file); err != nil {
+			log.Warnf("Error loading file %s: %v", description, err)
+			continue
+		}
+		s.files[file.ID] = &file
+	}
+
+	descriptions, _ = filepath.Glob(filepath.Join(dir, "batches", "*.json"))
+	for _, description := range descriptions {
+		var batch Batch
+		if err := readJSON(description, &batch); err != nil {
+			log.Warnf("Error loading batch %s: %v", description, err)
+			continue
+		}
+		s.batches[batch.ID] = &batch
+	}
+	log.Debugf("Loaded %d files and %d batches from %s", len(s.files), len(s.batches), dir)
+	return s
+}
+
+// CreateFile stores the content of a file
+func (s *Store) CreateFile(owner, filename, purpose string, content io.Reader) (File, error) {
+	file := &File{
+		ID:        "file-" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+		Object:    "file",
+		CreatedAt: time.Now().Unix(),
+		Filename:  filename,
+		Purpose:   purpose,
+		Owner:     owner,
+	}
+
+	f, err := os.Create(s.FilePath(file.ID))
+	if err != nil {
+		return File{}, err
+	}
+	file.Bytes, err = io.Copy(f, content)
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		_ = os.Remove(s.FilePath(file.ID))
+		return File{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err = s.saveFile(file); err != nil {
+		_ = os.Remove(s.FilePath(file.ID))
+		return File{}, err
+	}
+	s.files[file.ID] = file
+	return *file, nil
+}
+
+// File returns a file by id
+func (s *Store) File(id string) (File, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file, ok := s.files[id]
+	if !ok {
+		return File{}, false
+	}
+	return *file, true
+}
+
+// Files returns the files of an owner, newest first
+func (s *Store) Files(owner string) []File {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	files := make([]File, 0)
+	for _, file := range s.files {
+		if file.Owner == owner {
+			files = append(files, *file)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].CreatedAt > files[j].CreatedAt
+	})
+	return files
+}
+
+// FilePath returns the path of the content of a file
+func (s *Store) FilePath(id string) string {
+	return filepath.Join(s.dir, "files", id+".jsonl")
+}
+
+// DeleteFile removes a file and its content
+func (s *Store) DeleteFile(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.files[id]; !ok {
+		return fmt.Errorf("file %s not found", id)
+	}
+	delete(s.files, id)
+	if err := os.Remove(filepath.Join(s.dir, "files", id+".json")); err != nil {
+		return err
+	}
+	return os.Remove(s.FilePath(id))
+}
+
+// CreateBatch stores a new batch
+func (s *Store) CreateBatch(batch Batch) (Batch, error) {
+	batch.ID = "batch_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	batch.Object = "batch"
+	batch.CreatedAt = time.Now().Unix()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.saveBatch(&batch); err != nil {
+		return Batch{}, err
+	}
+	s.batches[batch.ID] = &batch
+	return batch, nil
+}
+
+// Batch returns a batch by id
+func (s *Store) Batch(id string) (Batch, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	batch, ok := s.batches[id]
+	if !ok {
+		return Batch{}, false
+	}
+	return *batch, true
+}
+
+// Batches returns the batches of an owner, newest first
+func (s *Store) Batches(owner string) []Batch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	batches := make([]Batch, 0)
+	for _, batch := range s.batches {
+		if batch.Owner == owner {
+			batches = append(batches, *batch)
+		}
+	}
+	sort.Slice(batches, func(i, j int) bool {
+		return batches[i].CreatedAt > batches[j].CreatedAt
+	})
+	return batches
+}
+
+// Unfinished returns the batches that were still running when the server stopped
+func (s *Store) Unfinished() []Batch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	batches := make([]Batch, 0)
+	for _, batch := range s.batches {
+		if !batch.Finished() {
+			batches = append(batches, *batch)
+		}
+	}
+	sort.Slice(batches, func(i, j int) bool {
+		return batches[i].CreatedAt < batches[j].CreatedAt
+	})
+	return batches
+}
+
+// UpdateBatch changes a batch and writes it to disk
+func (s *Store) UpdateBatch(id string, update func(batch *Batch)) (Batch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	batch, ok := s.batches[id]
+	if !ok {
+		return Batch{}, fmt.Errorf("batch %s not found", id)
+	}
+	update(batch)
+	return *batch, s.saveBatch(batch)
+}
+
+// AppendResult writes a result line to the output file of a batch and counts it
+func (s *Store) AppendResult(id, result string, failed bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	batch, ok := s.batches[id]
+	if !ok {
+		return fmt.Errorf("batch %s not found", id)
+	}
+	file, ok := s.files[batch.OutputFileID]
+	if !ok {
+		return fmt.Errorf("output file of batch %s not found", id)
+	}
+
+	f, err := os.OpenFile(s.FilePath(file.ID), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	n, err := f.WriteString(result + "\n")
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	file.Bytes += int64(n)
+	if err != nil {
+		return err
+	}
+	if err = s.saveFile(file); err != nil {
+		return err
+	}
+
+	if failed {
+		batch.RequestCounts.Failed++
+	} else {
+		batch.RequestCounts.Completed++
+	}
+	return s.saveBatch(batch)
+}
+
+// Results reads the output file of a batch and returns whether each finished custom_id failed.
+// A line cut off by a crash is removed, so the request is run again.
+func (s *Store) Results(id string) (map[string]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	batch, ok := s.batches[id]
+	if !ok {
+		return nil, fmt.Errorf("batch %s not found", id)
+	}
+	file, ok := s.files[batch.OutputFileID]
+	if !ok {
+		return nil, fmt.Errorf("output file of batch %s not found", id)
+	}
+
+	data, err := os.ReadFile(s.FilePath(file.ID))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if end := strings.LastIndexByte(string(data), '\n') + 1; end < len(data) {
+		data = data[:end]
+		if err = os.WriteFile(s.FilePath(file.ID), data, 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	results := make(map[string]bool)
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		result := gjson.Parse(line)
+		results[result.Get("custom_id").String()] = result.Get("error").IsObject()
+	}
+
+	file.Bytes = int64(len(data))
+	batch.RequestCounts.Completed = 0
+	batch.RequestCounts.Failed = 0
+	for _, failed := range results {
+		if failed {
+			batch.RequestCounts.Failed++
+		} else {
+			batch.RequestCounts.Completed++
+		}
+	}
+	if err = s.saveFile(file); err != nil {
+		return nil, err
+	}
+	return results, s.saveBatch(batch)
+}
+
+// CreateOutputFile creates the empty output file of a batch
+func (s *Store) CreateOutputFile(id string) (File, error) {
+	s.mu.Lock()
+	batch, ok := s.batches[id]
+	if !ok {
+		s.mu.Unlock()
+		return File{}, fmt.Errorf("batch %s not found", id)
+	}
+	owner := batch.Owner
+	s.mu.Unlock()
+
+	file, err := s.CreateFile(owner, id+"_output.jsonl", "batch_output", strings.NewReader(""))
+	if err != nil {
+		return File{}, err
+	}
+	_, err = s.UpdateBatch(id, func(batch *Batch) {
+		batch.OutputFileID = file.ID
+	})
+	return file, err
+}
+
+func (s *Store) saveFile(file *File) error {
+	return writeJSON(filepath.Join(s.dir, "files", file.ID+".json"), file)
+}
+
+func (s *Store) saveBatch(batch *Batch) error {
+	return writeJSON(filepath.Join(s.dir, "batches", batch.ID+".json"), batch)
+}
+
+// writeJSON writes a description through a temporary file, so a crash never leaves it half written
+func writeJSON(path string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func readJSON(path string, value interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
@@ -0,0 +1,106 @@
+package blob
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"mime"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+const cleanupInterval = time.Minute
+
+// extensions are the file extensions of the common image types, mime.ExtensionsByType may list rare ones first
+var extensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/webp": ".webp",
+	"image/gif":  ".gif",
+}
+
+// idRegexp is the form of the blob ids, which are also their file names
+var idRegexp = regexp.MustCompile(`^[0-9a-f]{32}(\.[0-9a-z]+)?$`)
+
+// Store keeps generated files on disk for a limited time. A blob is named by a random id with the
+// extension of its MIME type, the unguessable id is the only access check of its URL.
+type Store struct {
+	dir string
+	ttl time.Duration
+}
+
+// NewStore opens the store in dir, blobs older than ttl are deleted in the background
+func NewStore(dir string, ttl time.Duration) *Store {
+	s := &Store{
+		dir: dir,
+		ttl: ttl,
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Errorf("Error creating blob directory: %v", err)
+	}
+	go func() {
+		for {
+			s.cleanup()
+			time.Sleep(cleanupInterval)
+		}
+	}()
+	return s
+}
+
+// Put stores data and returns its id
+func (s *Store) Put(data []byte, mimeType string) (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(random)
+	if extension, ok := extensions[mimeType]; ok {
+		id = id + extension
+	} else if typeExtensions, _ := mime.ExtensionsByType(mimeType); len(typeExtensions) > 0 {
+		id = id + typeExtensions[0]
+	}
+	if err := os.WriteFile(filepath.Join(s.dir, id), data, 0644); err != nil {
+		return "", fmt.Errorf("failed to store blob: %w", err)
+	}
+	return id, nil
+}
+
+// Path returns the file of a blob, os.ErrNotExist when it is unknown or expired
+func (s *Store) Path(id string) (string, error) {
+	if !idRegexp.MatchString(id) {
+		return "", os.ErrNotExist
+	}
+	path := filepath.Join(s.dir, id)
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if time.Since(info.ModTime()) > s.ttl {
+		_ = os.Remove(path)
+		return "", os.ErrNotExist
+	}
+	return path, nil
+}
+
+// cleanup deletes the expired blobs
+func (s *Store) cleanup() {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !idRegexp.MatchString(entry.Name()) {
+			continue
+		}
+		info, errInfo := entry.Info()
+		if errInfo != nil || time.Since(info.ModTime()) <= s.ttl {
+			continue
+		}
+		if err = os.Remove(filepath.Join(s.dir, entry.Name())); err != nil {
+			log.Errorf("Failed to delete the blob %s: %v", entry.Name(), err)
+		}
+	}
+}
@@ -1,8 +1,64 @@
 package chrome
 
-import "github.com/chromedp/cdproto/network"
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+	"os"
+	"path/filepath"
+	"time"
+)
 
 type AuthInfo struct {
 	Cookies      []*network.CookieParam `json:"cookies"`
 	LocalStorage map[string]string      `json:"local_storage"`
 }
+
+// CheckAuth reports whether the page shows the element of the auth check selector, i.e. is logged in
+func CheckAuth(pageCtx context.Context, selector string) (bool, error) {
+	var nodes []*cdp.Node
+
+	timeoutCtx, cancel := context.WithTimeout(pageCtx, 1*time.Second)
+	defer cancel()
+	err := chromedp.Run(timeoutCtx,
+		chromedp.Nodes(selector, &nodes, chromedp.ByQueryAll),
+	)
+	if err != nil {
+		return false, err
+	}
+	return len(nodes) > 0, nil
+}
+
+// SaveAuthInfo writes the cookies and the local storage of the page to the auth file
+func SaveAuthInfo(pageCtx context.Context, authFilePath string) error {
+	cookies, err := GetCookies(pageCtx)
+	if err != nil {
+		return fmt.Errorf("error getting cookies: %w", err)
+	}
+	localStorages, err := GetLocalStorages(pageCtx)
+	if err != nil {
+		return fmt.Errorf("error getting local storages: %w", err)
+	}
+
+	jsonData, err := json.MarshalIndent(map[string]interface{}{"cookies": cookies, "local_storage": localStorages}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling cookies to JSON: %w", err)
+	}
+
+	// Ensure the directory exists
+	authAbsPath, err := filepath.Abs(authFilePath)
+	if err != nil {
+		return fmt.Errorf("error getting absolute path for auth file: %w", err)
+	}
+	if err = os.MkdirAll(filepath.Dir(authAbsPath), 0755); err != nil {
+		return fmt.Errorf("error creating directory %s: %w", filepath.Dir(authAbsPath), err)
+	}
+
+	if err = os.WriteFile(authFilePath, jsonData, 0644); err != nil {
+		return fmt.Errorf("error writing auth info to file %s: %w", authFilePath, err)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package chrome
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const fetchResourceTimeout = 60 * time.Second
+
+// fetchResourceJS downloads a URL in the page and returns it as a data URL. A JSON answer with a
+// download_url is followed, as the ChatGPT files API answers.
+const fetchResourceJS = `(async (url) => {
+	let response = await fetch(url, {credentials: "include"});
+	if (!response.ok) throw new Error("HTTP " + response.status + " fetching " + url);
+	if ((response.headers.get("content-type") || "").includes("json")) {
+		const body = await response.json();
+		if (!body.download_url) throw new Error("no download_url in the answer of " + url);
+		response = await fetch(body.download_url);
+		if (!response.ok) throw new Error("HTTP " + response.status + " fetching " + body.download_url);
+	}
+	const blob = await response.blob();
+	return await new Promise((resolve, reject) => {
+		const reader = new FileReader();
+		reader.onload = () => resolve(reader.result);
+		reader.onerror = () => reject(reader.error);
+		reader.readAsDataURL(blob);
+	});
+})(%s)`
+
+// FetchResource downloads a URL with the cookies of the page and returns its data and MIME type.
+// Relative URLs are resolved against the page, data URLs are decoded without it.
+func FetchResource(pageCtx context.Context, resourceURL string) ([]byte, string, error) {
+	if strings.HasPrefix(resourceURL, "data:") {
+		return DecodeDataURL(resourceURL)
+	}
+
+	urlJson, _ := json.Marshal(resourceURL)
+	ctx, cancel := context.WithTimeout(pageCtx, fetchResourceTimeout)
+	defer cancel()
+	var dataURL string
+	err := chromedp.Run(ctx, chromedp.Evaluate(fmt.Sprintf(fetchResourceJS, urlJson), &dataURL, func(p *runtime.EvaluateParams) *runtime.EvaluateParams {
+		return p.WithAwaitPromise(true)
+	}))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch %s: %w", resourceURL, err)
+	}
+	return DecodeDataURL(dataURL)
+}
+
+// DecodeDataURL decodes a base64 data URL, the MIME type is sniffed when the URL has none
+func DecodeDataURL(dataURL string) ([]byte, string, error) {
+	header, encoded, found := strings.Cut(strings.TrimPrefix(dataURL, "data:"), ",")
+	if !found || !strings.HasSuffix(header, ";base64") {
+		return nil, "", fmt.Errorf("not a base64 data url")
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, "", err
+	}
+	mimeType, _, _ := strings.Cut(strings.TrimSuffix(header, ";base64"), ";")
+	if mimeType == "" || mimeType == "application/octet-stream" {
+		mimeType = http.DetectContentType(data)
+	}
+	return data, mimeType, nil
+}
+
+// CaptureDownload runs an action starting a browser download on the page, like a click on a download
+// button, and returns the downloaded file
+func (p *Page) CaptureDownload(action chromedp.Action, timeout time.Duration) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "download-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	ctx, cancel := context.WithTimeout(p.ctx, timeout)
+	defer cancel()
+	finished := make(chan *browser.EventDownloadProgress, 1)
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		if progress, ok := ev.(*browser.EventDownloadProgress); ok && progress.State != browser.DownloadProgressStateInProgress {
+			select {
+			case finished <- progress:
+			default:
+			}
+		}
+	})
+
+	err = chromedp.Run(ctx,
+		browser.SetDownloadBehavior(browser.SetDownloadBehaviorBehaviorAllowAndName).WithDownloadPath(dir).WithEventsEnabled(true),
+		action,
+	)
+	defer func() {
+		_ = chromedp.Run(p.ctx, browser.SetDownloadBehavior(browser.SetDownloadBehaviorBehaviorDefault))
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case progress := <-finished:
+		if progress.State != browser.DownloadProgressStateCompleted {
+			return nil, fmt.Errorf("download %s", progress.State)
+		}
+		// With allowAndName the file is named by the download guid
+		return os.ReadFile(filepath.Join(dir, progress.GUID))
+	case <-ctx.Done():
+		return nil, fmt.Errorf("download not finished in %s", timeout)
+	}
+}
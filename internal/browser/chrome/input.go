@@ -0,0 +1,112 @@
+package chrome
+
+import (
+	"context"
+	"fmt"
+	"github.com/chromedp/cdproto/input"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+	"github.com/chromedp/chromedp/kb"
+	"sync"
+)
+
+// InputEvent is a mouse, wheel or keyboard event forwarded from the remote console.
+// X and Y are relative to the viewport, from 0 to 1, so the console does not need the page size.
+type InputEvent struct {
+	// Type is mousedown, mouseup, mousemove, wheel or key
+	Type       string
+	X          float64
+	Y          float64
+	Button     string
+	ClickCount int64
+	DeltaX     float64
+	DeltaY     float64
+	// Key is a DOM key value, "a", "Enter" or "ArrowLeft"
+	Key string
+	// Modifiers is the CDP bit field, Alt=1, Ctrl=2, Meta=4, Shift=8
+	Modifiers int64
+}
+
+var (
+	namedKeysOnce sync.Once
+	namedKeys     map[string]rune
+)
+
+// DispatchInput sends an input event of the remote console to the page
+func DispatchInput(pageCtx context.Context, event InputEvent) error {
+	return chromedp.Run(pageCtx, chromedp.ActionFunc(func(ctx context.Context) error {
+		if event.Type == "key" {
+			return dispatchKey(ctx, event)
+		}
+
+		_, _, _, viewport, _, _, err := page.GetLayoutMetrics().Do(ctx)
+		if err != nil {
+			return err
+		}
+		x := event.X * float64(viewport.ClientWidth)
+		y := event.Y * float64(viewport.ClientHeight)
+		modifiers := input.Modifier(event.Modifiers)
+
+		var mouseEvent *input.DispatchMouseEventParams
+		switch event.Type {
+		case "mousedown", "mouseup":
+			mouseType := input.MousePressed
+			if event.Type == "mouseup" {
+				mouseType = input.MouseReleased
+			}
+			button := input.MouseButton(event.Button)
+			if button == "" {
+				button = input.Left
+			}
+			mouseEvent = input.DispatchMouseEvent(mouseType, x, y).
+				WithButton(button).
+				WithClickCount(max(1, event.ClickCount))
+		case "mousemove":
+			mouseEvent = input.DispatchMouseEvent(input.MouseMoved, x, y)
+		case "wheel":
+			mouseEvent = input.DispatchMouseEvent(input.MouseWheel, x, y).
+				WithDeltaX(event.DeltaX).
+				WithDeltaY(event.DeltaY)
+		default:
+			return fmt.Errorf("unknown input event type %s", event.Type)
+		}
+		return mouseEvent.WithModifiers(modifiers).Do(ctx)
+	}))
+}
+
+// dispatchKey sends the keyDown, char and keyUp events of a key press
+func dispatchKey(ctx context.Context, event InputEvent) error {
+	var key rune
+	if runes := []rune(event.Key); len(runes) == 1 {
+		key = runes[0]
+	} else {
+		namedKeysOnce.Do(func() {
+			namedKeys = make(map[string]rune)
+			for r, k := range kb.Keys {
+				if _, exists := namedKeys[k.Key]; !exists && !k.Print {
+					namedKeys[k.Key] = r
+				}
+			}
+			// Enter is printable for kb, so it is missing from the loop above
+			namedKeys["Enter"] = '\r'
+		})
+		var ok bool
+		if key, ok = namedKeys[event.Key]; !ok {
+			return fmt.Errorf("unknown key %s", event.Key)
+		}
+	}
+
+	modifiers := input.Modifier(event.Modifiers)
+	shortcut := modifiers&(input.ModifierCtrl|input.ModifierAlt|input.ModifierMeta) != 0
+	for _, keyEvent := range kb.Encode(key) {
+		// A shortcut like Ctrl+A must not type its character
+		if shortcut && keyEvent.Type == input.KeyChar {
+			continue
+		}
+		keyEvent.Modifiers |= modifiers
+		if err := keyEvent.Do(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -6,7 +6,9 @@ import (
 	"github.com/luispater/anyAIProxyAPI/internal/config"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/chromedp/cdproto/browser"
 	"github.com/chromedp/cdproto/network"
 	"github.com/chromedp/chromedp"
 	log "github.com/sirupsen/logrus"
@@ -130,6 +132,20 @@ func (m *Manager) NewPage(url, adapterName, authFile string) (*Page, error) {
 	return NewPage(m.browserCtx, adapterName, url, authFile, sniffURL)
 }
 
+// Connected reports whether the browser answers CDP commands
+func (m *Manager) Connected() bool {
+	if m.browserCtx == nil || m.browserCtx.Err() != nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(m.browserCtx, 2*time.Second)
+	defer cancel()
+	err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		_, _, _, _, _, err := browser.GetVersion().Do(ctx)
+		return err
+	}))
+	return err == nil
+}
+
 func (m *Manager) Close() error {
 	var firstErr error
 
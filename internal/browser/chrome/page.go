@@ -28,6 +28,7 @@ type Page struct {
 	adapterName  string
 	RequestMutex sync.Mutex
 	URL          string
+	screencast   screencast
 }
 
 func NewPage(browserCtx context.Context, adapterName string, url string, authFilePath string, sniffURLs ...[]string) (*Page, error) {
@@ -235,6 +236,11 @@ func (p *Page) GetLocalStorages() (map[string]string, error) {
 	return localStorage, nil
 }
 
+// Closed reports whether the tab of the page was closed or lost with the browser
+func (p *Page) Closed() bool {
+	return p.ctx.Err() != nil
+}
+
 func (p *Page) Close() {
 	p.cancel()
 }
@@ -0,0 +1,115 @@
+package chrome
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+	log "github.com/sirupsen/logrus"
+	"sync"
+)
+
+// screencast shares the CDP screencast of a page between its viewers.
+// Chrome only sends a frame when the page changes, so the last frame is kept for new viewers.
+type screencast struct {
+	mu        sync.Mutex
+	viewers   map[chan []byte]struct{}
+	lastFrame []byte
+	cancel    context.CancelFunc
+}
+
+// WatchScreencast subscribes to the JPEG frames of the page. The screencast starts with the first viewer
+// and stops when the last one calls the returned stop function. A slow viewer only misses frames.
+func (p *Page) WatchScreencast(quality int) (<-chan []byte, func(), error) {
+	p.screencast.mu.Lock()
+	defer p.screencast.mu.Unlock()
+
+	if p.screencast.viewers == nil {
+		p.screencast.viewers = make(map[chan []byte]struct{})
+	}
+	if len(p.screencast.viewers) == 0 {
+		if err := p.startScreencast(quality); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	frames := make(chan []byte, 1)
+	if p.screencast.lastFrame != nil {
+		frames <- p.screencast.lastFrame
+	}
+	p.screencast.viewers[frames] = struct{}{}
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			p.screencast.mu.Lock()
+			defer p.screencast.mu.Unlock()
+			delete(p.screencast.viewers, frames)
+			if len(p.screencast.viewers) == 0 {
+				p.stopScreencast()
+			}
+		})
+	}
+	return frames, stop, nil
+}
+
+// startScreencast starts the CDP screencast, the caller holds the screencast lock
+func (p *Page) startScreencast(quality int) error {
+	ctx, cancel := context.WithCancel(p.ctx)
+	chromedp.ListenTarget(ctx, func(ev any) {
+		frame, ok := ev.(*page.EventScreencastFrame)
+		if !ok {
+			return
+		}
+		// Chrome stops sending frames until the last one is acknowledged
+		go func() {
+			if err := chromedp.Run(ctx, page.ScreencastFrameAck(frame.SessionID)); err != nil && ctx.Err() == nil {
+				log.Debugf("Failed to acknowledge screencast frame: %v", err)
+			}
+		}()
+
+		data, err := base64.StdEncoding.DecodeString(frame.Data)
+		if err != nil {
+			log.Debugf("Failed to decode screencast frame: %v", err)
+			return
+		}
+		p.broadcastFrame(data)
+	})
+
+	err := chromedp.Run(ctx, page.StartScreencast().WithFormat(page.ScreencastFormatJpeg).WithQuality(int64(quality)))
+	if err != nil {
+		cancel()
+		return err
+	}
+	p.screencast.cancel = cancel
+	log.Debugf("Screencast of %s started", p.URL)
+	return nil
+}
+
+// stopScreencast stops the CDP screencast, the caller holds the screencast lock
+func (p *Page) stopScreencast() {
+	if p.screencast.cancel == nil {
+		return
+	}
+	if err := chromedp.Run(p.ctx, page.StopScreencast()); err != nil {
+		log.Debugf("Failed to stop screencast: %v", err)
+	}
+	p.screencast.cancel()
+	p.screencast.cancel = nil
+	p.screencast.lastFrame = nil
+	log.Debugf("Screencast of %s stopped", p.URL)
+}
+
+// broadcastFrame sends a frame to every viewer, replacing the frame a viewer did not read yet
+func (p *Page) broadcastFrame(data []byte) {
+	p.screencast.mu.Lock()
+	defer p.screencast.mu.Unlock()
+	p.screencast.lastFrame = data
+	for frames := range p.screencast.viewers {
+		select {
+		case <-frames:
+		default:
+		}
+		frames <- data
+	}
+}
@@ -0,0 +1,108 @@
+package chrome
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+	"math"
+)
+
+// ScreenshotOptions selects the area and the image format of a screenshot
+type ScreenshotOptions struct {
+	// Format is png, jpeg or webp, png when empty
+	Format string
+	// Quality is the compression quality of jpeg and webp, from 0 to 100
+	Quality int
+	// FullPage captures the whole scrollable page instead of the viewport
+	FullPage bool
+	// Selector captures the first element matching the CSS selector
+	Selector string
+}
+
+// ContentType returns the MIME type of the screenshot format
+func (o ScreenshotOptions) ContentType() string {
+	switch o.Format {
+	case "jpeg":
+		return "image/jpeg"
+	case "webp":
+		return "image/webp"
+	default:
+		return "image/png"
+	}
+}
+
+// CaptureScreenshot takes a screenshot of the page with the given options
+func CaptureScreenshot(pageCtx context.Context, options ScreenshotOptions) ([]byte, error) {
+	var format page.CaptureScreenshotFormat
+	switch options.Format {
+	case "", "png":
+		format = page.CaptureScreenshotFormatPng
+	case "jpeg":
+		format = page.CaptureScreenshotFormatJpeg
+	case "webp":
+		format = page.CaptureScreenshotFormatWebp
+	default:
+		return nil, fmt.Errorf("unsupported screenshot format %s, use png, jpeg or webp", options.Format)
+	}
+	if options.Quality < 0 || options.Quality > 100 {
+		return nil, fmt.Errorf("screenshot quality must be between 0 and 100")
+	}
+
+	var buf []byte
+	err := chromedp.Run(pageCtx, chromedp.ActionFunc(func(ctx context.Context) error {
+		capture := page.CaptureScreenshot().WithFormat(format).WithFromSurface(true)
+		if format != page.CaptureScreenshotFormatPng && options.Quality > 0 {
+			capture = capture.WithQuality(int64(options.Quality))
+		}
+
+		if options.Selector != "" {
+			clip, err := elementClip(ctx, options.Selector)
+			if err != nil {
+				return err
+			}
+			capture = capture.WithClip(clip).WithCaptureBeyondViewport(true)
+		} else if options.FullPage {
+			capture = capture.WithCaptureBeyondViewport(true)
+		}
+
+		var err error
+		buf, err = capture.Do(ctx)
+		return err
+	}))
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// elementClip returns the area of the first element matching a selector, relative to its document
+func elementClip(ctx context.Context, selector string) (*page.Viewport, error) {
+	quotedSelector, _ := json.Marshal(selector)
+	script := fmt.Sprintf(`(() => {
+	const element = document.querySelector(%s);
+	if (!element) return null;
+	const rect = element.getBoundingClientRect();
+	const root = document.documentElement.getBoundingClientRect();
+	return {x: rect.left - root.left, y: rect.top - root.top, width: rect.width, height: rect.height};
+})()`, quotedSelector)
+
+	var clip *page.Viewport
+	if err := chromedp.Evaluate(script, &clip).Do(ctx); err != nil {
+		return nil, err
+	}
+	if clip == nil {
+		return nil, fmt.Errorf("selector %s did not match any element", selector)
+	}
+	if clip.Width == 0 || clip.Height == 0 {
+		return nil, fmt.Errorf("the element of selector %s is not visible", selector)
+	}
+
+	// Capture does not handle fractional dimensions
+	x, y := math.Round(clip.X), math.Round(clip.Y)
+	clip.Width, clip.Height = math.Round(clip.Width+clip.X-x), math.Round(clip.Height+clip.Y-y)
+	clip.X, clip.Y = x, y
+	clip.Scale = 1
+	return clip, nil
+}
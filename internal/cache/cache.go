@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/luispater/anyAIProxyAPI/internal/config"
+	"github.com/tidwall/gjson"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTTL     = time.Hour
+	defaultMaxSize = 64 * 1024 * 1024
+)
+
+// keyFields are the request fields that change the response, the others like "user" are left out of the key
+var keyFields = []string{
+	"model", "messages", "tools", "tool_choice", "parallel_tool_calls", "functions", "function_call",
+	"temperature", "top_p", "max_tokens", "max_completion_tokens", "stop", "seed", "n",
+	"presence_penalty", "frequency_penalty", "logit_bias", "logprobs", "top_logprobs",
+	"response_format", "reasoning_effort", "stream", "stream_options", "content_parts",
+}
+
+// Cache keeps the processor output of finished requests in memory, the least recently used entries
+// are evicted past the max size
+type Cache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	maxSize int
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type entry struct {
+	key       string
+	chunks    []string
+	size      int
+	expiresAt time.Time
+}
+
+// New creates the cache of the configuration. It returns nil when caching is disabled,
+// a nil cache never hits.
+func New(cacheConfig config.AppConfigCache) *Cache {
+	if !cacheConfig.Enabled {
+		return nil
+	}
+	c := &Cache{
+		ttl:     time.Duration(cacheConfig.TTLSeconds) * time.Second,
+		maxSize: cacheConfig.MaxSizeMB * 1024 * 1024,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+	if c.ttl <= 0 {
+		c.ttl = defaultTTL
+	}
+	if c.maxSize <= 0 {
+		c.maxSize = defaultMaxSize
+	}
+	return c
+}
+
+// Key returns the canonical hash of a chat completion request. Objects are hashed with sorted keys,
+// so the field order and the whitespace of the request do not matter.
+func Key(rawJson []byte) string {
+	canonical := make(map[string]any)
+	for _, field := range keyFields {
+		if value := gjson.GetBytes(rawJson, field); value.Exists() {
+			canonical[field] = value.Value()
+		}
+	}
+	data, _ := json.Marshal(canonical)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Get returns the chunks cached for a key
+func (c *Cache) Get(key string) ([]string, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := element.Value.(*entry)
+	if time.Now().After(e.expiresAt) {
+		c.remove(element)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return e.chunks, true
+}
+
+// Set caches the chunks of a key, an entry larger than the max size is not cached
+func (c *Cache) Set(key string, chunks []string) {
+	if c == nil {
+		return
+	}
+	size := 0
+	for _, chunk := range chunks {
+		size += len(chunk)
+	}
+	if size > c.maxSize {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	c.entries[key] = c.order.PushFront(&entry{
+		key:       key,
+		chunks:    chunks,
+		size:      size,
+		expiresAt: time.Now().Add(c.ttl),
+	})
+	c.size += size
+	for c.size > c.maxSize {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *Cache) remove(element *list.Element) {
+	e := element.Value.(*entry)
+	c.order.Remove(element)
+	delete(c.entries, e.key)
+	c.size -= e.size
+}
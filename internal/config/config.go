@@ -3,16 +3,100 @@ package config
 import (
 	"github.com/goccy/go-yaml"
 	"os"
+	"path/filepath"
 )
 
 // AppConfig holds the application configuration.
 type AppConfig struct {
-	Version  string              `yaml:"version"`
-	Debug    bool                `yaml:"debug"`
-	Browser  AppConfigBrowser    `yaml:"browser"`
-	Headless bool                `yaml:"headless"`
-	ApiPort  string              `yaml:"api-port"`
-	Instance []AppConfigInstance `yaml:"instance"`
+	Version     string              `yaml:"version"`
+	Debug       bool                `yaml:"debug"`
+	Browser     AppConfigBrowser    `yaml:"browser"`
+	Headless    bool                `yaml:"headless"`
+	ApiPort     string              `yaml:"api-port"`
+	ApiKeys     []AppConfigApiKey   `yaml:"api-keys,omitempty"`
+	ApiKeysFile string              `yaml:"api-keys-file,omitempty"`
+	AdminKey    string              `yaml:"admin-key,omitempty"`
+	RateLimit   AppConfigRateLimit  `yaml:"rate-limit,omitempty"`
+	BatchDir    string              `yaml:"batch-dir,omitempty"`
+	Screencast  AppConfigScreencast `yaml:"screencast,omitempty"`
+	Health      AppConfigHealth     `yaml:"health,omitempty"`
+	Audit       AppConfigAudit      `yaml:"audit,omitempty"`
+	Cache       AppConfigCache      `yaml:"cache,omitempty"`
+	Images      AppConfigImages     `yaml:"images,omitempty"`
+	Fetch       AppConfigFetch      `yaml:"fetch,omitempty"`
+	Instance    []AppConfigInstance `yaml:"instance"`
+}
+
+// AppConfigApiKey is an API key allowed to call the server.
+// Allow lists the instances ("chatgpt") or models ("chatgpt/gpt-4o") the key may use, all of them when empty.
+type AppConfigApiKey struct {
+	Key       string              `yaml:"key"`
+	Label     string              `yaml:"label,omitempty"`
+	Allow     []string            `yaml:"allow,omitempty"`
+	RateLimit *AppConfigRateLimit `yaml:"rate-limit,omitempty"`
+}
+
+// AppConfigRateLimit limits the requests per minute and the concurrent in-flight requests, zero means unlimited
+type AppConfigRateLimit struct {
+	RequestsPerMinute int `yaml:"requests-per-minute,omitempty"`
+	Concurrency       int `yaml:"concurrency,omitempty"`
+}
+
+// AppConfigApiKeys is the format of the api-keys-file
+type AppConfigApiKeys struct {
+	ApiKeys []AppConfigApiKey `yaml:"api-keys"`
+}
+
+// AppConfigScreencast is the JPEG quality and the frame rate limit of the live page screencast.
+type AppConfigScreencast struct {
+	Quality int `yaml:"quality,omitempty"`
+	MaxFPS  int `yaml:"max-fps,omitempty"`
+}
+
+// AppConfigHealth sets when an instance is reported not ready by /readyz.
+type AppConfigHealth struct {
+	FailureThreshold int `yaml:"failure-threshold,omitempty"`
+}
+
+// AppConfigAudit is the JSONL audit log of the tasks sent to the websites.
+// Redact patterns replace their matches in the captured texts, RedactFields are record paths replaced as a whole.
+type AppConfigAudit struct {
+	Enabled       bool                   `yaml:"enabled"`
+	File          string                 `yaml:"file,omitempty"`
+	MetadataOnly  bool                   `yaml:"metadata-only,omitempty"`
+	MaxSizeMB     int                    `yaml:"max-size-mb,omitempty"`
+	RotateHours   int                    `yaml:"rotate-hours,omitempty"`
+	RetentionDays int                    `yaml:"retention-days,omitempty"`
+	MaxBackups    int                    `yaml:"max-backups,omitempty"`
+	Redact        []AppConfigAuditRedact `yaml:"redact,omitempty"`
+	RedactFields  []string               `yaml:"redact-fields,omitempty"`
+}
+
+type AppConfigAuditRedact struct {
+	Pattern     string `yaml:"pattern"`
+	Replacement string `yaml:"replacement,omitempty"`
+}
+
+// AppConfigCache is the in-memory cache of the responses to identical requests.
+type AppConfigCache struct {
+	Enabled    bool `yaml:"enabled"`
+	TTLSeconds int  `yaml:"ttl-seconds,omitempty"`
+	MaxSizeMB  int  `yaml:"max-size-mb,omitempty"`
+}
+
+// AppConfigImages is the local store of the generated images answered as URLs, BaseURL is the address
+// of the proxy seen by the clients, the request host when empty.
+type AppConfigImages struct {
+	Dir        string `yaml:"dir,omitempty"`
+	TTLSeconds int    `yaml:"ttl-seconds,omitempty"`
+	BaseURL    string `yaml:"base-url,omitempty"`
+}
+
+// AppConfigFetch limits the downloads of the remote images and files of the requests, Cache keeps them by URL.
+type AppConfigFetch struct {
+	TimeoutSeconds int            `yaml:"timeout-seconds,omitempty"`
+	MaxSizeMB      int            `yaml:"max-size-mb,omitempty"`
+	Cache          AppConfigCache `yaml:"cache,omitempty"`
 }
 
 type AppConfigBrowser struct {
@@ -21,19 +105,39 @@ type AppConfigBrowser struct {
 	UserDataDir             string   `yaml:"user-data-dir,omitempty"`
 }
 type AppConfigRunner struct {
-	Init            string `yaml:"init"`
-	ChatCompletions string `yaml:"chat_completions"`
-	ContextCanceled string `yaml:"context_canceled"`
+	Init              string `yaml:"init"`
+	ChatCompletions   string `yaml:"chat_completions"`
+	ContextCanceled   string `yaml:"context_canceled"`
+	OpenConversation  string `yaml:"open_conversation,omitempty"`
+	ImagesGenerations string `yaml:"images_generations,omitempty"`
 }
 type AppConfigInstance struct {
-	Name      string                `yaml:"name"`
-	Adapter   string                `yaml:"adapter"`
-	ProxyURL  string                `yaml:"proxy-url"`
-	URL       string                `yaml:"url"`
-	SniffURL  []string              `yaml:"sniff-url"`
-	UserAgent string                `yaml:"user-agent,omitempty"`
-	Auth      AppConfigInstanceAuth `yaml:"auth"`
-	Runner    AppConfigRunner       `yaml:"runner"`
+	Name             string                `yaml:"name"`
+	Adapter          string                `yaml:"adapter"`
+	ProxyURL         string                `yaml:"proxy-url"`
+	URL              string                `yaml:"url"`
+	SniffURL         []string              `yaml:"sniff-url"`
+	UserAgent        string                `yaml:"user-agent,omitempty"`
+	Auth             AppConfigInstanceAuth `yaml:"auth"`
+	Runner           AppConfigRunner       `yaml:"runner"`
+	Models           []string              `yaml:"models,omitempty"`
+	ToolEmulation    bool                  `yaml:"tool-emulation,omitempty"`
+	RateLimit        AppConfigRateLimit    `yaml:"rate-limit,omitempty"`
+	BatchConcurrency int                   `yaml:"batch-concurrency,omitempty"`
+	Attachments      AppConfigAttachments  `yaml:"attachments,omitempty"`
+}
+
+// AppConfigAttachments limits the files, images and audio a request sends to an instance. Types are MIME types,
+// with wildcards like image/*, all the uploadable types are accepted when empty.
+type AppConfigAttachments struct {
+	MaxFileSizeMB int      `yaml:"max-file-size-mb,omitempty"`
+	MaxFiles      int      `yaml:"max-files,omitempty"`
+	Types         []string `yaml:"types,omitempty"`
+}
+
+// AppConfigModels is the model catalog file (runner/<instance>/models.yaml) of an instance.
+type AppConfigModels struct {
+	Models []string `yaml:"models"`
 }
 
 type AppConfigInstanceAuth struct {
@@ -54,5 +158,56 @@ func LoadConfig() (*AppConfig, error) {
 		return nil, err
 	}
 
+	if config.ApiKeysFile != "" {
+		apiKeys, errLoadApiKeys := LoadApiKeys(config.ApiKeysFile)
+		if errLoadApiKeys != nil {
+			return nil, errLoadApiKeys
+		}
+		config.ApiKeys = append(config.ApiKeys, apiKeys...)
+	}
+
+	for i := 0; i < len(config.Instance); i++ {
+		if len(config.Instance[i].Models) == 0 {
+			config.Instance[i].Models, err = LoadInstanceModels(config.Instance[i].Name)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	return &config, nil
 }
+
+// LoadInstanceModels loads the model catalog from the instance's runner directory.
+// A missing models.yaml is not an error, the instance simply has no declared models.
+func LoadInstanceModels(instanceName string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join("runner", instanceName, "models.yaml"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	var models AppConfigModels
+	err = yaml.Unmarshal(data, &models)
+	if err != nil {
+		return nil, err
+	}
+	return models.Models, nil
+}
+
+// LoadApiKeys loads the API keys from a keys file
+func LoadApiKeys(path string) ([]AppConfigApiKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var apiKeys AppConfigApiKeys
+	err = yaml.Unmarshal(data, &apiKeys)
+	if err != nil {
+		return nil, err
+	}
+	return apiKeys.ApiKeys, nil
+}
@@ -0,0 +1,125 @@
+package conversation
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Conversation is a web chat holding a known message history
+type Conversation struct {
+	URL       string
+	ID        string
+	UpdatedAt time.Time
+}
+
+// Store maps message histories to the web conversations holding them.
+// Keys are hashes of the instance name and the messages of the history.
+type Store struct {
+	mu    sync.Mutex
+	items map[string]*Conversation
+	ttl   time.Duration
+}
+
+// NewStore creates a conversation store, conversations not continued within ttl are forgotten
+func NewStore(ttl time.Duration) *Store {
+	return &Store{
+		items: make(map[string]*Conversation),
+		ttl:   ttl,
+	}
+}
+
+// Continue returns the conversation whose history the request extends by a single user turn.
+// The conversation is taken out of the store: once continued, the web chat no longer holds that history,
+// so a request branching from the same history starts a new chat.
+func (s *Store) Continue(instanceName, requestJson string) (*Conversation, bool) {
+	messages := gjson.Get(requestJson, "messages").Array()
+	if len(messages) < 2 || messages[len(messages)-1].Get("role").String() != "user" {
+		return nil, false
+	}
+
+	key := HistoryKey(instanceName, messages[:len(messages)-1])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conversation, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	delete(s.items, key)
+	if time.Since(conversation.UpdatedAt) > s.ttl {
+		return nil, false
+	}
+	return conversation, true
+}
+
+// Save remembers the conversation holding the request messages followed by the assistant reply
+func (s *Store) Save(instanceName, requestJson, replyJson string, conversation *Conversation) {
+	messages := gjson.Get(requestJson, "messages").Array()
+	messages = append(messages, gjson.Parse(replyJson))
+	key := HistoryKey(instanceName, messages)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, item := range s.items {
+		if time.Since(item.UpdatedAt) > s.ttl {
+			delete(s.items, k)
+		}
+	}
+	conversation.UpdatedAt = time.Now()
+	s.items[key] = conversation
+}
+
+// HistoryKey hashes the instance name and the messages of a history. Only what the website has seen is hashed,
+// so tool call ids and formatting differences of the clients do not break the continuation.
+func HistoryKey(instanceName string, messages []gjson.Result) string {
+	hash := sha256.New()
+	hash.Write([]byte(instanceName))
+	for _, msg := range messages {
+		hash.Write([]byte{0})
+		hash.Write([]byte(canonicalMessage(msg)))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func canonicalMessage(msg gjson.Result) string {
+	var builder strings.Builder
+	builder.WriteString(msg.Get("role").String())
+	builder.WriteString("\n")
+
+	contentResult := msg.Get("content")
+	if contentResult.Type == gjson.String {
+		builder.WriteString(strings.TrimSpace(contentResult.String()))
+	} else if contentResult.IsArray() {
+		for _, part := range contentResult.Array() {
+			switch part.Get("type").String() {
+			case "text":
+				builder.WriteString(strings.TrimSpace(part.Get("text").String()))
+			case "image_url":
+				builder.WriteString("\n" + part.Get("image_url.url").String())
+			}
+		}
+	}
+
+	for _, toolCall := range msg.Get("tool_calls").Array() {
+		builder.WriteString("\n" + toolCall.Get("function.name").String())
+		builder.WriteString("\n" + gjson.Get(toolCall.Get("function.arguments").String(), "@ugly").Raw)
+	}
+	return builder.String()
+}
+
+// LastTurn returns the request with only its last message, the new turn typed into a continued conversation
+func LastTurn(requestJson string) string {
+	messages := gjson.Get(requestJson, "messages").Array()
+	if len(messages) == 0 {
+		return requestJson
+	}
+	requestJson, _ = sjson.SetRaw(requestJson, "messages", "["+messages[len(messages)-1].Raw+"]")
+	return requestJson
+}
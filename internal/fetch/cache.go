@@ -0,0 +1,99 @@
+package fetch
+
+import (
+	"container/list"
+	"github.com/luispater/anyAIProxyAPI/internal/config"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCacheTTL     = 10 * time.Minute
+	defaultCacheMaxSize = 64 * 1024 * 1024
+)
+
+// fileCache keeps the downloaded files by URL in memory, the least recently used are evicted past the max size
+type fileCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	maxSize int
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type cachedFile struct {
+	url       string
+	data      []byte
+	mimeType  string
+	expiresAt time.Time
+}
+
+// newFileCache creates the URL cache of the configuration, it returns nil when it is disabled,
+// a nil cache never hits
+func newFileCache(cacheConfig config.AppConfigCache) *fileCache {
+	if !cacheConfig.Enabled {
+		return nil
+	}
+	c := &fileCache{
+		ttl:     time.Duration(cacheConfig.TTLSeconds) * time.Second,
+		maxSize: cacheConfig.MaxSizeMB * 1024 * 1024,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+	if c.ttl <= 0 {
+		c.ttl = defaultCacheTTL
+	}
+	if c.maxSize <= 0 {
+		c.maxSize = defaultCacheMaxSize
+	}
+	return c
+}
+
+// get returns the file cached for a URL
+func (c *fileCache) get(url string) (*cachedFile, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[url]
+	if !ok {
+		return nil, false
+	}
+	file := element.Value.(*cachedFile)
+	if time.Now().After(file.expiresAt) {
+		c.remove(element)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return file, true
+}
+
+// set caches the file of a URL, a file larger than the max size is not cached
+func (c *fileCache) set(url string, data []byte, mimeType string) {
+	if c == nil || len(data) > c.maxSize {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[url]; ok {
+		c.remove(element)
+	}
+	c.entries[url] = c.order.PushFront(&cachedFile{
+		url:       url,
+		data:      data,
+		mimeType:  mimeType,
+		expiresAt: time.Now().Add(c.ttl),
+	})
+	c.size += len(data)
+	for c.size > c.maxSize {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *fileCache) remove(element *list.Element) {
+	file := c.order.Remove(element).(*cachedFile)
+	delete(c.entries, file.url)
+	c.size -= len(file.data)
+}
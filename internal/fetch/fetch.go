@@ -0,0 +1,102 @@
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"github.com/luispater/anyAIProxyAPI/internal/config"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTimeout = 30 * time.Second
+	defaultMaxSize = 20 * 1024 * 1024
+)
+
+// Fetcher downloads the remote images and files of the requests, so they can be uploaded to the websites
+type Fetcher struct {
+	client  *http.Client
+	maxSize int64
+	cache   *fileCache
+}
+
+// New creates the fetcher of the configuration, its URL cache is disabled unless configured
+func New(fetchConfig config.AppConfigFetch) *Fetcher {
+	timeout := time.Duration(fetchConfig.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	maxSize := int64(fetchConfig.MaxSizeMB) * 1024 * 1024
+	if maxSize <= 0 {
+		maxSize = defaultMaxSize
+	}
+	return &Fetcher{
+		client:  NewPublicClient(timeout, true),
+		maxSize: maxSize,
+		cache:   newFileCache(fetchConfig.Cache),
+	}
+}
+
+// IsRemote reports whether a URL is an http(s) URL the fetcher downloads
+func IsRemote(rawURL string) bool {
+	return strings.HasPrefix(rawURL, "http://") || strings.HasPrefix(rawURL, "https://")
+}
+
+// Fetch downloads a URL and returns its data and MIME type. Only public addresses are fetched, and the download
+// stops with the context. The type is sniffed from the data, the Content-Type header only names the types
+// the sniffing cannot tell apart from text or binary data.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) ([]byte, string, error) {
+	if file, hit := f.cache.get(rawURL); hit {
+		log.Debugf("Fetch cache hit for %s", rawURL)
+		return file.data, file.mimeType, nil
+	}
+
+	if err := CheckURL(ctx, rawURL); err != nil {
+		return nil, "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid url %s", rawURL)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not download %s: %v", rawURL, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, "", fmt.Errorf("could not download %s: status %d", rawURL, resp.StatusCode)
+	}
+	if resp.ContentLength > f.maxSize {
+		return nil, "", fmt.Errorf("%s is larger than %d MB", rawURL, f.maxSize/1024/1024)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, f.maxSize+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("could not download %s: %v", rawURL, err)
+	}
+	if int64(len(data)) > f.maxSize {
+		return nil, "", fmt.Errorf("%s is larger than %d MB", rawURL, f.maxSize/1024/1024)
+	}
+
+	mimeType := sniff(data, resp.Header.Get("Content-Type"))
+	log.Debugf("Fetched %s, %d bytes of %s", rawURL, len(data), mimeType)
+	f.cache.set(rawURL, data, mimeType)
+	return data, mimeType, nil
+}
+
+// sniff returns the MIME type of data, or of its Content-Type header when the data only sniffs as text or binary
+func sniff(data []byte, contentType string) string {
+	mimeType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	if mimeType != "application/octet-stream" && mimeType != "text/plain" {
+		return mimeType
+	}
+	if headerType, _, err := mime.ParseMediaType(contentType); err == nil && headerType != "" && headerType != "application/octet-stream" {
+		return strings.ToLower(headerType)
+	}
+	return mimeType
+}
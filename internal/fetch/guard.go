@@ -0,0 +1,84 @@
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+const maxRedirects = 5
+
+// sharedAddressSpace is the carrier-grade NAT range of RFC 6598, private in practice
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// PublicIP reports whether an IP is a public unicast address, not a loopback, private, link-local
+// (like the 169.254.169.254 metadata address), multicast or unspecified one
+func PublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified() && !sharedAddressSpace.Contains(ip)
+}
+
+// NewPublicClient creates an HTTP client that only connects to public addresses. The address is checked
+// when the connection is dialed, after the DNS resolution, so a host resolving to a private address on
+// a second lookup is rejected too. Redirects are followed when followRedirects is set, each hop checked.
+func NewPublicClient(timeout time.Duration, followRedirects bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !PublicIP(ip) {
+				return fmt.Errorf("address %s is not public", host)
+			}
+			return nil
+		},
+	}
+	transport := &http.Transport{
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if !followRedirects {
+				return http.ErrUseLastResponse
+			}
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			return CheckURL(req.Context(), req.URL.String())
+		},
+	}
+}
+
+// CheckURL checks that a URL is an http(s) URL whose host resolves to public addresses only
+func CheckURL(ctx context.Context, rawURL string) error {
+	if !IsRemote(rawURL) {
+		return fmt.Errorf("invalid url %s", rawURL)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil || req.URL.Hostname() == "" {
+		return fmt.Errorf("invalid url %s", rawURL)
+	}
+	addresses, err := net.DefaultResolver.LookupIPAddr(ctx, req.URL.Hostname())
+	if err != nil {
+		return fmt.Errorf("could not resolve %s", req.URL.Hostname())
+	}
+	for _, address := range addresses {
+		if !PublicIP(address.IP) {
+			return fmt.Errorf("host %s is not a public address", req.URL.Hostname())
+		}
+	}
+	return nil
+}
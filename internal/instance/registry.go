@@ -0,0 +1,117 @@
+package instance
+
+import (
+	"sync"
+	"time"
+)
+
+// Status is the runtime state of an instance, shared by the browser loop and the API
+type Status struct {
+	Paused        bool
+	Attached      bool
+	AttachedAt    time.Time
+	AuthChecked   bool
+	LoggedIn      bool
+	AuthCheckedAt time.Time
+	AuthSavedAt   time.Time
+	// ConsecutiveFailures counts the requests failed since the last successful one
+	ConsecutiveFailures int
+	LastError           string
+	LastSuccessAt       time.Time
+	LastFailureAt       time.Time
+}
+
+// Registry keeps the runtime state of the instances
+type Registry struct {
+	mu       sync.Mutex
+	statuses map[string]*Status
+}
+
+// NewRegistry creates an empty registry, every instance starts running and with an unknown auth state
+func NewRegistry() *Registry {
+	return &Registry{
+		statuses: make(map[string]*Status),
+	}
+}
+
+// Status returns the state of an instance
+func (r *Registry) Status(name string) Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return *r.status(name)
+}
+
+// Paused reports whether an instance stopped accepting work
+func (r *Registry) Paused(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status(name).Paused
+}
+
+// SetPaused pauses or resumes an instance
+func (r *Registry) SetPaused(name string, paused bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status(name).Paused = paused
+}
+
+// Attached reports whether an operator controls the page of an instance through the console
+func (r *Registry) Attached(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status(name).Attached
+}
+
+// SetAttached records an operator attaching to or detaching from an instance
+func (r *Registry) SetAttached(name string, attached bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status := r.status(name)
+	status.Attached = attached
+	if attached {
+		status.AttachedAt = time.Now()
+	} else {
+		status.AttachedAt = time.Time{}
+	}
+}
+
+// SetAuthChecked records the result of the auth check selector of an instance
+func (r *Registry) SetAuthChecked(name string, loggedIn bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status := r.status(name)
+	status.AuthChecked = true
+	status.LoggedIn = loggedIn
+	status.AuthCheckedAt = time.Now()
+}
+
+// SetAuthSaved records that the auth file of an instance was written
+func (r *Registry) SetAuthSaved(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status(name).AuthSavedAt = time.Now()
+}
+
+// RecordResult records the outcome of a request processed by an instance, err is nil on success
+func (r *Registry) RecordResult(name string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status := r.status(name)
+	if err == nil {
+		status.ConsecutiveFailures = 0
+		status.LastSuccessAt = time.Now()
+		return
+	}
+	status.ConsecutiveFailures++
+	status.LastError = err.Error()
+	status.LastFailureAt = time.Now()
+}
+
+func (r *Registry) status(name string) *Status {
+	status, ok := r.statuses[name]
+	if !ok {
+		status = &Status{}
+		r.statuses[name] = status
+	}
+	return status
+}
@@ -84,13 +84,13 @@ func (m *Method) AIStudioTokens(systemTokens, inputTokens, endTokens string) (ui
 
 	totalTokens, err := strconv.ParseUint(endTokens, 10, 64)
 	if err != nil {
-		log.Error("convert total tokens failed: %v", err)
+		log.Errorf("convert total tokens failed: %v", err)
 		return 0, 0, 0, err
 	}
 
 	promptTokens, err := strconv.ParseUint(inputTokens, 10, 64)
 	if err != nil {
-		log.Error("convert prompt tokens failed: %v", err)
+		log.Errorf("convert prompt tokens failed: %v", err)
 		return 0, 0, 0, err
 	}
 
@@ -0,0 +1,165 @@
+package method
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/tidwall/gjson"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnsupportedType is wrapped by the errors of the attachments that cannot be uploaded
+var ErrUnsupportedType = errors.New("unsupported file type")
+
+// audioFormats are the MIME types of the input_audio formats
+var audioFormats = map[string]string{
+	"wav":  "audio/x-wav",
+	"mp3":  "audio/mpeg",
+	"flac": "audio/x-flac",
+	"ogg":  "audio/ogg",
+	"m4a":  "audio/mp4",
+	"aac":  "audio/x-aac",
+	"webm": "audio/webm",
+}
+
+// Attachment is a file sent in an image_url, file or input_audio content part
+type Attachment struct {
+	Filename string
+	MimeType string
+	Data     string
+}
+
+// Size returns the decoded size of the attachment
+func (a Attachment) Size() int {
+	return base64.StdEncoding.DecodedLen(len(a.Data)) - strings.Count(a.Data[max(0, len(a.Data)-2):], "=")
+}
+
+// DataURL renders the attachment as a data URL, the filename is kept in its name parameter for UploadFiles
+func (a Attachment) DataURL() string {
+	if a.Filename == "" {
+		return fmt.Sprintf("data:%s;base64,%s", a.MimeType, a.Data)
+	}
+	return fmt.Sprintf("data:%s;name=%s;base64,%s", a.MimeType, url.PathEscape(a.Filename), a.Data)
+}
+
+// RequestAttachments returns the attachments of all the messages of a request. The remote image URLs
+// are skipped, the API downloads them into data URLs first.
+func RequestAttachments(requestJson string) ([]Attachment, error) {
+	attachments := make([]Attachment, 0)
+	for _, msg := range gjson.Get(requestJson, "messages").Array() {
+		for _, part := range msg.Get("content").Array() {
+			attachment, err := PartAttachment(part, len(attachments))
+			if err != nil {
+				return nil, err
+			}
+			if attachment != nil {
+				attachments = append(attachments, *attachment)
+			}
+		}
+	}
+	return attachments, nil
+}
+
+// PartAttachment returns the attachment of a content part, nil for a text part or a remote image or file URL.
+// Files without a known MIME type are sent as text/plain when they hold text, as source files do,
+// and rejected otherwise. Index names the files without a filename, like UploadFiles does.
+func PartAttachment(part gjson.Result, index int) (*Attachment, error) {
+	attachment := &Attachment{}
+	switch part.Get("type").String() {
+	case "image_url":
+		imageURL := part.Get("image_url.url").String()
+		if !strings.HasPrefix(imageURL, "data:") {
+			return nil, nil
+		}
+		mimeType, params, data, err := ParseDataURL(imageURL)
+		if err != nil {
+			return nil, fmt.Errorf("image_url: %v", err)
+		}
+		attachment.MimeType, attachment.Filename, attachment.Data = mimeType, params["name"], data
+	case "file":
+		if part.Get("file.file_id").Exists() {
+			return nil, fmt.Errorf("file: file_id is not supported, send the file content in file_data")
+		}
+		fileData := part.Get("file.file_data").String()
+		if fileData == "" {
+			return nil, fmt.Errorf("file: file_data is required")
+		}
+		if strings.HasPrefix(fileData, "http://") || strings.HasPrefix(fileData, "https://") {
+			return nil, nil
+		}
+		attachment.Filename = part.Get("file.filename").String()
+		if strings.HasPrefix(fileData, "data:") {
+			mimeType, _, data, err := ParseDataURL(fileData)
+			if err != nil {
+				return nil, fmt.Errorf("file %s: %v", attachment.Filename, err)
+			}
+			attachment.MimeType, attachment.Data = mimeType, data
+		} else {
+			attachment.Data = fileData
+		}
+	case "input_audio":
+		format := part.Get("input_audio.format").String()
+		mimeType, ok := audioFormats[format]
+		if !ok {
+			return nil, fmt.Errorf("input_audio: %w %q", ErrUnsupportedType, format)
+		}
+		attachment.MimeType, attachment.Data = mimeType, part.Get("input_audio.data").String()
+		attachment.Filename = fmt.Sprintf("audio_%d.%s", index, format)
+	default:
+		return nil, nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(attachment.Data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid base64 data: %v", part.Get("type").String(), err)
+	}
+	if _, ok := MimeTypes[attachment.MimeType]; !ok {
+		attachment.MimeType = fileMimeType(attachment.Filename, data)
+	}
+	if attachment.MimeType == "" {
+		return nil, fmt.Errorf("%s: %w of %q", part.Get("type").String(), ErrUnsupportedType, attachment.Filename)
+	}
+	if attachment.Filename == "" {
+		attachment.Filename = fmt.Sprintf("file_%d.%s", index, MimeTypes[attachment.MimeType])
+	}
+	attachment.Filename = filepath.Base(attachment.Filename)
+	return attachment, nil
+}
+
+// ParseDataURL splits a base64 data URL into its MIME type, its parameters and its base64 data
+func ParseDataURL(dataURL string) (string, map[string]string, string, error) {
+	header, data, found := strings.Cut(strings.TrimPrefix(dataURL, "data:"), ",")
+	if !found || !strings.HasSuffix(header, ";base64") {
+		return "", nil, "", fmt.Errorf("not a base64 data url")
+	}
+	fields := strings.Split(strings.TrimSuffix(header, ";base64"), ";")
+	params := make(map[string]string)
+	for _, field := range fields[1:] {
+		if key, value, ok := strings.Cut(field, "="); ok {
+			if unescaped, err := url.PathUnescape(value); err == nil {
+				value = unescaped
+			}
+			params[strings.ToLower(key)] = value
+		}
+	}
+	return strings.ToLower(fields[0]), params, data, nil
+}
+
+// fileMimeType finds the MIME type of a file from its extension, or text/plain for a text file,
+// it returns "" when the file cannot be uploaded
+func fileMimeType(filename string, data []byte) string {
+	if extension := strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), "."); extension != "" {
+		for mimeType, mimeExtension := range MimeTypes {
+			if mimeExtension == extension {
+				return mimeType
+			}
+		}
+	}
+	if strings.HasPrefix(http.DetectContentType(data), "text/plain") {
+		return "text/plain"
+	}
+	return ""
+}
@@ -12,3 +12,8 @@ func (m *Method) GetURL() (string, error) {
 	}
 	return currentURL, nil
 }
+
+// Navigate opens the url in the page, e.g. the web conversation continued by a request
+func (m *Method) Navigate(url string) error {
+	return chromedp.Run(m.page.GetContext(), chromedp.Navigate(url))
+}
@@ -0,0 +1,30 @@
+package method
+
+import (
+	"github.com/chromedp/chromedp"
+	"github.com/luispater/anyAIProxyAPI/internal/adapter"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+const defaultDownloadTimeout = 60 * time.Second
+
+// DownloadImage clicks the download button of a generated image and sends the downloaded file to the task,
+// for the images the sniffed traffic does not carry
+func (m *Method) DownloadImage(elementSelector string, timeout float64, channel chan *adapter.AdapterResponse) error {
+	downloadTimeout := time.Duration(timeout * float64(time.Millisecond))
+	if downloadTimeout <= 0 {
+		downloadTimeout = defaultDownloadTimeout
+	}
+
+	data, err := m.page.CaptureDownload(chromedp.Click(elementSelector, chromedp.ByQuery), downloadTimeout)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Downloaded an image of %d bytes with %s", len(data), elementSelector)
+	channel <- &adapter.AdapterResponse{
+		Parts: []adapter.Part{{Type: adapter.PartImage, Data: data, MimeType: http.DetectContentType(data)}},
+	}
+	return nil
+}
@@ -762,6 +762,7 @@ func (m *Method) UploadFiles(base64edImages []string) error {
 	}
 
 	var filePaths []string
+	usedNames := make(map[string]bool)
 
 	// 2. Decode base64 images and save them as temporary files
 	for i, data := range base64edImages {
@@ -795,8 +796,14 @@ func (m *Method) UploadFiles(base64edImages []string) error {
 			continue
 		}
 
-		// Create a temporary file
+		// Create a temporary file, named after the name parameter of the data URL when it has one
 		fileName := fmt.Sprintf("file_%d.%s", i, ext)
+		if _, params, _, errParse := ParseDataURL(data); errParse == nil && params["name"] != "" {
+			if name := filepath.Base(params["name"]); name != "." && name != ".." && name != string(filepath.Separator) && !usedNames[name] {
+				fileName = name
+			}
+		}
+		usedNames[fileName] = true
 		filePath := filepath.Join(tempDir, fileName)
 
 		if err = os.WriteFile(filePath, rawData, 0644); err != nil {
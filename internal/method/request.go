@@ -2,6 +2,7 @@ package method
 
 import (
 	"fmt"
+	"github.com/luispater/anyAIProxyAPI/internal/toolcall"
 	log "github.com/sirupsen/logrus"
 	"github.com/tidwall/gjson"
 	"strings"
@@ -50,7 +51,7 @@ func (m *Method) PromptCount(requestJson string) (systemPromptCount int, assista
 			return
 		}
 		role := roleResult.String()
-		if role == "system" {
+		if role == "system" || role == "developer" {
 			systemPromptCount++
 		} else if role == "user" {
 			userPromptCount++
@@ -76,7 +77,7 @@ func (m *Method) SystemPrompt(requestJson string) (bool, string, error) {
 			return false, "", fmt.Errorf("role is not a string")
 		}
 		role := roleResult.String()
-		if role == "system" {
+		if role == "system" || role == "developer" {
 			contentResult := gjson.Get(msg.Raw, "content")
 			if contentResult.Type == gjson.String {
 				return true, contentResult.String(), nil
@@ -138,157 +139,52 @@ func (m *Method) UserPrompt(requestJson string) (bool, string, error) {
 	return false, "", fmt.Errorf("messages is emtpy")
 }
 
+// BuildPrompt renders the messages into a single prompt. Once the request has a history, every turn is
+// labelled with its speaker, including the tool calls of the assistant and the tool results. The images and
+// files are referenced by filename where they appeared, ImagePrompt returns them to upload. When function
+// calling is emulated, the tools instructions are added.
 func (m *Method) BuildPrompt(requestJson string, includeSystem bool) (string, error) {
-	userPromptCount := 0
-	assistantPromptCount := 0
-	systemPromptCount := 0
-
-	prompts := make([]string, 0)
-
-	messagesResult := gjson.Get(requestJson, "messages")
-	if !messagesResult.IsArray() {
-		return "", fmt.Errorf("messages not define")
+	messages, err := flattenMessages(requestJson)
+	if err != nil {
+		log.Error(err)
+		return "", err
 	}
-	messages := messagesResult.Array()
+
+	history := false
 	for _, msg := range messages {
-		roleResult := gjson.Get(msg.Raw, "role")
-		if roleResult.Type != gjson.String {
-			return "", fmt.Errorf("role is not a string")
-		}
-		role := roleResult.String()
-		if role == "user" {
-			userPromptCount++
-		} else if role == "assistant" {
-			assistantPromptCount++
-		} else if role == "system" && includeSystem {
-			systemPromptCount++
+		if msg.Role == "assistant" || msg.Role == "tool" || (msg.Role == "system" && includeSystem) {
+			history = true
 		}
 	}
 
-	if assistantPromptCount > 0 || systemPromptCount > 0 {
-		for _, msg := range messages {
-			msgText := ""
-
-			roleResult := gjson.Get(msg.Raw, "role")
-			if roleResult.Type != gjson.String {
-				log.Error("role is not a string")
-				continue
-			}
-
-			role := roleResult.String()
-			if role == "user" {
-				msgText = "user:\n"
-			} else if role == "assistant" {
-				msgText = "model:\n"
-			} else {
-				continue
-			}
-
-			contentResult := gjson.Get(msg.Raw, "content")
-
-			if contentResult.Type == gjson.String {
-				msgText = msgText + contentResult.String()
-			} else if contentResult.IsArray() {
-				contents := contentResult.Array()
-				for i := 0; i < len(contents); i++ {
-					contentType := contents[i].Get("type").String()
-					if contentType == "text" {
-						contentTextResult := contents[i].Get("text")
-						if contentTextResult.Type != gjson.String {
-							log.Error("text is not a string")
-							return "", fmt.Errorf("text is not a string")
-						}
-						msgText = msgText + contentTextResult.String()
-					}
-				}
-			} else {
-				log.Error("context is not a string or array")
-				return "", fmt.Errorf("context is not a string or array")
-			}
-
-			prompts = append(prompts, strings.TrimSpace(msgText))
+	prompts := make([]string, 0)
+	systemPrompt := make([]string, 0)
+	for _, msg := range messages {
+		if msg.Role == "system" {
+			systemPrompt = append(systemPrompt, msg.Text)
+			continue
 		}
-	} else {
-		for _, msg := range messages {
-			msgText := ""
-
-			roleResult := gjson.Get(msg.Raw, "role")
-			if roleResult.Type != gjson.String {
-				log.Error("role is not a string")
-				continue
-			}
-
-			role := roleResult.String()
-			if role != "user" {
-				continue
-			}
-
-			contentResult := gjson.Get(msg.Raw, "content")
-
-			if contentResult.Type == gjson.String {
-				msgText = contentResult.String()
-			} else if contentResult.IsArray() {
-				contents := contentResult.Array()
-				for i := 0; i < len(contents); i++ {
-					contentType := contents[i].Get("type").String()
-					if contentType == "text" {
-						contentTextResult := contents[i].Get("text")
-						if contentTextResult.Type != gjson.String {
-							log.Error("text is not a string")
-							return "", fmt.Errorf("text is not a string")
-						}
-						msgText = contentTextResult.String()
-					}
-				}
-			} else {
-				log.Error("context is not a string or array")
-				return "", fmt.Errorf("context is not a string or array")
-			}
-
-			prompts = append(prompts, strings.TrimSpace(msgText))
+		label, ok := roleLabels[msg.Role]
+		if !ok {
+			continue
+		}
+		if history {
+			prompts = append(prompts, strings.TrimSpace(label+":\n"+msg.Text))
+		} else {
+			prompts = append(prompts, strings.TrimSpace(msg.Text))
 		}
 	}
 
 	if includeSystem {
-		systemPrompt := make([]string, 0)
-		for _, msg := range messages {
-			roleResult := gjson.Get(msg.Raw, "role")
-			if roleResult.Type != gjson.String {
-				log.Error("role is not a string")
-				continue
-			}
-
-			role := roleResult.String()
-			if role != "system" {
-				continue
-			}
-
-			contentResult := gjson.Get(msg.Raw, "content")
-
-			if contentResult.Type == gjson.String {
-				systemPrompt = append(systemPrompt, contentResult.String())
-			} else if contentResult.IsArray() {
-				contents := contentResult.Array()
-				for i := 0; i < len(contents); i++ {
-					contentType := contents[i].Get("type").String()
-					if contentType == "text" {
-						contentTextResult := contents[i].Get("text")
-						if contentTextResult.Type != gjson.String {
-							log.Error("text is not a string")
-							return "", fmt.Errorf("text is not a string")
-						}
-						systemPrompt = append(systemPrompt, contentTextResult.String())
-					}
-				}
-			} else {
-				log.Error("context is not a string or array")
-				return "", fmt.Errorf("context is not a string or array")
-			}
+		if toolsPrompt := toolcall.Prompt(requestJson); toolsPrompt != "" {
+			systemPrompt = append(systemPrompt, toolsPrompt)
 		}
 		if len(systemPrompt) > 0 {
 			systemPromptText := "system:\n" + strings.Join(systemPrompt, "\n")
 			prompts = append([]string{systemPromptText}, prompts...)
 		}
+	} else if toolsPrompt := toolcall.Prompt(requestJson); toolsPrompt != "" {
+		prompts = append([]string{toolsPrompt}, prompts...)
 	}
 
 	if len(prompts) > 0 {
@@ -297,83 +193,43 @@ func (m *Method) BuildPrompt(requestJson string, includeSystem bool) (string, er
 	return "", fmt.Errorf("message is empty")
 }
 
+// ImagePrompt returns the images, files and audio of all the messages as data URLs, in the order BuildPrompt
+// references them. Each data URL names its file in a name parameter, the name used by UploadFiles.
+// Remote URLs are not returned, the API downloads them into data URLs first.
 func (m *Method) ImagePrompt(requestJson string) (bool, []string, error) {
-	messagesResult := gjson.Get(requestJson, "messages")
-	if !messagesResult.IsArray() {
-		log.Error("messages not define")
-		return false, nil, fmt.Errorf("messages not define")
+	messages, err := flattenMessages(requestJson)
+	if err != nil {
+		log.Error(err)
+		return false, nil, err
 	}
-	messages := messagesResult.Array()
-	arrayImageURL := make([]string, 0)
-	if len(messages) > 0 {
-		msg := messages[len(messages)-1]
-		roleResult := gjson.Get(msg.Raw, "role")
-		if roleResult.Type != gjson.String {
-			log.Error("role is not a string")
-			return false, nil, fmt.Errorf("role is not a string")
-		}
-		role := roleResult.String()
-		if role == "user" {
-			contentResult := gjson.Get(msg.Raw, "content")
-			if contentResult.Type == gjson.String {
-				return false, nil, fmt.Errorf("content has not any image_url")
-			} else if contentResult.IsArray() {
-				contents := contentResult.Array()
-				for i := 0; i < len(contents); i++ {
-					contentType := contents[i].Get("type").String()
-					if contentType == "image_url" {
-						contentImageURLResult := contents[i].Get("image_url.url")
-						if contentImageURLResult.Type != gjson.String {
-							log.Error("image_url.url is not a string")
-							return false, nil, fmt.Errorf("image_url.url is not a string")
-						}
-						imageUrl := contentImageURLResult.String()
-						if imageUrl != "" {
-							arrayImageURL = append(arrayImageURL, imageUrl)
-						}
-					}
-				}
-			} else {
-				log.Error("context is not a string or array")
-				return false, nil, fmt.Errorf("context is not a string or array")
-			}
-		} else {
-			return false, nil, fmt.Errorf("role is not user")
-		}
-
-		if len(arrayImageURL) > 0 {
-			return true, arrayImageURL, nil
+	dataURLs := make([]string, 0)
+	for _, msg := range messages {
+		for _, attachment := range msg.Attachments {
+			dataURLs = append(dataURLs, attachment.DataURL())
 		}
 	}
-	return false, nil, fmt.Errorf("messages is emtpy")
+	if len(dataURLs) == 0 {
+		return false, nil, fmt.Errorf("messages have no image or file")
+	}
+	return true, dataURLs, nil
 }
 
+// ToolPrompt returns the tool results answering the last tool calls, the tool messages ending the request,
+// each rendered with its tool_call_id and function name
 func (m *Method) ToolPrompt(requestJson string) (bool, string, error) {
-	messagesResult := gjson.Get(requestJson, "messages")
-	if !messagesResult.IsArray() {
-		log.Info("messages not define")
-		return false, "", fmt.Errorf("messages not define")
+	messages, err := flattenMessages(requestJson)
+	if err != nil {
+		log.Info(err)
+		return false, "", err
 	}
-	messages := messagesResult.Array()
-	if len(messages) > 0 {
-		msg := messages[len(messages)-1]
-		roleResult := gjson.Get(msg.Raw, "role")
-		if roleResult.Type != gjson.String {
-			log.Info("role is not a string")
-			return false, "", fmt.Errorf("role is not a string")
-		}
-		role := roleResult.String()
-		if role == "tool" {
-			contentResult := gjson.Get(msg.Raw, "content")
-			if contentResult.Type == gjson.String {
-				return true, contentResult.String(), nil
-			} else {
-				log.Info("context is not a string")
-				return false, "", fmt.Errorf("context is not a string")
-			}
-		}
+	results := make([]string, 0)
+	for i := len(messages) - 1; i >= 0 && messages[i].Role == "tool"; i-- {
+		results = append([]string{messages[i].Text}, results...)
 	}
-	return false, "", nil
+	if len(results) == 0 {
+		return false, "", nil
+	}
+	return true, strings.Join(results, "\n\n"), nil
 }
 
 func (m *Method) MaxTokens(requestJson string) (bool, string, error) {
@@ -400,7 +256,12 @@ func (m *Method) ReasoningEffort(requestJson string) (bool, string) {
 	return false, ""
 }
 
+// Tools returns the function declarations of the request. It returns false when function calling is
+// emulated, the declarations are then part of the prompt built by BuildPrompt.
 func (m *Method) Tools(requestJson string) (bool, string, error) {
+	if toolcall.Enabled(requestJson) {
+		return false, "", nil
+	}
 	toolsResult := gjson.Get(requestJson, "tools")
 	if toolsResult.Type == gjson.Null {
 		return false, "", nil
@@ -0,0 +1,110 @@
+package method
+
+import (
+	"fmt"
+	"github.com/luispater/anyAIProxyAPI/internal/toolcall"
+	"github.com/tidwall/gjson"
+	"strings"
+)
+
+// roleLabels are the speaker labels of the roles in a rendered transcript
+var roleLabels = map[string]string{
+	"user":      "user",
+	"assistant": "model",
+	"tool":      "tool",
+}
+
+// transcriptMessage is a message of the request flattened into text. Its images and files are uploaded
+// separately, the text references them by filename where they appeared.
+type transcriptMessage struct {
+	Role        string
+	Text        string
+	Attachments []Attachment
+}
+
+// flattenMessages renders every message of a request, in order. Developer messages are system messages,
+// the tool calls of the assistant messages are rendered in the convention of the tool emulation and the
+// tool results carry their tool_call_id and the name of the function called. The attachments of all the
+// messages are numbered and named uniquely across the request, so UploadFiles keeps their names.
+func flattenMessages(requestJson string) ([]transcriptMessage, error) {
+	messagesResult := gjson.Get(requestJson, "messages")
+	if !messagesResult.IsArray() {
+		return nil, fmt.Errorf("messages not define")
+	}
+
+	toolNames := make(map[string]string)
+	usedNames := make(map[string]bool)
+	attachmentCount := 0
+	transcript := make([]transcriptMessage, 0)
+	for _, msg := range messagesResult.Array() {
+		roleResult := msg.Get("role")
+		if roleResult.Type != gjson.String {
+			return nil, fmt.Errorf("role is not a string")
+		}
+		message := transcriptMessage{Role: roleResult.String()}
+		if message.Role == "developer" {
+			message.Role = "system"
+		}
+
+		texts := make([]string, 0)
+		contentResult := msg.Get("content")
+		toolCallsResult := msg.Get("tool_calls")
+		if contentResult.Type == gjson.String {
+			texts = append(texts, contentResult.String())
+		} else if contentResult.IsObject() {
+			texts = append(texts, contentResult.Get("text").String())
+		} else if contentResult.IsArray() {
+			for _, part := range contentResult.Array() {
+				if part.Get("type").String() == "text" {
+					textResult := part.Get("text")
+					if textResult.Type != gjson.String {
+						return nil, fmt.Errorf("text is not a string")
+					}
+					texts = append(texts, textResult.String())
+					continue
+				}
+
+				attachment, err := PartAttachment(part, attachmentCount)
+				if err != nil {
+					return nil, err
+				}
+				if attachment == nil {
+					if imageURL := part.Get("image_url.url").String(); imageURL != "" {
+						texts = append(texts, fmt.Sprintf("[image: %s]", imageURL))
+					}
+					continue
+				}
+				if usedNames[attachment.Filename] {
+					attachment.Filename = fmt.Sprintf("%d_%s", attachmentCount, attachment.Filename)
+				}
+				usedNames[attachment.Filename] = true
+				attachmentCount++
+				message.Attachments = append(message.Attachments, *attachment)
+				texts = append(texts, fmt.Sprintf("[attachment: %s]", attachment.Filename))
+			}
+		} else if !(contentResult.Type == gjson.Null && toolCallsResult.IsArray()) {
+			return nil, fmt.Errorf("context is not a string or array")
+		}
+		message.Text = strings.Join(texts, "\n")
+
+		if message.Role == "assistant" {
+			for _, toolCall := range toolCallsResult.Array() {
+				name := toolCall.Get("function.name").String()
+				toolNames[toolCall.Get("id").String()] = name
+				if message.Text != "" && !strings.HasSuffix(message.Text, "\n") {
+					message.Text = message.Text + "\n"
+				}
+				message.Text = message.Text + toolcall.FormatCall(name, toolCall.Get("function.arguments").String())
+			}
+		} else if message.Role == "tool" {
+			toolCallId := msg.Get("tool_call_id").String()
+			name, ok := toolNames[toolCallId]
+			if !ok {
+				name = msg.Get("name").String()
+			}
+			message.Text = toolcall.FormatResult(name, toolCallId, message.Text)
+		}
+		transcript = append(transcript, message)
+	}
+	return transcript, nil
+}
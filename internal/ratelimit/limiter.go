@@ -0,0 +1,134 @@
+package ratelimit
+
+import (
+	"github.com/luispater/anyAIProxyAPI/internal/config"
+	"math"
+	"sync"
+	"time"
+)
+
+// Request is a rate limit a request has to pass, e.g. the limit of its API key or of its instance
+type Request struct {
+	Name  string
+	Limit config.AppConfigRateLimit
+}
+
+// Result describes the outcome of an acquisition, it fills the x-ratelimit-* headers
+type Result struct {
+	Allowed bool
+	// Name is the rate limit that rejected the request, or the one with the fewest requests remaining when allowed
+	Name              string
+	Reason            string
+	RequestsLimit     int
+	RequestsRemaining int
+	// Reset is the time until the request tokens are refilled
+	Reset time.Duration
+	// RetryAfter is the time to wait before retrying a rejected request
+	RetryAfter time.Duration
+}
+
+// Limiter enforces token bucket request rates and concurrency limits
+type Limiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens   float64
+	last     time.Time
+	inFlight int
+}
+
+// NewLimiter creates a rate limiter
+func NewLimiter() *Limiter {
+	return &Limiter{
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Acquire takes a request token and a concurrency slot from every rate limit, or from none of them
+// when one rejects the request. The returned release function frees the concurrency slots, it must
+// be called when the request is finished.
+func (l *Limiter) Acquire(requests ...Request) (func(), Result) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for _, request := range requests {
+		b := l.bucket(request, now)
+		if request.Limit.Concurrency > 0 && b.inFlight >= request.Limit.Concurrency {
+			result := l.describe(request, b, false)
+			result.Reason = "concurrent requests"
+			result.RetryAfter = time.Second
+			return func() {}, result
+		}
+		if request.Limit.RequestsPerMinute > 0 && b.tokens < 1 {
+			result := l.describe(request, b, false)
+			result.Reason = "requests per minute"
+			result.RetryAfter = time.Duration((1 - b.tokens) * float64(time.Minute) / float64(request.Limit.RequestsPerMinute))
+			return func() {}, result
+		}
+	}
+
+	for _, request := range requests {
+		b := l.buckets[request.Name]
+		if request.Limit.RequestsPerMinute > 0 {
+			b.tokens--
+		}
+		b.inFlight++
+	}
+	// The most restrictive rate limit is reported, the one with the fewest requests remaining
+	result := Result{Allowed: true}
+	for i, request := range requests {
+		described := l.describe(request, l.buckets[request.Name], true)
+		if i == 0 || (described.RequestsLimit > 0 && (result.RequestsLimit == 0 || described.RequestsRemaining < result.RequestsRemaining)) {
+			result = described
+		}
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			for _, request := range requests {
+				if b, ok := l.buckets[request.Name]; ok && b.inFlight > 0 {
+					b.inFlight--
+				}
+			}
+		})
+	}, result
+}
+
+// bucket returns the refilled bucket of a rate limit
+func (l *Limiter) bucket(request Request, now time.Time) *bucket {
+	b, ok := l.buckets[request.Name]
+	if !ok {
+		b = &bucket{
+			tokens: float64(request.Limit.RequestsPerMinute),
+			last:   now,
+		}
+		l.buckets[request.Name] = b
+	}
+
+	if request.Limit.RequestsPerMinute > 0 {
+		capacity := float64(request.Limit.RequestsPerMinute)
+		b.tokens = math.Min(capacity, b.tokens+now.Sub(b.last).Minutes()*capacity)
+	}
+	b.last = now
+	return b
+}
+
+func (l *Limiter) describe(request Request, b *bucket, allowed bool) Result {
+	result := Result{
+		Allowed:           allowed,
+		Name:              request.Name,
+		RequestsLimit:     request.Limit.RequestsPerMinute,
+		RequestsRemaining: int(math.Max(0, math.Floor(b.tokens))),
+	}
+	if request.Limit.RequestsPerMinute > 0 {
+		capacity := float64(request.Limit.RequestsPerMinute)
+		result.Reset = time.Duration((capacity - b.tokens) * float64(time.Minute) / capacity)
+	}
+	return result
+}
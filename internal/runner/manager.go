@@ -11,8 +11,10 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -31,8 +33,13 @@ type RunnerManager struct {
 	debugConfigs    map[string]string
 	appConfigRunner config.AppConfigRunner
 	abort           bool
+	promptMu        sync.Mutex
+	prompt          string
 }
 
+// promptMethods are the methods building the text sent to the website
+var promptMethods = map[string]bool{"BuildPrompt": true, "UserPrompt": true}
+
 func NewRunnerManager(name string, appConfigRunner config.AppConfigRunner, page *chrome.Page, debug bool) (*RunnerManager, error) {
 	runner := &RunnerManager{
 		name:            name,
@@ -52,6 +59,9 @@ func NewRunnerManager(name string, appConfigRunner config.AppConfigRunner, page
 }
 
 func (rm *RunnerManager) Abort() {
+	if rm == nil {
+		return
+	}
 	rm.abort = true
 }
 
@@ -70,29 +80,24 @@ func (rm *RunnerManager) NeedReportToken(name string) bool {
 	return cfg.NeedReportToken
 }
 
-func (rm *RunnerManager) GetTokenReport() (uint64, uint64, uint64) {
+// GetTokenReport waits up to timeout for the token numbers reported by the website.
+// The last return value is false when the report is not available in time.
+func (rm *RunnerManager) GetTokenReport(timeout time.Duration) (uint64, uint64, uint64, bool) {
+	deadline := time.Now().Add(timeout)
 	for {
-		var promptTokens, completionTokens, totalTokens uint64
-
-		if pt, hasKey := rm.results["PromptTokens"]; hasKey {
-			promptTokens = pt.Value.(uint64)
-		} else {
-			time.Sleep(100 * time.Millisecond)
-			continue
-		}
-		if ct, hasKey := rm.results["CompletionTokens"]; hasKey {
-			completionTokens = ct.Value.(uint64)
-		} else {
-			time.Sleep(100 * time.Millisecond)
-			continue
-		}
-		if tt, hasKey := rm.results["TotalTokens"]; hasKey {
-			totalTokens = tt.Value.(uint64)
-		} else {
-			time.Sleep(100 * time.Millisecond)
-			continue
-		}
-		return promptTokens, completionTokens, totalTokens
+		pt, hasPromptTokens := rm.results["PromptTokens"]
+		ct, hasCompletionTokens := rm.results["CompletionTokens"]
+		tt, hasTotalTokens := rm.results["TotalTokens"]
+		if hasPromptTokens && hasCompletionTokens && hasTotalTokens {
+			promptTokens, okPromptTokens := pt.Value.(uint64)
+			completionTokens, okCompletionTokens := ct.Value.(uint64)
+			totalTokens, okTotalTokens := tt.Value.(uint64)
+			return promptTokens, completionTokens, totalTokens, okPromptTokens && okCompletionTokens && okTotalTokens
+		}
+		if time.Now().After(deadline) {
+			return 0, 0, 0, false
+		}
+		time.Sleep(100 * time.Millisecond)
 	}
 }
 
@@ -137,6 +142,11 @@ func (rm *RunnerManager) LoadConfigurations() error {
 		// Remove extension
 		name := strings.TrimSuffix(fileName, filepath.Ext(fileName))
 
+		// models.yaml is the model catalog of the instance, not a workflow
+		if name == "models" {
+			continue
+		}
+
 		switch name {
 		case rm.appConfigRunner.Init:
 			name = "init"
@@ -144,6 +154,10 @@ func (rm *RunnerManager) LoadConfigurations() error {
 			name = "chat_completions"
 		case rm.appConfigRunner.ContextCanceled:
 			name = "context_canceled"
+		case rm.appConfigRunner.OpenConversation:
+			name = "open_conversation"
+		case rm.appConfigRunner.ImagesGenerations:
+			name = "images_generations"
 		}
 
 		log.Debugf("Loading configuration file: %s -> %s", name, filePath)
@@ -165,6 +179,22 @@ func (rm *RunnerManager) LoadConfigurations() error {
 	return nil
 }
 
+// HasWorkflow reports whether the workflow is loaded
+func (rm *RunnerManager) HasWorkflow(name string) bool {
+	_, ok := rm.configs[name]
+	return ok
+}
+
+// Workflows returns the names of the loaded workflows
+func (rm *RunnerManager) Workflows() []string {
+	names := make([]string, 0, len(rm.configs))
+	for name := range rm.configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func (rm *RunnerManager) Run(name string) error {
 	if rm.debug {
 		err := rm.LoadConfigurations()
@@ -525,6 +555,9 @@ func (rm *RunnerManager) executeMethod(obj any, methodName string, params []inte
 
 	// Execute method
 	results := methodValue.MethodByName(methodName).Call(args)
+	if promptMethods[methodName] {
+		rm.recordPrompt(results)
+	}
 
 	// Check return values
 	return rm.handleResults(results)
@@ -575,6 +608,33 @@ func (rm *RunnerManager) convertToType(input string, targetType reflect.Type) (r
 	}
 }
 
+// recordPrompt keeps the text returned by a successful prompt method
+func (rm *RunnerManager) recordPrompt(results []reflect.Value) {
+	if len(results) > 0 {
+		if err, ok := results[len(results)-1].Interface().(error); ok && err != nil {
+			return
+		}
+	}
+	for _, result := range results {
+		if result.Kind() == reflect.String && result.String() != "" {
+			rm.promptMu.Lock()
+			rm.prompt = result.String()
+			rm.promptMu.Unlock()
+			return
+		}
+	}
+}
+
+// Prompt returns the last prompt built for the website by the workflows of the runner
+func (rm *RunnerManager) Prompt() string {
+	if rm == nil {
+		return ""
+	}
+	rm.promptMu.Lock()
+	defer rm.promptMu.Unlock()
+	return rm.prompt
+}
+
 // handleResults handles the return values of method execution
 func (rm *RunnerManager) handleResults(results []reflect.Value) ([]reflect.Value, error) {
 	if len(results) > 0 {
@@ -0,0 +1,99 @@
+package toolcall
+
+import (
+	"github.com/tidwall/gjson"
+	"strings"
+	"unicode"
+)
+
+// Call is a tool call parsed from the generated text
+type Call struct {
+	Name      string
+	Arguments string
+}
+
+// Extract splits the generated text into the visible content and the tool calls it contains.
+//
+// The adapters return the whole text generated so far on every update, so Extract is called
+// with a growing text. The returned content only ever grows too: an unfinished tool call block,
+// a trailing partial open tag and trailing whitespace are held back until more text arrives.
+// When done is true, an unfinished block is released as plain text.
+func Extract(text string, done bool) (string, []Call) {
+	var content strings.Builder
+	calls := make([]Call, 0)
+
+	for {
+		start := strings.Index(text, OpenTag)
+		if start < 0 {
+			break
+		}
+
+		end := strings.Index(text[start+len(OpenTag):], CloseTag)
+		if end < 0 {
+			if done {
+				break
+			}
+			content.WriteString(text[:start])
+			return strings.TrimRightFunc(content.String(), unicode.IsSpace), calls
+		}
+		end = start + len(OpenTag) + end
+
+		call, ok := parseCall(text[start+len(OpenTag) : end])
+		if ok {
+			content.WriteString(text[:start])
+			calls = append(calls, call)
+		} else {
+			content.WriteString(text[:end+len(CloseTag)])
+		}
+		text = text[end+len(CloseTag):]
+	}
+
+	if !done {
+		text = holdPartialTag(text)
+	}
+	content.WriteString(text)
+	return strings.TrimRightFunc(content.String(), unicode.IsSpace), calls
+}
+
+// holdPartialTag cuts a trailing prefix of the open tag, which may become a tool call block
+func holdPartialTag(text string) string {
+	for i := len(OpenTag) - 1; i > 0; i-- {
+		if strings.HasSuffix(text, OpenTag[:i]) {
+			return text[:len(text)-i]
+		}
+	}
+	return text
+}
+
+func parseCall(body string) (Call, bool) {
+	body = strings.TrimSpace(body)
+	// Models tend to fence the JSON even when told not to
+	if strings.HasPrefix(body, "```") {
+		body = strings.TrimPrefix(body, "```json")
+		body = strings.TrimPrefix(body, "```")
+		body = strings.TrimSuffix(body, "```")
+		body = strings.TrimSpace(body)
+	}
+	if !gjson.Valid(body) {
+		return Call{}, false
+	}
+
+	callResult := gjson.Parse(body)
+	name := callResult.Get("name").String()
+	if name == "" {
+		return Call{}, false
+	}
+
+	argumentsResult := callResult.Get("arguments")
+	if !argumentsResult.Exists() {
+		argumentsResult = callResult.Get("parameters")
+	}
+
+	arguments := "{}"
+	if argumentsResult.Type == gjson.String {
+		arguments = argumentsResult.String()
+	} else if argumentsResult.IsObject() {
+		arguments = argumentsResult.Raw
+	}
+	return Call{Name: name, Arguments: arguments}, true
+}
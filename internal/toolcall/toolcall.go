@@ -0,0 +1,94 @@
+package toolcall
+
+import (
+	"fmt"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"strings"
+)
+
+const (
+	// EmulationKey is the request field the processor sets when the instance emulates function calling,
+	// a value sent by the client is removed first
+	EmulationKey = "tool_emulation"
+
+	OpenTag  = "<tool_call>"
+	CloseTag = "</tool_call>"
+)
+
+// Enabled reports whether function calling is emulated for the request
+func Enabled(requestJson string) bool {
+	return gjson.Get(requestJson, EmulationKey).Bool()
+}
+
+// Active reports whether tool calls of the request should be parsed from the generated text,
+// that is the emulation is enabled, tools are declared and tool_choice is not "none"
+func Active(requestJson string) bool {
+	if !Enabled(requestJson) {
+		return false
+	}
+	if len(gjson.Get(requestJson, "tools").Array()) == 0 {
+		return false
+	}
+	return gjson.Get(requestJson, "tool_choice").String() != "none"
+}
+
+// Prompt builds the instructions describing the declared tools and the tool call convention.
+// It returns an empty string when no tool call should be parsed from the reply.
+func Prompt(requestJson string) string {
+	if !Active(requestJson) {
+		return ""
+	}
+
+	functions := make([]string, 0)
+	for _, tool := range gjson.Get(requestJson, "tools").Array() {
+		functionResult := tool.Get("function")
+		if functionResult.IsObject() {
+			functions = append(functions, functionResult.Raw)
+		}
+	}
+
+	var prompt strings.Builder
+	prompt.WriteString("# Tools\n\n")
+	prompt.WriteString("You can call the following tools, each described by a JSON schema:\n")
+	prompt.WriteString("<tools>\n")
+	prompt.WriteString(strings.Join(functions, "\n"))
+	prompt.WriteString("\n</tools>\n\n")
+	prompt.WriteString("To call a tool, reply with one block per call containing a JSON object with the tool name and its arguments, exactly like this:\n")
+	prompt.WriteString(OpenTag + "\n")
+	prompt.WriteString(`{"name": "tool_name", "arguments": {"argument_name": "value"}}`)
+	prompt.WriteString("\n" + CloseTag + "\n\n")
+	prompt.WriteString("Rules:\n")
+	prompt.WriteString("- The arguments must be valid JSON matching the schema of the tool.\n")
+	prompt.WriteString("- Do not wrap tool call blocks in code fences and do not describe them.\n")
+	prompt.WriteString("- After your tool calls, stop and wait. The results will be sent back in <tool_result> blocks.\n")
+
+	toolChoiceResult := gjson.Get(requestJson, "tool_choice")
+	if toolChoiceResult.Type == gjson.String && toolChoiceResult.String() == "required" {
+		prompt.WriteString("- You must call at least one tool in this reply.\n")
+	} else if name := toolChoiceResult.Get("function.name").String(); name != "" {
+		prompt.WriteString(fmt.Sprintf("- You must call the tool `%s` in this reply.\n", name))
+	} else {
+		prompt.WriteString("- Call a tool only when it is needed, otherwise answer normally.\n")
+	}
+
+	if gjson.Get(requestJson, "parallel_tool_calls").Type == gjson.False {
+		prompt.WriteString("- Call at most one tool in this reply.\n")
+	}
+
+	return strings.TrimSpace(prompt.String())
+}
+
+// FormatCall renders an assistant tool call in the convention of the emulation prompt
+func FormatCall(name, arguments string) string {
+	call, _ := sjson.Set(`{"name":"","arguments":{}}`, "name", name)
+	if gjson.Valid(arguments) && strings.TrimSpace(arguments) != "" {
+		call, _ = sjson.SetRaw(call, "arguments", arguments)
+	}
+	return OpenTag + "\n" + call + "\n" + CloseTag
+}
+
+// FormatResult renders the result of a tool call
+func FormatResult(name, toolCallId, content string) string {
+	return fmt.Sprintf("<tool_result name=%q tool_call_id=%q>\n%s\n</tool_result>", name, toolCallId, content)
+}
@@ -0,0 +1,56 @@
+package usage
+
+import (
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+)
+
+// pieceRegexp splits text the same way the cl100k/o200k pre-tokenizers do before byte pair merges:
+// contractions, words with an optional leading space, digit groups, punctuation runs and whitespace.
+var pieceRegexp = regexp.MustCompile(`'(?:[sdmt]|ll|ve|re)| ?\p{L}+| ?\p{N}{1,3}| ?[^\s\p{L}\p{N}]+|\s+`)
+
+// EstimateTokens estimates the number of BPE tokens of a text.
+//
+// Real tokenizers merge frequent byte pairs, so a piece costs roughly one token per four latin
+// characters, while CJK characters are mostly a token each and rare symbols cost a token per byte pair.
+func EstimateTokens(text string) uint64 {
+	if text == "" {
+		return 0
+	}
+
+	var tokens uint64
+	for _, piece := range pieceRegexp.FindAllString(text, -1) {
+		tokens = tokens + estimatePieceTokens(piece)
+	}
+	return tokens
+}
+
+func estimatePieceTokens(piece string) uint64 {
+	var cjk, letters, others uint64
+	for _, r := range piece {
+		switch {
+		case unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r):
+			cjk++
+		case r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == ' '):
+			letters++
+		case unicode.IsSpace(r):
+			letters++
+		case r < utf8.RuneSelf:
+			others++
+		default:
+			// Non-ascii letters and symbols are split into their utf-8 bytes before merging
+			others = others + uint64(utf8.RuneLen(r))
+		}
+	}
+
+	tokens := cjk + ceilDiv(letters, 4) + ceilDiv(others, 2)
+	if tokens == 0 {
+		tokens = 1
+	}
+	return tokens
+}
+
+func ceilDiv(a, b uint64) uint64 {
+	return (a + b - 1) / b
+}
@@ -0,0 +1,87 @@
+package usage
+
+import (
+	"github.com/luispater/anyAIProxyAPI/internal/adapter"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	// messageOverheadTokens is the number of tokens the chat format adds around each message
+	messageOverheadTokens = 4
+	// replyPrimingTokens is the number of tokens priming the assistant reply
+	replyPrimingTokens = 3
+	// imageTokens is the estimated cost of an image input
+	imageTokens = 765
+)
+
+// Usage is the token usage of a chat completion
+type Usage struct {
+	PromptTokens     uint64
+	CompletionTokens uint64
+	TotalTokens      uint64
+	// Estimated is true when the numbers were not reported by the website
+	Estimated bool
+}
+
+// Estimate estimates the token usage of a chat completion request and its final adapter response
+func Estimate(requestJson string, response *adapter.AdapterResponse) Usage {
+	promptTokens := EstimatePromptTokens(requestJson)
+	completionTokens := EstimateCompletionTokens(response)
+	return Usage{
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		TotalTokens:      promptTokens + completionTokens,
+		Estimated:        true,
+	}
+}
+
+// EstimatePromptTokens estimates the prompt tokens of a chat completion request
+func EstimatePromptTokens(requestJson string) uint64 {
+	var tokens uint64
+	for _, msg := range gjson.Get(requestJson, "messages").Array() {
+		tokens = tokens + messageOverheadTokens
+		tokens = tokens + EstimateTokens(msg.Get("role").String())
+
+		contentResult := msg.Get("content")
+		if contentResult.Type == gjson.String {
+			tokens = tokens + EstimateTokens(contentResult.String())
+		} else if contentResult.IsArray() {
+			for _, part := range contentResult.Array() {
+				switch part.Get("type").String() {
+				case "text":
+					tokens = tokens + EstimateTokens(part.Get("text").String())
+				case "image_url":
+					tokens = tokens + imageTokens
+				}
+			}
+		}
+
+		for _, toolCall := range msg.Get("tool_calls").Array() {
+			tokens = tokens + EstimateTokens(toolCall.Get("function.name").String())
+			tokens = tokens + EstimateTokens(toolCall.Get("function.arguments").String())
+		}
+	}
+
+	if toolsResult := gjson.Get(requestJson, "tools"); toolsResult.IsArray() {
+		tokens = tokens + EstimateTokens(toolsResult.Raw)
+	}
+
+	return tokens + replyPrimingTokens
+}
+
+// EstimateCompletionTokens estimates the completion tokens of a final adapter response
+func EstimateCompletionTokens(response *adapter.AdapterResponse) uint64 {
+	if response == nil {
+		return 0
+	}
+	return EstimateTokens(response.Content) + EstimateTokens(response.ReasoningContent) + EstimateTokens(response.ToolCalls)
+}
+
+// SetUsage writes the usage object into a chat completion (chunk) json
+func SetUsage(jsonOutput string, u Usage) string {
+	jsonOutput, _ = sjson.Set(jsonOutput, "usage.prompt_tokens", u.PromptTokens)
+	jsonOutput, _ = sjson.Set(jsonOutput, "usage.completion_tokens", u.CompletionTokens)
+	jsonOutput, _ = sjson.Set(jsonOutput, "usage.total_tokens", u.TotalTokens)
+	return jsonOutput
+}
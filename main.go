@@ -3,23 +3,22 @@ package main
 import (
 	"bytes"
 	"context" // Will be needed for marshalling cookies
-	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/chromedp/cdproto/cdp"
 	"github.com/luispater/anyAIProxyAPI/internal/runner"
 	"os"
 	"os/signal"
 	"path"
-	"path/filepath"
 	"syscall"
 	"time"
 
-	// For cdp.Node
 	"github.com/chromedp/chromedp" // For chromedp actions
 	"github.com/luispater/anyAIProxyAPI/internal/api"
+	"github.com/luispater/anyAIProxyAPI/internal/audit"
 	"github.com/luispater/anyAIProxyAPI/internal/browser/chrome"
 	chromedpmanager "github.com/luispater/anyAIProxyAPI/internal/browser/chrome"
 	"github.com/luispater/anyAIProxyAPI/internal/config"
+	"github.com/luispater/anyAIProxyAPI/internal/instance"
 	// "github.com/playwright-community/playwright-go" // Playwright no longer used
 	log "github.com/sirupsen/logrus"
 )
@@ -62,12 +61,36 @@ func main() {
 	}
 
 	pages := make(map[string]*chrome.Page) // Changed from playwright.Page to context.Context
+	instances := instance.NewRegistry()
+
+	auditLogger, errNewLogger := audit.NewLogger(cfg.Audit)
+	if errNewLogger != nil {
+		log.Fatalf("could not open audit log: %v", errNewLogger)
+		return
+	}
+
+	// Create a new browser manager
+	browserManager, errNewManager := chromedpmanager.NewManager(cfg)
+	if errNewManager != nil {
+		log.Fatalf("could not create browser manager: %v", errNewManager)
+		return
+	}
+	defer func() {
+		log.Debugf("Closing browser manager...")
+		if err = browserManager.Close(); err != nil {
+			log.Debugf("Error closing browser manager: %v", err)
+		}
+		log.Debugf("Browser manager closed.")
+	}()
 
 	// Create API server configuration
 	apiConfig := &api.ServerConfig{
-		Port:  cfg.ApiPort,
-		Debug: cfg.Debug,
-		Pages: &pages,
+		Port:      cfg.ApiPort,
+		Debug:     cfg.Debug,
+		Pages:     &pages,
+		Browser:   browserManager,
+		Instances: instances,
+		Audit:     auditLogger,
 	}
 
 	// Create API server
@@ -84,20 +107,6 @@ func main() {
 
 	log.Info("Starting Any AI Proxy API application...")
 
-	// Create a new browser manager
-	browserManager, errNewManager := chromedpmanager.NewManager(cfg)
-	if errNewManager != nil {
-		log.Fatalf("could not create browser manager: %v", errNewManager)
-		return
-	}
-	defer func() {
-		log.Debugf("Closing browser manager...")
-		if err = browserManager.Close(); err != nil {
-			log.Debugf("Error closing browser manager: %v", err)
-		}
-		log.Debugf("Browser manager closed.")
-	}()
-
 	// Launch the browser and create a context
 	if err = browserManager.LaunchBrowserAndContext(); err != nil {
 		log.Fatalf("could not launch browser and context: %v", err)
@@ -164,24 +173,16 @@ func main() {
 		case <-time.After(5 * time.Second):
 			for instanceName, pageInstance := range pages { // p is pageCtxInstance
 				if mapCfg[instanceName].Auth.Check != "" {
-					hasCheckFlag := false
-
-					var nodes []*cdp.Node
-
-					timeoutCtx, cancel := context.WithTimeout(pageInstance.GetContext(), 1*time.Second)
-					err = chromedp.Run(timeoutCtx,
-						chromedp.Nodes(mapCfg[instanceName].Auth.Check, &nodes, chromedp.ByQueryAll),
-					)
-					cancel()
-					if err != nil {
-						if err.Error() != "context deadline exceeded" {
-							log.Errorf("Error checking auth selector '%s' for instance %s: %v", mapCfg[instanceName].Auth.Check, instanceName, err)
-						}
-					} else if len(nodes) == 0 {
+					hasCheckFlag, errCheckAuth := chromedpmanager.CheckAuth(pageInstance.GetContext(), mapCfg[instanceName].Auth.Check)
+					if errCheckAuth != nil && !errors.Is(errCheckAuth, context.DeadlineExceeded) {
+						log.Errorf("Error checking auth selector '%s' for instance %s: %v", mapCfg[instanceName].Auth.Check, instanceName, errCheckAuth)
+					} else if !hasCheckFlag {
+						// The selector query waits for a match, so a missing selector ends with the check timeout
 						log.Debugf("Auth.Check selector '%s' not found for instance %s. Skipping state save.", mapCfg[instanceName].Auth.Check, instanceName)
+						instances.SetAuthChecked(instanceName, false)
 					} else {
-						hasCheckFlag = true
-						log.Debugf("Auth.Check selector '%s' found %d elements for instance %s.", mapCfg[instanceName].Auth.Check, len(nodes), instanceName)
+						log.Debugf("Auth.Check selector '%s' found for instance %s.", mapCfg[instanceName].Auth.Check, instanceName)
+						instances.SetAuthChecked(instanceName, true)
 					}
 
 					if hasCheckFlag {
@@ -198,43 +199,11 @@ func main() {
 						}
 
 						if saveState {
-							cookies, errGetCookies := chromedpmanager.GetCookies(pageInstance.GetContext())
-							localStorages, errGetLocalStorages := chromedpmanager.GetLocalStorages(pageInstance.GetContext())
-							// localStorages, errGetLocalStorages := pageInstance.GetLocalStorages()
-							if errGetCookies != nil {
-								log.Debugf("Error getting cookies for instance %s: %v", instanceName, errGetCookies)
-								continue
-							}
-							if errGetLocalStorages != nil {
-								log.Debugf("Error getting local storages for instance %s: %v", instanceName, errGetLocalStorages)
-								continue
-							}
-
-							jsonData, errMarshalIndent := json.MarshalIndent(map[string]interface{}{"cookies": cookies, "local_storage": localStorages}, "", "  ")
-							if errMarshalIndent != nil {
-								log.Debugf("Error marshalling cookies to JSON for instance %s: %v", instanceName, errMarshalIndent)
-								continue
-							}
-
-							// Ensure the directory exists
-							authAbsPath, errAbs := filepath.Abs(mapCfg[instanceName].Auth.File)
-							if errAbs != nil {
-								log.Debugf("Error getting absolute path for auth file for instance %s: %v", instanceName, errAbs)
-								continue
-							}
-							authDirName := filepath.Dir(authAbsPath)
-							if _, errStat := os.Stat(authDirName); os.IsNotExist(errStat) {
-								errMkdir := os.MkdirAll(authDirName, 0755)
-								if errMkdir != nil {
-									log.Debugf("Error creating directory %s for instance %s: %v", authDirName, instanceName, errMkdir)
-									continue
-								}
-							}
-
-							errWriteFile := os.WriteFile(mapCfg[instanceName].Auth.File, jsonData, 0644)
-							if errWriteFile != nil {
-								log.Debugf("Error writing auth info to file %s for instance %s: %v", mapCfg[instanceName].Auth.File, instanceName, errWriteFile)
+							errSaveAuthInfo := chromedpmanager.SaveAuthInfo(pageInstance.GetContext(), mapCfg[instanceName].Auth.File)
+							if errSaveAuthInfo != nil {
+								log.Debugf("Error saving auth info for instance %s: %v", instanceName, errSaveAuthInfo)
 							} else {
+								instances.SetAuthSaved(instanceName)
 								log.Debugf("Successfully wrote auth info to file %s for instance %s", mapCfg[instanceName].Auth.File, instanceName)
 							}
 						}